@@ -0,0 +1,546 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mholt/archiver"
+	"github.com/spf13/cobra"
+)
+
+const (
+	backupManifestName    = "manifest.json"
+	backupManifestVersion = 1
+)
+
+var (
+	// backupPaths are archived when they exist, a restore only writes under them
+	backupPaths = []string{cephConfigPath, cephDataPath, cnUserDetailsFile, "/nano_user_details", dashboardUserDetailsFile, cnUsersDir, s3CmdFilePath}
+	// backupSymlinks are the only links a restore recreates, with their target
+	backupSymlinks = map[string]string{"/nano_user_details": cnUserDetailsFile}
+
+	restoreForce bool
+
+	// sparseZeros is a block of zeros, a restore leaves a hole for every such block of a file
+	sparseZeros = make([]byte, 64*1024)
+)
+
+// backupManifest is the first entry of a backup archive
+type backupManifest struct {
+	Version       int       `json:"version"`
+	Created       time.Time `json:"created"`
+	Hostname      string    `json:"hostname"`
+	Fsid          string    `json:"fsid"`
+	CephVersion   string    `json:"ceph_version"`
+	CnCoreVersion string    `json:"cn_core_version"`
+	Paths         []string  `json:"paths"`
+	// symlinks are kept here since archiver writes the link name instead of its target
+	Symlinks map[string]string `json:"symlinks,omitempty"`
+	// OSDs backed by a device only have their tmpfs directory archived, the data stays on the device
+	OsdDevices []string `json:"osd_devices,omitempty"`
+}
+
+// backupEntry is a file to archive, path is relative to the host root
+type backupEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// cliBackup is the Cobra CLI call
+func cliBackup() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "backup FILE",
+		Short:   "Archive the cluster configuration, keyrings, monitor store and OSD data",
+		Args:    cobra.ExactArgs(1),
+		Run:     backupCluster,
+		Example: "cn-core backup /backup/cn-core.tar.gz\n",
+	}
+	cmd.Flags().SortFlags = false
+	addConfigFlags(cmd.Flags())
+
+	return cmd
+}
+
+// cliRestore is the Cobra CLI call
+func cliRestore() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "restore FILE",
+		Short:   "Restore a cluster archived by backup, before running init",
+		Args:    cobra.ExactArgs(1),
+		Run:     restoreCluster,
+		Example: "cn-core restore /backup/cn-core.tar.gz && cn-core init\n",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().BoolVar(&restoreForce, "force", false, "Overwrite an existing cluster.")
+
+	return cmd
+}
+
+// backupCluster freezes the daemons while the archive is written so the stores are consistent
+func backupCluster(cmd *cobra.Command, args []string) {
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+
+	thaw := freezeDaemons()
+	err = createBackup(args[0])
+	thaw()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// restoreCluster lays the archive down so init finds the same cluster identity
+func restoreCluster(cmd *cobra.Command, args []string) {
+	if err := restoreBackup(args[0], restoreForce); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// freezeDaemons stops the daemons with SIGSTOP in shutdown order, the archive is then as consistent as after a power loss
+// The returned function resumes them in the reverse order
+func freezeDaemons() func() {
+	var frozen [][]int
+	for _, group := range shutdownOrder {
+		pids := group.pids()
+		if len(pids) == 0 {
+			continue
+		}
+		log.Printf("backup: freezing %s (pid %s)", group.name, joinPids(pids))
+		for _, pid := range pids {
+			syscall.Kill(pid, syscall.SIGSTOP)
+		}
+		frozen = append(frozen, pids)
+	}
+
+	return func() {
+		for i := len(frozen) - 1; i >= 0; i-- {
+			for _, pid := range frozen[i] {
+				syscall.Kill(pid, syscall.SIGCONT)
+			}
+		}
+		if len(frozen) > 0 {
+			log.Println("backup: daemons resumed")
+		}
+	}
+}
+
+// createBackup writes the archive, the manifest comes first so restore can check it before writing anything
+func createBackup(path string) error {
+	manifest, entries, err := collectBackup()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	tgz := archiver.NewTarGz()
+	if err := tgz.Create(out); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tgz.Write(archiver.File{
+		FileInfo:   archiver.FileInfo{FileInfo: manifestInfo{size: int64(len(content)), modTime: manifest.Created}, CustomName: backupManifestName},
+		ReadCloser: archiver.ReadFakeCloser{Reader: bytes.NewReader(content)},
+	})
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	for _, entry := range entries {
+		if err := writeBackupEntry(tgz, entry); err != nil {
+			return fmt.Errorf("backup: %v", err)
+		}
+	}
+
+	if err := tgz.Close(); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	log.Printf("backup: cluster %s archived to %s (%d files)", manifest.Fsid, path, len(entries))
+
+	return nil
+}
+
+func writeBackupEntry(tgz *archiver.TarGz, entry backupEntry) error {
+	name := strings.TrimPrefix(entry.path, "/")
+	if entry.info.IsDir() {
+		return tgz.Write(archiver.File{FileInfo: archiver.FileInfo{FileInfo: entry.info, CustomName: name}})
+	}
+
+	f, err := os.Open(hostPath(entry.path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tgz.Write(archiver.File{FileInfo: archiver.FileInfo{FileInfo: entry.info, CustomName: name}, ReadCloser: f})
+}
+
+// collectBackup builds the manifest and the list of files to archive
+func collectBackup() (backupManifest, []backupEntry, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return backupManifest{}, nil, err
+	}
+	manifest := backupManifest{
+		Version:       backupManifestVersion,
+		Created:       time.Now().UTC().Truncate(time.Second),
+		Hostname:      hostname,
+		CnCoreVersion: cnCoreVersion,
+		Symlinks:      map[string]string{},
+		OsdDevices:    conf.osdDevices(),
+	}
+
+	manifest.Fsid, err = readFsid()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("backup: no cluster to back up: %v", err)
+	}
+
+	manifest.CephVersion, err = cephClient().Version()
+	if err != nil {
+		return manifest, nil, fmt.Errorf("backup: failed to get the ceph version: %v", err)
+	}
+
+	var entries []backupEntry
+	for _, root := range backupPaths {
+		if _, err := os.Lstat(hostPath(root)); os.IsNotExist(err) {
+			continue
+		}
+		manifest.Paths = append(manifest.Paths, root)
+
+		err := filepath.Walk(hostPath(root), func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(hostPath("/"), name)
+			if err != nil {
+				return err
+			}
+			path := "/" + rel
+
+			switch {
+			case info.Mode()&os.ModeSymlink != 0 && strings.HasPrefix(path, osdBasePath+"/"):
+				// ceph-volume activate links the device of an OSD again, under the name it has then
+				log.Printf("backup: skipping %s, ceph-volume recreates it", path)
+			case info.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(name)
+				if err != nil {
+					return err
+				}
+				manifest.Symlinks[path] = target
+			case info.IsDir(), info.Mode().IsRegular():
+				entries = append(entries, backupEntry{path: path, info: info})
+			default:
+				// sockets and devices are recreated by the daemons
+				log.Printf("backup: skipping %s, not a regular file", path)
+			}
+			return nil
+		})
+		if err != nil {
+			return manifest, nil, fmt.Errorf("backup: %v", err)
+		}
+	}
+
+	return manifest, entries, nil
+}
+
+// readFsid returns the fsid from ceph.conf
+func readFsid() (string, error) {
+	c, err := readCephConf()
+	if err != nil {
+		return "", err
+	}
+	if fsid, ok := c.get("global", "fsid"); ok && fsid != "" {
+		return fsid, nil
+	}
+
+	return "", fmt.Errorf("no fsid in %s", cephConfFilePath)
+}
+
+// restoreBackup extracts an archive made by createBackup
+func restoreBackup(path string, force bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+	defer f.Close()
+
+	tgz := archiver.NewTarGz()
+	if err := tgz.Open(f, 0); err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+	defer tgz.Close()
+
+	manifest, err := readBackupManifest(tgz)
+	if err != nil {
+		return fmt.Errorf("restore: %s is not a cn-core backup: %v", path, err)
+	}
+	if err := checkRestore(manifest, force); err != nil {
+		return err
+	}
+	log.Printf("restore: restoring cluster %s (%s) backed up on %s", manifest.Fsid, manifest.CephVersion, manifest.Created.Format(time.RFC3339))
+
+	// the backed up paths are replaced, files left by the overwritten cluster would corrupt the restored stores
+	for _, root := range manifest.Paths {
+		if err := removeAll(root); err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+	}
+
+	count := 0
+	for {
+		file, err := tgz.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+		if err := restoreEntry(manifest, file); err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+		count++
+	}
+
+	for link, target := range manifest.Symlinks {
+		if err := removeAll(link); err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+		if err := mkdirAll(filepath.Dir(link), 0755); err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+		if err := symlink(target, link); err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+	}
+
+	log.Printf("restore: %d files restored, run init to start the cluster", count)
+	for _, device := range manifest.OsdDevices {
+		log.Printf("restore: the data of the OSD on %s was not archived, the device must be attached again", device)
+	}
+
+	return nil
+}
+
+func readBackupManifest(tgz *archiver.TarGz) (backupManifest, error) {
+	var manifest backupManifest
+
+	file, err := tgz.Read()
+	if err != nil {
+		return manifest, err
+	}
+	if file.Name() != backupManifestName {
+		return manifest, fmt.Errorf("the first entry is %s instead of %s", file.Name(), backupManifestName)
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+// checkRestore refuses archives this cn-core cannot restore and does not overwrite a cluster unless forced
+func checkRestore(manifest backupManifest, force bool) error {
+	if manifest.Version != backupManifestVersion {
+		return fmt.Errorf("restore: unsupported backup version %d", manifest.Version)
+	}
+
+	// the manifest comes from the archive, it cannot widen what a restore writes
+	for _, root := range manifest.Paths {
+		if !isBackupPath(root) {
+			return fmt.Errorf("restore: %s is not a path cn-core backs up", root)
+		}
+	}
+	for link, target := range manifest.Symlinks {
+		if backupSymlinks[link] != target {
+			return fmt.Errorf("restore: unexpected symlink %s -> %s", link, target)
+		}
+	}
+
+	// the monitor is named after the host, it would not find its store under another name
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	if manifest.Hostname != hostname {
+		return fmt.Errorf("restore: the backup was taken on host %s, start the container with --hostname %s", manifest.Hostname, manifest.Hostname)
+	}
+
+	if _, err := stat(cephConfFilePath); err == nil && !force {
+		fsid, _ := readFsid()
+		return fmt.Errorf("restore: a cluster already exists (fsid %s), use --force to overwrite it", fsid)
+	}
+
+	return nil
+}
+
+// restoreEntry writes a single file of the archive, keeping its mode and owner
+func restoreEntry(manifest backupManifest, file archiver.File) error {
+	hdr, ok := file.Header.(*tar.Header)
+	if !ok {
+		return fmt.Errorf("unexpected header %T", file.Header)
+	}
+
+	// only the backed up paths listed in the manifest can be written
+	path := filepath.Clean("/" + hdr.Name)
+	root := ""
+	for _, p := range manifest.Paths {
+		if isBackupPath(p) && (path == p || strings.HasPrefix(path, p+"/")) {
+			root = p
+		}
+	}
+	if root == "" {
+		return fmt.Errorf("%s is outside of the backed up paths", hdr.Name)
+	}
+	if err := checkNoSymlink(root, path); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := mkdirAll(path, file.Mode().Perm()); err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		if err := mkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(hostPath(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if err := copySparse(out, file); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s: unsupported entry type %c", hdr.Name, hdr.Typeflag)
+	}
+
+	if err := chown(path, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	return os.Chtimes(hostPath(path), hdr.ModTime, hdr.ModTime)
+}
+
+// copySparse writes a file leaving holes for the blocks of zeros, e.g: the unused part of a bluestore block file
+func copySparse(out *os.File, r io.Reader) error {
+	buf := make([]byte, len(sparseZeros))
+	var size int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			var err error
+			if bytes.Equal(buf[:n], sparseZeros[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	// a file ending with a hole only gets its size from a truncate
+	return out.Truncate(size)
+}
+
+// isBackupPath tells if a path is one of backupPaths
+func isBackupPath(path string) bool {
+	for _, p := range backupPaths {
+		if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNoSymlink refuses to write path when it or one of its parents up to root is a symlink, it could point anywhere
+func checkNoSymlink(root, path string) error {
+	for p := path; ; p = filepath.Dir(p) {
+		if info, err := os.Lstat(hostPath(p)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink, refusing to write %s through it", p, path)
+		}
+		if p == root || p == "/" {
+			return nil
+		}
+	}
+}
+
+// manifestInfo describes the manifest, which is not a file on disk
+type manifestInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (m manifestInfo) Name() string       { return backupManifestName }
+func (m manifestInfo) Size() int64        { return m.size }
+func (m manifestInfo) Mode() os.FileMode  { return 0644 }
+func (m manifestInfo) ModTime() time.Time { return m.modTime }
+func (m manifestInfo) IsDir() bool        { return false }
+func (m manifestInfo) Sys() interface{}   { return nil }
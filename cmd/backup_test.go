@@ -0,0 +1,274 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mholt/archiver"
+	"github.com/stretchr/testify/assert"
+)
+
+const testFsid = "8f3e7b4a-5a4a-4c6b-9a0a-2f1c6c2d1b5e"
+
+// fakeCluster lays down the files of a bootstrapped cluster under a temporary root
+func fakeCluster(t *testing.T) (string, func()) {
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+
+	root, cleanup := testRoot(t)
+	files := map[string]string{
+		"etc/ceph/ceph.conf":                                         "\n[global]\nfsid = " + testFsid + "\n",
+		"etc/ceph/ceph.client.admin.keyring":                         "[client.admin]\n\tkey = AQ==\n",
+		"var/lib/ceph/mon/ceph-" + hostname + "/keyring":             "[mon.]\n\tkey = AQ==\n",
+		"var/lib/ceph/mon/ceph-" + hostname + "/store.db/000005.sst": "sst",
+		"var/lib/ceph/osd/ceph-0/block":                              "block",
+		"opt/ceph-container/tmp/cn_user_details":                     `{"keys": [{"user": "cn"}]}`,
+	}
+	for path, content := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, path), []byte(content), 0600))
+	}
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "var/lib/ceph/tmp"), 0700))
+	assert.Nil(t, os.Symlink("/opt/ceph-container/tmp/cn_user_details", filepath.Join(root, "nano_user_details")))
+
+	return root, cleanup
+}
+
+func testBackup(t *testing.T) (string, func()) {
+	root, cleanup := fakeCluster(t)
+	runner = &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph", "--version"}, Output: "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)\n"},
+	}}
+	conf = defaultConfig()
+
+	archive := filepath.Join(root, "backup.tar.gz")
+	assert.Nil(t, createBackup(archive))
+
+	return archive, cleanup
+}
+
+func TestBackupRestore(t *testing.T) {
+	archive, cleanup := testBackup(t)
+	defer cleanup()
+
+	// restore in a fresh container
+	root, cleanupRestore := testRoot(t)
+	defer cleanupRestore()
+
+	assert.Nil(t, restoreBackup(archive, false))
+
+	hostname, _ := os.Hostname()
+	content, err := ioutil.ReadFile(filepath.Join(root, "var/lib/ceph/mon/ceph-"+hostname+"/store.db/000005.sst"))
+	assert.Nil(t, err)
+	assert.Equal(t, "sst", string(content))
+
+	info, err := os.Stat(filepath.Join(root, "etc/ceph/ceph.client.admin.keyring"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(root, "var/lib/ceph/tmp"))
+	assert.Nil(t, err)
+	assert.True(t, info.IsDir())
+
+	target, err := os.Readlink(filepath.Join(root, "nano_user_details"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/opt/ceph-container/tmp/cn_user_details", target)
+
+	fsid, err := readFsid()
+	assert.Nil(t, err)
+	assert.Equal(t, testFsid, fsid)
+
+	// the cluster exists now
+	err = restoreBackup(archive, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "a cluster already exists (fsid "+testFsid+")")
+
+	// the files of the overwritten cluster do not survive next to the restored ones
+	leftover := filepath.Join(root, "var/lib/ceph/mon/ceph-"+hostname+"/store.db/000009.sst")
+	assert.Nil(t, ioutil.WriteFile(leftover, []byte("stale"), 0644))
+	assert.Nil(t, restoreBackup(archive, true))
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+	content, err = ioutil.ReadFile(filepath.Join(root, "var/lib/ceph/mon/ceph-"+hostname+"/store.db/000005.sst"))
+	assert.Nil(t, err)
+	assert.Equal(t, "sst", string(content))
+}
+
+func TestBackupRestoreDeviceOsd(t *testing.T) {
+	root, cleanup := fakeCluster(t)
+	defer cleanup()
+	runner = &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph", "--version"}, Output: "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)\n"},
+	}}
+	conf.OsdDevice = "/dev/sdb"
+
+	// the tmpfs directory ceph-volume activate fills for an OSD on a device
+	osdDir := filepath.Join(root, "var/lib/ceph/osd/ceph-1")
+	assert.Nil(t, os.MkdirAll(osdDir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(osdDir, "type"), []byte("bluestore\n"), 0600))
+	assert.Nil(t, os.Symlink("/dev/ceph-0b5d4a56/osd-block-0b5d4a56", filepath.Join(osdDir, "block")))
+
+	archive := filepath.Join(root, "backup.tar.gz")
+	assert.Nil(t, createBackup(archive))
+
+	restored, cleanupRestore := testRoot(t)
+	defer cleanupRestore()
+	assert.Nil(t, restoreBackup(archive, false))
+
+	assert.Equal(t, "bluestore\n", readTestFile(t, restored, "/var/lib/ceph/osd/ceph-1/type"))
+	_, err := os.Lstat(filepath.Join(restored, "var/lib/ceph/osd/ceph-1/block"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCopySparse(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	// a mostly empty bluestore block file
+	content := make([]byte, 4<<20)
+	copy(content[1<<20:], "bluestore block device")
+	f, err := os.Create(filepath.Join(root, "block"))
+	assert.Nil(t, err)
+	defer f.Close()
+	assert.Nil(t, copySparse(f, bytes.NewReader(content)))
+
+	assert.Equal(t, string(content), readTestFile(t, root, "/block"))
+	info, err := f.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	assert.True(t, info.Sys().(*syscall.Stat_t).Blocks*512 < int64(len(content)), "the zeros are holes")
+}
+
+func TestBackupManifest(t *testing.T) {
+	archive, cleanup := testBackup(t)
+	defer cleanup()
+
+	f, err := os.Open(archive)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	tgz := newTestTarGz(t, f)
+	manifest, err := readBackupManifest(tgz)
+	assert.Nil(t, err)
+
+	hostname, _ := os.Hostname()
+	assert.Equal(t, backupManifestVersion, manifest.Version)
+	assert.Equal(t, hostname, manifest.Hostname)
+	assert.Equal(t, testFsid, manifest.Fsid)
+	assert.Equal(t, "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)", manifest.CephVersion)
+	assert.Equal(t, []string{"/etc/ceph", "/var/lib/ceph", "/opt/ceph-container/tmp/cn_user_details", "/nano_user_details"}, manifest.Paths)
+	assert.Equal(t, map[string]string{"/nano_user_details": "/opt/ceph-container/tmp/cn_user_details"}, manifest.Symlinks)
+}
+
+func TestRestoreChecks(t *testing.T) {
+	hostname, _ := os.Hostname()
+	manifest := backupManifest{Version: backupManifestVersion, Hostname: hostname}
+
+	_, cleanup := testRoot(t)
+	defer cleanup()
+	assert.Nil(t, checkRestore(manifest, false))
+
+	manifest.Hostname = "elsewhere"
+	err := checkRestore(manifest, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "--hostname elsewhere")
+
+	manifest = backupManifest{Version: 2, Hostname: hostname}
+	assert.NotNil(t, checkRestore(manifest, false))
+}
+
+// testArchive returns a backup archive with a manifest and empty regular files
+func testArchive(t *testing.T, manifest string, names ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte(manifest))
+	assert.Nil(t, err)
+	for _, name := range names {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}))
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	return &buf
+}
+
+func TestRestoreOutsidePaths(t *testing.T) {
+	tgz := newTestTarGz(t, testArchive(t, `{"version": 1, "paths": ["/etc/ceph"]}`, "etc/ceph/../../root/.ssh/authorized_keys"))
+	m, err := readBackupManifest(tgz)
+	assert.Nil(t, err)
+	file, err := tgz.Read()
+	assert.Nil(t, err)
+
+	err = restoreEntry(m, file)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "outside of the backed up paths")
+}
+
+func TestRestoreMaliciousManifest(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+	hostname, _ := os.Hostname()
+	archive := filepath.Join(root, "backup.tar.gz")
+
+	for manifest, want := range map[string]string{
+		`{"paths": ["/"]}`:    "restore: / is not a path cn-core backs up",
+		`{"paths": ["/etc"]}`: "restore: /etc is not a path cn-core backs up",
+		`{"symlinks": {"/etc/cron.d/evil": "/tmp/evil"}}`:          "restore: unexpected symlink /etc/cron.d/evil -> /tmp/evil",
+		`{"symlinks": {"/nano_user_details": "/etc/shadow"}}`:      "restore: unexpected symlink /nano_user_details -> /etc/shadow",
+		`{"paths": ["/etc/ceph"], "symlinks": {"/etc/ceph": "/"}}`: "restore: unexpected symlink /etc/ceph -> /",
+	} {
+		manifest = `{"version": 1, "hostname": "` + hostname + `", ` + manifest[1:]
+		assert.Nil(t, ioutil.WriteFile(archive, testArchive(t, manifest, "etc/passwd").Bytes(), 0644))
+		assert.EqualError(t, restoreBackup(archive, false), want)
+	}
+	_, err := os.Stat(filepath.Join(root, "etc/passwd"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a file is not written through a symlink under the backed up paths
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "etc/ceph"), 0755))
+	assert.Nil(t, os.Symlink(root, filepath.Join(root, "etc/ceph/keys")))
+	tgz := newTestTarGz(t, testArchive(t, `{"version": 1, "paths": ["/etc/ceph"]}`, "etc/ceph/keys/evil"))
+	m, err := readBackupManifest(tgz)
+	assert.Nil(t, err)
+	file, err := tgz.Read()
+	assert.Nil(t, err)
+	assert.EqualError(t, restoreEntry(m, file), "/etc/ceph/keys is a symlink, refusing to write /etc/ceph/keys/evil through it")
+	_, err = os.Stat(filepath.Join(root, "evil"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func newTestTarGz(t *testing.T, r io.Reader) *archiver.TarGz {
+	tgz := archiver.NewTarGz()
+	assert.Nil(t, tgz.Open(r, 0))
+	return tgz
+}
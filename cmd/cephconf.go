@@ -0,0 +1,249 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cephConfDropInDir = cephConfigPath + "/ceph.conf.d"
+	cephConfEnvPrefix = "CEPH_CONF_"
+	cephConfHeader    = "# Generated by cn-core on every start, put your own settings in " + cephConfDropInDir + "\n"
+)
+
+// cephConfSection is a section of ceph.conf, its keys keep the order they were set in
+type cephConfSection struct {
+	name   string
+	keys   []string
+	values map[string]string
+}
+
+// cephConfFile is a ceph.conf made of sections
+type cephConfFile struct {
+	sections []*cephConfSection
+}
+
+// normalizeCephConfKey matches the spellings Ceph accepts for the same option, e.g: "mon host" and "mon_host"
+func normalizeCephConfKey(key string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(key)))
+}
+
+func (c *cephConfFile) section(name string) *cephConfSection {
+	for _, s := range c.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &cephConfSection{name: name, values: map[string]string{}}
+	c.sections = append(c.sections, s)
+	return s
+}
+
+// set adds a key to a section, or replaces its value if the section already has it under any spelling
+func (c *cephConfFile) set(section, key, value string) {
+	s := c.section(section)
+	for _, k := range s.keys {
+		if normalizeCephConfKey(k) == normalizeCephConfKey(key) {
+			s.values[k] = value
+			return
+		}
+	}
+	s.keys = append(s.keys, key)
+	s.values[key] = value
+}
+
+func (c *cephConfFile) get(section, key string) (string, bool) {
+	for _, s := range c.sections {
+		if s.name != section {
+			continue
+		}
+		for _, k := range s.keys {
+			if normalizeCephConfKey(k) == normalizeCephConfKey(key) {
+				return s.values[k], true
+			}
+		}
+	}
+	return "", false
+}
+
+// merge sets every key of other, other wins
+func (c *cephConfFile) merge(other *cephConfFile) {
+	for _, s := range other.sections {
+		for _, k := range s.keys {
+			c.set(s.name, k, s.values[k])
+		}
+	}
+}
+
+func (c *cephConfFile) String() string {
+	var out bytes.Buffer
+	out.WriteString(cephConfHeader)
+	for _, s := range c.sections {
+		fmt.Fprintf(&out, "\n[%s]\n", s.name)
+		for _, k := range s.keys {
+			fmt.Fprintf(&out, "%s = %s\n", k, s.values[k])
+		}
+	}
+	return out.String()
+}
+
+// parseCephConf reads a ceph.conf, name is only used in the errors
+func parseCephConf(r io.Reader, name string) (*cephConfFile, error) {
+	c := &cephConfFile{}
+	var section string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: empty section name", name, n)
+			}
+			c.section(section)
+		default:
+			fields := strings.SplitN(line, "=", 2)
+			if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" {
+				return nil, fmt.Errorf("%s:%d: expected 'key = value', got %q", name, n, line)
+			}
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: %s is not in a section", name, n, strings.TrimSpace(fields[0]))
+			}
+			c.set(section, strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return c, nil
+}
+
+// readCephConf parses the ceph.conf written by a previous start
+func readCephConf() (*cephConfFile, error) {
+	f, err := os.Open(hostPath(cephConfFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseCephConf(f, cephConfFilePath)
+}
+
+// generateCephConf returns the ceph.conf holding the settings cn-core starts the daemons with
+// so a daemon started by hand in the container behaves the same
+func generateCephConf(hostname, fsid string) *cephConfFile {
+	c := &cephConfFile{}
+	c.set("global", "fsid", fsid)
+	c.set("global", "mon initial members", hostname)
+	c.set("global", "mon host", conf.monHost())
+	c.set("global", "public network", conf.publicNetwork())
+	c.set("global", "cluster network", conf.publicNetwork())
+	if isIPv6(conf.MonIP) {
+		c.set("global", "ms bind ipv6", "true")
+		c.set("global", "ms bind ipv4", "false")
+	}
+	c.set("global", "log file", "/dev/null")
+	c.set("global", "osd pool default size", osdPoolDefaultSize)
+	c.set("global", "osd crush chooseleaf type", osdCrushChooseleafType)
+
+	mon := "mon." + hostname
+	c.set(mon, "mon data", cephDataPath+"/mon/ceph-"+hostname)
+	c.set(mon, "public addr", conf.monAddr())
+
+	c.set("osd", "osd journal size", osdJournalSize)
+	c.set("osd", "osd objectstore", osdObjectstore)
+	c.set("osd", "bluestore block size", osdBlockSize())
+
+	rgw := "client.rgw." + hostname
+	c.set(rgw, "keyring", cephDataPath+"/radosgw/ceph-rgw."+hostname+"/keyring")
+	for _, opt := range rgwOptions(hostname) {
+		c.set(rgw, opt[0], opt[1])
+	}
+
+	return c
+}
+
+// cephConfEnvSection returns the section a CEPH_CONF_<section>_<key> variable applies to
+// mon and rgw stand for the monitor and the gateway of this host
+func cephConfEnvSection(section, hostname string) string {
+	switch section = strings.ToLower(section); section {
+	case "mon":
+		return "mon." + hostname
+	case "rgw":
+		return "client.rgw." + hostname
+	}
+	return section
+}
+
+// applyCephConfOverrides merges the drop-ins in lexical order, then the CEPH_CONF_ environment variables
+func applyCephConfOverrides(c *cephConfFile, hostname string, environ []string) error {
+	dropIns, err := filepath.Glob(hostPath(cephConfDropInDir + "/*.conf"))
+	if err != nil {
+		return err
+	}
+	for _, path := range dropIns {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		dropIn, err := parseCephConf(f, filepath.Join(cephConfDropInDir, filepath.Base(path)))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		c.merge(dropIn)
+	}
+
+	for _, env := range environ {
+		if !strings.HasPrefix(env, cephConfEnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(env, cephConfEnvPrefix), "=", 2)
+		fields := strings.SplitN(kv[0], "_", 2)
+		if len(kv) != 2 || len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("invalid %s, expected %s<section>_<key>=<value>", kv[0], cephConfEnvPrefix)
+		}
+		c.set(cephConfEnvSection(fields[0], hostname), strings.ToLower(fields[1]), kv[1])
+	}
+
+	return nil
+}
+
+// renderCephConf returns the content of ceph.conf for a cluster, with the user settings applied
+func renderCephConf(hostname, fsid string) (string, error) {
+	c := generateCephConf(hostname, fsid)
+	if err := applyCephConfOverrides(c, hostname, os.Environ()); err != nil {
+		return "", fmt.Errorf("failed to apply the ceph.conf overrides: %v", err)
+	}
+	return c.String(), nil
+}
@@ -0,0 +1,178 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCephConf(t *testing.T) {
+	c := generateCephConf("nano", "f4d6b1bd-4c1b-4a5f-a1b8-1a2b3c4d5e6f")
+	out := c.String()
+
+	assert.True(t, strings.HasPrefix(out, cephConfHeader))
+	assert.Contains(t, out, "\n[global]\nfsid = f4d6b1bd-4c1b-4a5f-a1b8-1a2b3c4d5e6f\nmon initial members = nano\n")
+	assert.Contains(t, out, "\n[mon.nano]\nmon data = /var/lib/ceph/mon/ceph-nano\npublic addr = 127.0.0.1:3300\n")
+	assert.Contains(t, out, "\n[osd]\nosd journal size = 100\n")
+	assert.Contains(t, out, "\n[client.rgw.nano]\nkeyring = /var/lib/ceph/radosgw/ceph-rgw.nano/keyring\nrgw dns name = nano\n")
+
+	// the generated file reads back the same
+	parsed, err := parseCephConf(strings.NewReader(out), "ceph.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, out, parsed.String())
+}
+
+func TestGenerateCephConfIPv6(t *testing.T) {
+	defer func() { conf = defaultConfig() }()
+	conf.MonIP = "fd00::2"
+
+	out := generateCephConf("nano", "fsid").String()
+	assert.Contains(t, out, "mon host = [v2:[fd00::2]:3300,v1:[fd00::2]:6789]\npublic network = ::/0\ncluster network = ::/0\n")
+	assert.Contains(t, out, "ms bind ipv6 = true\nms bind ipv4 = false\n")
+	assert.Contains(t, out, "public addr = [fd00::2]:3300\n")
+	assert.Contains(t, out, "rgw frontends = beast endpoint=[::]:8000\n")
+}
+
+func TestParseCephConf(t *testing.T) {
+	c, err := parseCephConf(strings.NewReader("# comment\n[global]\n; comment\nmon_host = 10.0.0.1\n\n[osd]\nosd-objectstore=filestore\n"), "ceph.conf")
+	assert.Nil(t, err)
+	value, ok := c.get("global", "mon host")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", value)
+	value, _ = c.get("osd", "osd objectstore")
+	assert.Equal(t, "filestore", value)
+
+	_, err = parseCephConf(strings.NewReader("mon host = 10.0.0.1\n"), "10-net.conf")
+	assert.EqualError(t, err, "10-net.conf:1: mon host is not in a section")
+	_, err = parseCephConf(strings.NewReader("[global]\nmon host\n"), "10-net.conf")
+	assert.EqualError(t, err, `10-net.conf:2: expected 'key = value', got "mon host"`)
+	_, err = parseCephConf(strings.NewReader("[ ]\n"), "10-net.conf")
+	assert.EqualError(t, err, "10-net.conf:1: empty section name")
+}
+
+func TestPreviousMonAddr(t *testing.T) {
+	c, err := parseCephConf(strings.NewReader("[mon.nano]\npublic addr = 10.0.0.1:3300\n"), "ceph.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:3300", previousMonAddr(c, "nano"))
+
+	// a ceph.conf written before mon_ip existed has no address for the monitor
+	c, err = parseCephConf(strings.NewReader("[global]\nmon host = [v2:127.0.0.1:3300,v1:127.0.0.1:6789]\n"), "ceph.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:3300", previousMonAddr(c, "nano"))
+}
+
+func TestApplyCephConfOverrides(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	dir := filepath.Join(root, cephConfDropInDir)
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "10-debug.conf"), []byte("[osd]\ndebug_osd = 10\n[mon.nano]\npublic_addr = 10.0.0.1:3300\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "20-debug.conf"), []byte("[osd]\ndebug osd = 20\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a drop-in"), 0644))
+
+	c := generateCephConf("nano", "fsid")
+	environ := []string{"PATH=/usr/bin", "CEPH_CONF_GLOBAL_MON_HOST=10.0.0.1", "CEPH_CONF_rgw_rgw_dns_name=s3.example.com", "CEPH_CONF_osd_debug_osd=30"}
+	assert.Nil(t, applyCephConfOverrides(c, "nano", environ))
+
+	// drop-ins apply in lexical order, the environment wins, keys keep their first spelling
+	out := c.String()
+	assert.Contains(t, out, "mon host = 10.0.0.1\n")
+	assert.Contains(t, out, "\n[mon.nano]\nmon data = /var/lib/ceph/mon/ceph-nano\npublic addr = 10.0.0.1:3300\n")
+	assert.Contains(t, out, "debug_osd = 30\n")
+	assert.Contains(t, out, "rgw dns name = s3.example.com\n")
+	assert.NotContains(t, out, "not a drop-in")
+
+	err := applyCephConfOverrides(c, "nano", []string{"CEPH_CONF_global=1"})
+	assert.EqualError(t, err, "invalid global, expected CEPH_CONF_<section>_<key>=<value>")
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "30-broken.conf"), []byte("debug = 1\n"), 0644))
+	err = applyCephConfOverrides(c, "nano", nil)
+	assert.EqualError(t, err, cephConfDropInDir+"/30-broken.conf:1: debug is not in a section")
+}
+
+// effectiveOption returns the value of an option for a daemon started with args, the command line wins over ceph.conf
+func effectiveOption(c *cephConfFile, args []string, key string, sections ...string) string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--") && normalizeCephConfKey(arg[2:]) == normalizeCephConfKey(key) && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	for _, section := range append(sections, "global") {
+		if value, ok := c.get(section, key); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestCephConfOverridesReachDaemons(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+	for _, dir := range []string{cephConfigPath, cephDataPath + "/bootstrap-osd", "/opt/ceph-container/tmp", dashboardDir + "static/js", "/root"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dashboardDir+"sree.cfg.sample"), []byte(testSreeCfg), 0644))
+	defer fakeSysfs(t, map[string]string{"proc/meminfo": testMemInfo})()
+
+	// the examples of the README
+	os.Setenv("CEPH_CONF_GLOBAL_OSD_POOL_DEFAULT_SIZE", "3")
+	os.Setenv("CEPH_CONF_rgw_rgw_dns_name", "s3.example.com")
+	defer os.Unsetenv("CEPH_CONF_GLOBAL_OSD_POOL_DEFAULT_SIZE")
+	defer os.Unsetenv("CEPH_CONF_rgw_rgw_dns_name")
+
+	replay := replayTestdata(t, "bootstrap-osd-device.json")
+	runner = replay
+	conf.OsdDevice = "/dev/sdb"
+	bootstrapCluster()
+	assert.Equal(t, 0, replay.remaining())
+
+	f, err := os.Open(filepath.Join(root, cephConfFilePath))
+	assert.Nil(t, err)
+	defer f.Close()
+	c, err := parseCephConf(f, cephConfFilePath)
+	assert.Nil(t, err)
+
+	hostname, _ := os.Hostname()
+	started := 0
+	for _, entry := range replay.entries {
+		switch {
+		case entry.Args[0] == "ceph-mon" && entry.Background:
+			assert.Equal(t, "3", effectiveOption(c, entry.Args, "osd pool default size", "mon."+hostname, "mon"))
+		case entry.Args[0] == "ceph-osd" && entry.Args[5] == "-i":
+			assert.Equal(t, "3", effectiveOption(c, entry.Args, "osd pool default size", "osd."+entry.Args[6], "osd"))
+		case entry.Args[0] == "radosgw":
+			assert.Equal(t, "s3.example.com", effectiveOption(c, entry.Args, "rgw dns name", "client.rgw."+hostname, "client"))
+		default:
+			continue
+		}
+		started++
+	}
+	assert.Equal(t, 3, started)
+}
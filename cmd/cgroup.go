@@ -0,0 +1,186 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cgroupV1Unlimited is the smallest value reported by cgroup v1 for an unbounded memory limit
+	// The kernel reports 9223372036854771712 on 4k pages systems, it depends on the page size
+	cgroupV1Unlimited uint64 = 1 << 62
+)
+
+var (
+	// cgroupRoot and procRoot are variables so tests can use a fake sysfs
+	cgroupRoot = "/sys/fs/cgroup"
+	procRoot   = "/proc"
+)
+
+// cgroupMemory is the memory accounting of the cgroup cn-core runs in
+type cgroupMemory struct {
+	Version int    // 1 or 2, 0 when no memory cgroup was found
+	Limited bool   // false when the cgroup has no memory limit
+	Limit   uint64 // in bytes, only relevant when Limited is true
+	Usage   uint64 // in bytes
+}
+
+// detectCgroupMemory reads the memory limit and usage of the cgroup v1 or v2 hierarchy
+func detectCgroupMemory() (cgroupMemory, error) {
+	// cgroup v2 exposes the list of controllers at the root of the unified hierarchy
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return readCgroupV2Memory()
+	}
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "memory", "memory.limit_in_bytes")); err == nil {
+		return readCgroupV1Memory()
+	}
+
+	return cgroupMemory{}, nil
+}
+
+func readCgroupV1Memory() (cgroupMemory, error) {
+	mem := cgroupMemory{Version: 1}
+	dir := filepath.Join(cgroupRoot, "memory")
+
+	limit, err := readCgroupValue(filepath.Join(dir, "memory.limit_in_bytes"))
+	if err != nil {
+		return mem, err
+	}
+	usage, err := readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return mem, err
+	}
+
+	// 8 ExaBytes is the value of an unbounded device
+	mem.Limited = limit < cgroupV1Unlimited
+	mem.Limit = limit
+	mem.Usage = usage
+
+	return mem, nil
+}
+
+func readCgroupV2Memory() (cgroupMemory, error) {
+	mem := cgroupMemory{Version: 2}
+
+	// without a cgroup namespace the unified hierarchy is mounted from its root
+	// so we look for our own cgroup first
+	dir := cgroupRoot
+	if path, err := cgroupV2Path(); err == nil {
+		if _, err := os.Stat(filepath.Join(cgroupRoot, path, "memory.max")); err == nil {
+			dir = filepath.Join(cgroupRoot, path)
+		}
+	}
+
+	// the root cgroup has no memory.max, there is no limit
+	content, err := ioutil.ReadFile(filepath.Join(dir, "memory.max"))
+	if os.IsNotExist(err) {
+		return mem, nil
+	}
+	if err != nil {
+		return mem, err
+	}
+
+	if max := strings.TrimSpace(string(content)); max != "max" {
+		limit, err := strconv.ParseUint(max, 10, 64)
+		if err != nil {
+			return mem, fmt.Errorf("failed to parse %s: %v", filepath.Join(dir, "memory.max"), err)
+		}
+		mem.Limited = true
+		mem.Limit = limit
+	}
+
+	usage, err := readCgroupValue(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return mem, err
+	}
+	mem.Usage = usage
+
+	return mem, nil
+}
+
+// cgroupV2Path returns the path of our cgroup in the unified hierarchy from /proc/self/cgroup
+func cgroupV2Path() (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, "self", "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// the unified hierarchy line looks like '0::/docker/37c246814969'
+		if line := scanner.Text(); strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+
+	return "", fmt.Errorf("no cgroup v2 entry in %s", filepath.Join(procRoot, "self", "cgroup"))
+}
+
+func readCgroupValue(path string) (uint64, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// we need to trim the newline since the string representation of the Readfile gives
+	// "209715200\n" and strconv is not happy
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return value, nil
+}
+
+// readMemInfo returns a value of /proc/meminfo in bytes
+func readMemInfo(key string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// lines look like 'MemAvailable:    3887372 kB'
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != key+":" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %s from meminfo: %v", key, err)
+		}
+		return value * 1024, nil
+	}
+
+	return 0, fmt.Errorf("%s not found in meminfo", key)
+}
@@ -0,0 +1,185 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	s3cmdTemplate = `[default]
+access_key = {{.AccessKey}}
+secret_key = {{.SecretKey}}
+host_base = {{.S3Host}}
+host_bucket = {{.S3Host}}
+use_https = {{if .TLS}}True{{else}}False{{end}}
+{{- if .CABundle}}
+ca_certs_file = {{.CABundle}}
+check_ssl_certificate = True
+{{- end}}
+`
+
+	// the configuration Sree reads in the browser, the keys are the ones of the cn user
+	sreeBaseJsTemplate = `var config = {
+    endpoint: '{{js .Endpoint}}',
+    accessKey: '{{js .AccessKey}}',
+    secretKey: '{{js .SecretKey}}'
+};
+`
+)
+
+// clientContext holds the values the client configurations are rendered from
+type clientContext struct {
+	Endpoint  string // URL the dashboard uses to reach Rados Gateway
+	S3Host    string // host:port s3cmd connects to, the HTTPS port with TLS
+	AccessKey string
+	SecretKey string
+	RgwPort   string
+	DashPort  string
+	TLS       bool
+	CABundle  string // CA certificates the clients trust Rados Gateway with, empty without TLS or for a public CA
+}
+
+// clientTemplate is a client configuration file rendered from scratch on every start
+// The template is, by order of preference, name.tmpl in the templates directory, the embedded text,
+// or a sample shipped with the client whose placeholders are turned into template actions
+// The sample is never the rendered file itself, it would hold the keys and addresses of a previous start
+type clientTemplate struct {
+	name         string
+	path         string
+	perm         os.FileMode
+	text         string
+	sample       string
+	placeholders []string // pairs of placeholder and template action
+}
+
+var clientTemplates = map[string][]clientTemplate{
+	"s3cmd": {
+		{name: "s3cfg", path: s3CmdFilePath, perm: 0600, text: s3cmdTemplate},
+	},
+	"dashboard": {
+		{name: "base.js", path: dashboardDir + "static/js/base.js", perm: 0644, text: sreeBaseJsTemplate},
+		{name: "sree.cfg", path: dashboardDir + "sree.cfg", perm: 0644, sample: dashboardDir + "sree.cfg.sample",
+			placeholders: []string{"RGW_CIVETWEB_PORT_VALUE", "{{.RgwPort}}", "SREE_PORT_VALUE", "{{.DashPort}}"}},
+	},
+}
+
+// configureClients renders the configuration files of a client, they reflect the current keys and addresses on every start
+func configureClients(client string) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal("Failed to get the hostname.")
+	}
+
+	switch client {
+	case "s3cmd":
+		log.Println("init rgw: configure s3cmd client")
+	case "dashboard":
+		log.Println("init dashboard: configure dashboard")
+	}
+
+	// the cn user does not exist yet during a dry run
+	creds := s3Credentials{User: cnCoreRgwUserUID, AccessKey: "<access key>", SecretKey: "<secret key>"}
+	if !dryRun {
+		if creds, err = cnCredentials(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx := newClientContext(hostname, creds)
+	for _, t := range clientTemplates[client] {
+		if err := t.render(ctx); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func newClientContext(hostname string, creds s3Credentials) clientContext {
+	ctx := clientContext{
+		Endpoint:  "http://" + net.JoinHostPort(conf.ExposedIP, conf.RgwPort),
+		S3Host:    hostname + ":" + conf.RgwPort,
+		AccessKey: creds.AccessKey,
+		SecretKey: creds.SecretKey,
+		RgwPort:   conf.RgwPort,
+		DashPort:  conf.DashPort,
+	}
+	// the dashboard keeps the HTTP endpoint, browsers do not trust the local CA
+	if conf.rgwTLS() {
+		_, _, ca := conf.rgwTLSFiles()
+		ctx.S3Host, ctx.TLS, ctx.CABundle = hostname+":"+conf.RgwTLSPort, true, ca
+	}
+
+	return ctx
+}
+
+// render writes the configuration file, replacing whatever a previous start rendered
+func (t clientTemplate) render(ctx clientContext) error {
+	if dryRun {
+		return writeFile(t.path, nil, t.perm)
+	}
+
+	tmpl, err := t.load(conf.TemplatesDir)
+	if err != nil {
+		return fmt.Errorf("failed to load the template of %s: %v", t.path, err)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, ctx); err != nil {
+		return fmt.Errorf("failed to render %s: %v", t.path, err)
+	}
+
+	return writeFile(t.path, out.Bytes(), t.perm)
+}
+
+func (t clientTemplate) load(dir string) (*template.Template, error) {
+	override := filepath.Join(dir, t.name+".tmpl")
+	content, err := ioutil.ReadFile(hostPath(override))
+	if err == nil {
+		log.Println("init: rendering " + t.path + " from " + override)
+		return template.New(t.name).Parse(string(content))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if t.text != "" {
+		return template.New(t.name).Parse(t.text)
+	}
+
+	sample, err := ioutil.ReadFile(hostPath(t.sample))
+	if err != nil {
+		return nil, err
+	}
+
+	// braces in the sample are not template actions
+	replacer := strings.NewReplacer(append([]string{"{{", `{{"{{"}}`}, t.placeholders...)...)
+	return template.New(t.name).Parse(replacer.Replace(string(sample)))
+}
@@ -0,0 +1,113 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// files shipped by Sree, with their placeholders
+const (
+	testSreeCfg = "[sree]\nRGW_CIVETWEB_PORT = RGW_CIVETWEB_PORT_VALUE\nSREE_PORT = SREE_PORT_VALUE\n"
+	// a base.js rendered by an earlier start, it has no placeholders left
+	testBaseJs = "var config = {\n    endpoint: 'http://10.0.0.1:8000',\n    accessKey: 'STALEACCESSKEY000000',\n    secretKey: 'staleSecretKey'\n};\n"
+)
+
+func testClientsRoot(t *testing.T) (string, func()) {
+	root, cleanup := testRoot(t)
+	conf = defaultConfig()
+
+	for _, dir := range []string{"/root", dashboardDir + "static/js", "/opt/ceph-container/tmp"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dashboardDir+"sree.cfg.sample"), []byte(testSreeCfg), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dashboardDir+"static/js/base.js"), []byte(testBaseJs), 0644))
+	writeTestKeys(t, root, "CN4CCESSKEY0000000000", "cnSecretKey")
+
+	return root, cleanup
+}
+
+func writeTestKeys(t *testing.T, root, accessKey, secretKey string) {
+	details := `{"keys": [{"user": "cn", "access_key": "` + accessKey + `", "secret_key": "` + secretKey + `"}]}`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, cnUserDetailsFile), []byte(details), 0600))
+}
+
+func readTestFile(t *testing.T, root, path string) string {
+	content, err := ioutil.ReadFile(filepath.Join(root, path))
+	assert.Nil(t, err)
+	return string(content)
+}
+
+func TestConfigureS3cmd(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+
+	hostname, _ := os.Hostname()
+	configureClients("s3cmd")
+	assert.Equal(t, "[default]\naccess_key = CN4CCESSKEY0000000000\nsecret_key = cnSecretKey\nhost_base = "+hostname+":8000\nhost_bucket = "+hostname+":8000\nuse_https = False\n", readTestFile(t, root, s3CmdFilePath))
+
+	info, err := os.Stat(filepath.Join(root, s3CmdFilePath))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestConfigureDashboard(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.ExposedIP = "192.168.0.10"
+
+	configureClients("dashboard")
+	assert.Equal(t, "var config = {\n    endpoint: 'http://192.168.0.10:8000',\n    accessKey: 'CN4CCESSKEY0000000000',\n    secretKey: 'cnSecretKey'\n};\n", readTestFile(t, root, dashboardDir+"static/js/base.js"))
+	assert.Equal(t, "[sree]\nRGW_CIVETWEB_PORT = 8000\nSREE_PORT = 5000\n", readTestFile(t, root, dashboardDir+"sree.cfg"))
+
+	// the next start renders the files again from the templates, not from what the previous one rendered
+	conf.ExposedIP, conf.DashPort = "192.168.0.11", "5050"
+	writeTestKeys(t, root, "CN4CCESSKEY1111111111", "cnSecretKey1")
+	configureClients("dashboard")
+	assert.Equal(t, "var config = {\n    endpoint: 'http://192.168.0.11:8000',\n    accessKey: 'CN4CCESSKEY1111111111',\n    secretKey: 'cnSecretKey1'\n};\n", readTestFile(t, root, dashboardDir+"static/js/base.js"))
+	assert.Equal(t, "[sree]\nRGW_CIVETWEB_PORT = 8000\nSREE_PORT = 5050\n", readTestFile(t, root, dashboardDir+"sree.cfg"))
+}
+
+func TestClientTemplateOverride(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, cnCoreTemplatesDir), 0755))
+	override := "[default]\nhost_base = {{.S3Host}}\nsignature_v2 = True\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, cnCoreTemplatesDir, "s3cfg.tmpl"), []byte(override), 0644))
+
+	hostname, _ := os.Hostname()
+	configureClients("s3cmd")
+	assert.Equal(t, "[default]\nhost_base = "+hostname+":8000\nsignature_v2 = True\n", readTestFile(t, root, s3CmdFilePath))
+
+	// a broken override is reported
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, cnCoreTemplatesDir, "s3cfg.tmpl"), []byte("{{.Unknown}}"), 0644))
+	err := clientTemplates["s3cmd"][0].render(newClientContext(hostname, s3Credentials{}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to render "+s3CmdFilePath)
+}
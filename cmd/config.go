@@ -0,0 +1,428 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alecthomas/units"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	cnCoreConfigPath    = "/etc/cn-core/cn-core.yaml"
+	cnCoreConfigEnv     = "CN_CORE_CONFIG"
+	cnCoreTemplatesDir  = "/etc/cn-core/templates"
+	configSourceDefault = "default"
+)
+
+// cnConfig is the effective configuration of cn-core
+// Values are resolved with the following precedence: flags > env > file > defaults
+type cnConfig struct {
+	RgwPort            string
+	DashPort           string
+	HealthPort         string
+	ExposedIP          string
+	MonIP              string
+	PublicNetwork      string
+	BindAddress        string
+	RgwTLS             string
+	RgwTLSPort         string
+	RgwTLSCert         string
+	RgwTLSKey          string
+	RgwTLSCA           string
+	OsdDevice          string
+	OsdCount           string
+	OsdPath            string
+	BluestoreBlockSize string
+	Supervise          string
+	RestartBudget      string
+	TemplatesDir       string
+	Fixtures           string
+	SeedDir            string
+	Dashboard          string
+
+	// path is the configuration file that was loaded, if any
+	path string
+	// sources records where each value came from, indexed by option key
+	sources map[string]string
+}
+
+// configOption describes how a single configuration value can be set
+type configOption struct {
+	key     string   // key in the configuration file
+	flag    string   // command line flag
+	envs    []string // environment variables, the last one set wins
+	usage   string
+	value   *string
+	boolean bool // the flag does not take a value
+}
+
+var (
+	// conf is the configuration used by the bootstrap functions
+	conf = defaultConfig()
+
+	configFile string
+)
+
+func defaultConfig() *cnConfig {
+	return &cnConfig{
+		RgwPort:       "8000",
+		DashPort:      "5000",
+		HealthPort:    "5001",
+		MonIP:         "127.0.0.1",
+		RgwTLS:        "false",
+		RgwTLSPort:    "8443",
+		OsdCount:      "1",
+		Supervise:     "false",
+		RestartBudget: "5",
+		TemplatesDir:  cnCoreTemplatesDir,
+		Dashboard:     dashboardSree,
+		sources:       map[string]string{},
+	}
+}
+
+// options lists every configurable value, in the order they are displayed
+func (c *cnConfig) options() []configOption {
+	return []configOption{
+		// RGW_CIVETWEB_PORT is kept for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1356
+		{key: "rgw_port", flag: "rgw-port", envs: []string{"RGW_FRONTEND_PORT", "RGW_CIVETWEB_PORT"}, usage: "Specify binding port for Rados Gateway.", value: &c.RgwPort},
+		{key: "dash_port", flag: "dash-port", envs: []string{"SREE_PORT"}, usage: "Specify binding port for the dashboard.", value: &c.DashPort},
+		{key: "health_port", flag: "health-port", envs: []string{"HEALTH_PORT"}, usage: "Specify binding port for the liveness and readiness probes, 0 disables them.", value: &c.HealthPort},
+		// EXPOSED_IP is coming from cn itself
+		{key: "exposed_ip", flag: "dash-exposed-ip", envs: []string{"EXPOSED_IP"}, usage: "Specify the IP address the dashboard uses to reach Rados Gateway.", value: &c.ExposedIP},
+		{key: "mon_ip", flag: "mon-ip", envs: []string{"MON_IP"}, usage: "Specify the IP address of the monitor, IPv4 or IPv6. auto detects the primary address of the container.", value: &c.MonIP},
+		{key: "public_network", flag: "public-network", envs: []string{"CEPH_PUBLIC_NETWORK"}, usage: "Specify the public network of the cluster in CIDR notation, it must contain mon_ip. Default is every address of the mon_ip family.", value: &c.PublicNetwork},
+		{key: "bind_address", flag: "bind-address", envs: []string{"RGW_BIND_ADDRESS"}, usage: "Specify the IP address Rados Gateway and the ceph dashboard listen on. Default is every address of the mon_ip family.", value: &c.BindAddress},
+		{key: "rgw_tls", flag: "rgw-tls", envs: []string{"RGW_TLS"}, usage: "Serve S3 over HTTPS too, with a certificate issued by a local CA unless rgw_tls_cert is given.", value: &c.RgwTLS, boolean: true},
+		{key: "rgw_tls_port", flag: "rgw-tls-port", envs: []string{"RGW_TLS_PORT"}, usage: "Specify binding port for the HTTPS endpoint of Rados Gateway.", value: &c.RgwTLSPort},
+		{key: "rgw_tls_cert", flag: "rgw-tls-cert", envs: []string{"RGW_TLS_CERT"}, usage: "Specify a PEM certificate for Rados Gateway instead of the generated one.", value: &c.RgwTLSCert},
+		{key: "rgw_tls_key", flag: "rgw-tls-key", envs: []string{"RGW_TLS_KEY"}, usage: "Specify the PEM private key of rgw_tls_cert.", value: &c.RgwTLSKey},
+		{key: "rgw_tls_ca", flag: "rgw-tls-ca", envs: []string{"RGW_TLS_CA"}, usage: "Specify the PEM CA bundle the clients need to trust rgw_tls_cert.", value: &c.RgwTLSCA},
+		{key: "osd_device", flag: "osd-device", envs: []string{"OSD_DEVICE"}, usage: "Specify block devices to use for the OSDs, separated by commas. One OSD is created per device.", value: &c.OsdDevice},
+		{key: "osd_count", flag: "osd-count", envs: []string{"OSD_COUNT"}, usage: "Specify the number of file backed OSDs to run.", value: &c.OsdCount},
+		{key: "osd_path", flag: "osd-path", envs: []string{"OSD_PATH"}, usage: "Specify a dedicated directory for the OSD data.", value: &c.OsdPath},
+		{key: "bluestore_block_size", flag: "bluestore-block-size", envs: []string{"BLUESTORE_BLOCK_SIZE"}, usage: "Specify the size of the BlueStore block, e.g: 10GB.", value: &c.BluestoreBlockSize},
+		{key: "supervise", flag: "supervise", envs: []string{"SUPERVISE"}, usage: "Run the daemons in the foreground and restart them when they crash.", value: &c.Supervise, boolean: true},
+		{key: "restart_budget", flag: "restart-budget", envs: []string{"RESTART_BUDGET"}, usage: "Specify how many times a supervised daemon can restart within 10 minutes before giving up.", value: &c.RestartBudget},
+		{key: "dashboard", flag: "dashboard", envs: []string{"DASHBOARD"}, usage: "Specify the dashboard to run. Valid choices are: " + strings.Join(validValueDashboard, ", ") + ".", value: &c.Dashboard},
+		{key: "templates_dir", flag: "templates-dir", envs: []string{"CN_CORE_TEMPLATES_DIR"}, usage: "Specify a directory of templates overriding the client configurations cn-core renders.", value: &c.TemplatesDir},
+		{key: "fixtures", flag: "fixtures", envs: []string{"CN_CORE_FIXTURES"}, usage: "Specify a YAML file of buckets to create, with their settings, once Rados Gateway runs.", value: &c.Fixtures},
+		{key: "seed_dir", flag: "seed-dir", envs: []string{"CN_CORE_SEED_DIR"}, usage: "Specify a directory whose sub-directories are uploaded as buckets once Rados Gateway runs.", value: &c.SeedDir},
+	}
+}
+
+// addConfigFlags declares the configuration flags on a command
+func addConfigFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&configFile, "config", "", "Specify the configuration file, default is "+cnCoreConfigPath+".")
+	for _, opt := range defaultConfig().options() {
+		if opt.boolean {
+			b, _ := strconv.ParseBool(*opt.value)
+			flags.Bool(opt.flag, b, opt.usage)
+			continue
+		}
+		flags.String(opt.flag, *opt.value, opt.usage)
+	}
+}
+
+// loadConfig resolves the configuration from the defaults, the configuration file, the environment and the flags
+func loadConfig(flags *pflag.FlagSet) (*cnConfig, error) {
+	c := defaultConfig()
+	opts := c.options()
+	for _, opt := range opts {
+		c.sources[opt.key] = configSourceDefault
+	}
+
+	// an explicit configuration file must exist, the default one is optional
+	path := configFile
+	if path == "" {
+		path = os.Getenv(cnCoreConfigEnv)
+	}
+	explicit := path != ""
+	if !explicit {
+		path = cnCoreConfigPath
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		values := map[string]string{}
+		if err := yaml.UnmarshalStrict(content, &values); err != nil {
+			return nil, fmt.Errorf("config: failed to parse %s: %v", path, err)
+		}
+		known := map[string]bool{}
+		for _, opt := range opts {
+			known[opt.key] = true
+			if v, ok := values[opt.key]; ok {
+				*opt.value = v
+				c.sources[opt.key] = "file (" + path + ")"
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				return nil, fmt.Errorf("config: unknown option %q in %s", key, path)
+			}
+		}
+		c.path = path
+	} else if explicit || !os.IsNotExist(err) {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	for _, opt := range opts {
+		for _, env := range opt.envs {
+			if v := os.Getenv(env); v != "" {
+				*opt.value = v
+				c.sources[opt.key] = "env (" + env + ")"
+			}
+		}
+		if flags != nil && flags.Changed(opt.flag) {
+			*opt.value = flags.Lookup(opt.flag).Value.String()
+			c.sources[opt.key] = "flag (--" + opt.flag + ")"
+		}
+	}
+
+	if err := c.resolveMonIP(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// validate checks the configuration before any daemon is touched
+func (c *cnConfig) validate() error {
+	for key, port := range map[string]string{"rgw_port": c.RgwPort, "dash_port": c.DashPort, "rgw_tls_port": c.RgwTLSPort} {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("config: %s must be a valid port, got %q", key, port)
+		}
+	}
+	if c.RgwPort == c.DashPort {
+		return fmt.Errorf("config: rgw_port and dash_port must be different, both are %s", c.RgwPort)
+	}
+	healthPort, err := strconv.Atoi(c.HealthPort)
+	if err != nil || healthPort < 0 || healthPort > 65535 {
+		return fmt.Errorf("config: health_port must be a valid port or 0, got %q", c.HealthPort)
+	}
+	if c.HealthPort == c.RgwPort || c.HealthPort == c.DashPort {
+		return fmt.Errorf("config: health_port must be different from rgw_port and dash_port, got %s", c.HealthPort)
+	}
+
+	if c.RgwTLSPort == c.RgwPort || c.RgwTLSPort == c.DashPort || c.RgwTLSPort == c.HealthPort {
+		return fmt.Errorf("config: rgw_tls_port must be different from rgw_port, dash_port and health_port, got %s", c.RgwTLSPort)
+	}
+	if _, err := strconv.ParseBool(c.RgwTLS); err != nil {
+		return fmt.Errorf("config: rgw_tls must be true or false, got %q", c.RgwTLS)
+	}
+	if (c.RgwTLSCert == "") != (c.RgwTLSKey == "") {
+		return fmt.Errorf("config: rgw_tls_cert and rgw_tls_key go together")
+	}
+	if c.RgwTLSCert != "" && !c.rgwTLS() {
+		return fmt.Errorf("config: rgw_tls_cert is only used with rgw_tls")
+	}
+	if c.RgwTLSCA != "" && c.RgwTLSCert == "" {
+		return fmt.Errorf("config: rgw_tls_ca is only used with rgw_tls_cert")
+	}
+
+	if c.ExposedIP != "" && net.ParseIP(c.ExposedIP) == nil {
+		return fmt.Errorf("config: exposed_ip must be an IP address, got %q", c.ExposedIP)
+	}
+
+	monIP := net.ParseIP(c.MonIP)
+	if monIP == nil || monIP.IsUnspecified() {
+		return fmt.Errorf("config: mon_ip must be an IP address or %s, got %q", monIPAuto, c.MonIP)
+	}
+	if c.PublicNetwork != "" {
+		_, network, err := net.ParseCIDR(c.PublicNetwork)
+		if err != nil {
+			return fmt.Errorf("config: public_network must be a network in CIDR notation, got %q", c.PublicNetwork)
+		}
+		if !network.Contains(monIP) {
+			return fmt.Errorf("config: public_network %s does not contain mon_ip %s", c.PublicNetwork, c.MonIP)
+		}
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		return fmt.Errorf("config: bind_address must be an IP address, got %q", c.BindAddress)
+	}
+
+	if c.BluestoreBlockSize != "" {
+		if _, err := units.ParseBase2Bytes(c.BluestoreBlockSize); err != nil {
+			if _, err := strconv.ParseUint(c.BluestoreBlockSize, 10, 64); err != nil {
+				return fmt.Errorf("config: bluestore_block_size must be a size, got %q", c.BluestoreBlockSize)
+			}
+		}
+	}
+
+	osdCount, err := strconv.Atoi(c.OsdCount)
+	if err != nil || osdCount < 1 {
+		return fmt.Errorf("config: osd_count must be a positive number, got %q", c.OsdCount)
+	}
+	// the OSDs share the memory left once the mon and mgr run, like bootstrapOsd does, one OSD is checked against cnMemMin by init
+	if osds := c.osdCount(); osds > 1 {
+		memLimit, err := getMemLimit()
+		if err != nil {
+			return fmt.Errorf("config: failed to read the memory limit: %v", err)
+		}
+		var share uint64
+		if memLimit > mbTob(osdMemReserve) {
+			share = osdMemoryShare(memLimit-mbTob(osdMemReserve), osds)
+		}
+		if share < mbTob(osdMemMin) {
+			return fmt.Errorf("config: %d OSDs need at least %dMB of RAM, got %dMB", osds, osdMemReserve+uint64(osds)*osdMemMin, bToMb(memLimit))
+		}
+	}
+
+	devices := c.osdDevices()
+	for _, device := range devices {
+		fileType, err := getFileType(device)
+		if err != nil {
+			return fmt.Errorf("config: osd_device: %v", err)
+		}
+		if fileType != "blockdev" {
+			return fmt.Errorf("config: invalid osd_device %s, only block device is supported", device)
+		}
+	}
+	if len(devices) > 0 && osdCount != 1 && osdCount != len(devices) {
+		return fmt.Errorf("config: osd_count is %d but %d osd_device were given, one OSD is created per device", osdCount, len(devices))
+	}
+
+	if _, err := strconv.ParseBool(c.Supervise); err != nil {
+		return fmt.Errorf("config: supervise must be true or false, got %q", c.Supervise)
+	}
+	restartBudget, err := strconv.Atoi(c.RestartBudget)
+	if err != nil || restartBudget < 0 {
+		return fmt.Errorf("config: restart_budget must be 0 or a positive number, got %q", c.RestartBudget)
+	}
+
+	switch c.Dashboard {
+	case dashboardSree, dashboardCeph, dashboardNone:
+	default:
+		return fmt.Errorf("config: dashboard must be one of %s, got %q", strings.Join(validValueDashboard, ", "), c.Dashboard)
+	}
+
+	if c.OsdPath != "" {
+		fileType, err := getFileType(c.OsdPath)
+		if err != nil {
+			return fmt.Errorf("config: osd_path: %v", err)
+		}
+		if fileType != "directory" {
+			return fmt.Errorf("config: osd_path %s must be a directory", c.OsdPath)
+		}
+	}
+
+	return nil
+}
+
+// osdDevices returns the block devices to use for the OSDs
+func (c *cnConfig) osdDevices() []string {
+	var devices []string
+	for _, device := range strings.Split(c.OsdDevice, ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// osdCount returns the number of OSDs to run
+func (c *cnConfig) osdCount() int {
+	if devices := c.osdDevices(); len(devices) > 0 {
+		return len(devices)
+	}
+	count, err := strconv.Atoi(c.OsdCount)
+	if err != nil {
+		return 1
+	}
+	return count
+}
+
+// healthPort returns the port of the probes listener, 0 when disabled
+func (c *cnConfig) healthPort() string {
+	if port, _ := strconv.Atoi(c.HealthPort); port == 0 {
+		return ""
+	}
+	return c.HealthPort
+}
+
+// supervise tells if the daemons must run under the supervisor
+func (c *cnConfig) supervise() bool {
+	b, _ := strconv.ParseBool(c.Supervise)
+	return b
+}
+
+// restartBudget returns how many restarts a supervised daemon is allowed within the restart window
+func (c *cnConfig) restartBudget() int {
+	budget, _ := strconv.Atoi(c.RestartBudget)
+	return budget
+}
+
+// cliConfig is the Cobra CLI call
+func cliConfig() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect cn-core configuration",
+		Args:  cobra.NoArgs,
+	}
+
+	show := &cobra.Command{
+		Use:     "show",
+		Short:   "Print the effective configuration and where each value came from",
+		Args:    cobra.NoArgs,
+		Run:     showConfig,
+		Example: "cn-core config show --rgw-port 8080\n",
+	}
+	show.Flags().SortFlags = false
+	addConfigFlags(show.Flags())
+
+	cmd.AddCommand(show)
+
+	return cmd
+}
+
+// showConfig prints the effective configuration
+func showConfig(cmd *cobra.Command, args []string) {
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if c.path != "" {
+		fmt.Println("# configuration file: " + c.path)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, opt := range c.options() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", opt.key, *opt.value, c.sources[opt.key])
+	}
+	w.Flush()
+}
@@ -0,0 +1,149 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "cn-core-config")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "cn-core.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeTestConfig(t, "rgw_port: 8080\ndash_port: \"5050\"\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	defer func() { configFile = "" }()
+
+	os.Setenv("SREE_PORT", "6000")
+	defer os.Unsetenv("SREE_PORT")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addConfigFlags(flags)
+	assert.Nil(t, flags.Parse([]string{"--config", path, "--dash-exposed-ip", "10.0.0.1"}))
+
+	c, err := loadConfig(flags)
+	assert.Nil(t, err)
+	assert.Equal(t, "8080", c.RgwPort)
+	assert.Equal(t, "file ("+path+")", c.sources["rgw_port"])
+	assert.Equal(t, "6000", c.DashPort)
+	assert.Equal(t, "env (SREE_PORT)", c.sources["dash_port"])
+	assert.Equal(t, "10.0.0.1", c.ExposedIP)
+	assert.Equal(t, "flag (--dash-exposed-ip)", c.sources["exposed_ip"])
+	assert.Equal(t, configSourceDefault, c.sources["osd_device"])
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	path := writeTestConfig(t, "rgw_prot: 8080\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	configFile = path
+	defer func() { configFile = "" }()
+
+	_, err := loadConfig(nil)
+	assert.NotNil(t, err)
+
+	configFile = filepath.Join(filepath.Dir(path), "missing.yaml")
+	_, err = loadConfig(nil)
+	assert.NotNil(t, err)
+}
+
+func TestValidateConfig(t *testing.T) {
+	c := defaultConfig()
+	assert.Nil(t, c.validate())
+
+	c.RgwPort = "80000"
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.DashPort = c.RgwPort
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.ExposedIP = "not-an-ip"
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.BluestoreBlockSize = "10GB"
+	assert.Nil(t, c.validate())
+	c.BluestoreBlockSize = "lots"
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.MonIP, c.PublicNetwork, c.BindAddress = "fd00::2", "fd00::/64", "::"
+	assert.Nil(t, c.validate())
+	c.PublicNetwork = "10.0.0.0/8"
+	assert.EqualError(t, c.validate(), "config: public_network 10.0.0.0/8 does not contain mon_ip fd00::2")
+	c.PublicNetwork = "fd00::"
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.MonIP = "0.0.0.0"
+	assert.NotNil(t, c.validate())
+	c.MonIP = monIPAuto
+	assert.NotNil(t, c.validate())
+
+	c = defaultConfig()
+	c.BindAddress = "eth0"
+	assert.NotNil(t, c.validate())
+	c = defaultConfig()
+	c.RgwTLSPort = c.RgwPort
+	assert.NotNil(t, c.validate())
+	c = defaultConfig()
+	c.RgwTLSCert = "/certs/s3.pem"
+	assert.EqualError(t, c.validate(), "config: rgw_tls_cert and rgw_tls_key go together")
+	c.RgwTLSKey = "/certs/s3.key"
+	assert.EqualError(t, c.validate(), "config: rgw_tls_cert is only used with rgw_tls")
+	c.RgwTLS = "true"
+	assert.Nil(t, c.validate())
+
+	// a budget of 0 gives up on the first exit
+	c = defaultConfig()
+	c.RestartBudget = "0"
+	assert.Nil(t, c.validate())
+	c.RestartBudget = "-1"
+	assert.EqualError(t, c.validate(), `config: restart_budget must be 0 or a positive number, got "-1"`)
+
+	// 512MB are enough for one OSD only, whether the OSDs are files or devices
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "536870912\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "0\n",
+	})()
+	c = defaultConfig()
+	c.OsdCount = "1"
+	assert.Nil(t, c.validate())
+	c.OsdCount = "3"
+	assert.EqualError(t, c.validate(), "config: 3 OSDs need at least 1024MB of RAM, got 512MB")
+	c = defaultConfig()
+	c.OsdDevice = "/dev/sdb,/dev/sdc,/dev/sdd"
+	assert.EqualError(t, c.validate(), "config: 3 OSDs need at least 1024MB of RAM, got 512MB")
+}
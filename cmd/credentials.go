@@ -0,0 +1,213 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	validValueCredentialsFormat = []string{"env", "json", "aws", "rclone", "s3cmd", "mc"}
+
+	credentialsFormat  string
+	credentialsProfile string
+	credentialsUser    string
+	credentialsTenant  string
+)
+
+// s3Credentials are the keys of a user, as kept in its details file
+type s3Credentials struct {
+	User      string
+	AccessKey string
+	SecretKey string
+}
+
+// s3Export is what 'cn-core credentials' prints, in every format
+type s3Export struct {
+	Profile   string `json:"profile"`
+	Endpoint  string `json:"endpoint"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// CABundle is the CA certificates to trust the endpoint with, inside the container
+	CABundle string `json:"ca_bundle,omitempty"`
+}
+
+// loadCredentials reads the first S3 key of a user details file written from the output of radosgw-admin
+func loadCredentials(path string) (s3Credentials, error) {
+	content, err := ioutil.ReadFile(hostPath(path))
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("failed to read the user credentials: %v", err)
+	}
+
+	var info rgwUserInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return s3Credentials{}, fmt.Errorf("failed to parse the user credentials in %s: %v", path, err)
+	}
+	if len(info.Keys) == 0 {
+		return s3Credentials{}, fmt.Errorf("user %q has no S3 key in %s", info.UserID, path)
+	}
+	key := info.Keys[0]
+	if key.AccessKey == "" || key.SecretKey == "" {
+		return s3Credentials{}, fmt.Errorf("the first S3 key of user %q in %s is incomplete", info.UserID, path)
+	}
+
+	return s3Credentials{User: info.UserID, AccessKey: key.AccessKey, SecretKey: key.SecretKey}, nil
+}
+
+// cnCredentials returns the keys of the cn user, the clients cn-core configures use them
+func cnCredentials() (s3Credentials, error) {
+	return loadCredentials(cnUserDetailsFile)
+}
+
+// cliCredentials is the Cobra CLI call
+func cliCredentials() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Print the S3 endpoint and keys of the cn user for local tools",
+		Args:  cobra.NoArgs,
+		Run:   printCredentialsCmd,
+		Example: `eval "$(cn-core credentials)"
+cn-core credentials --format rclone >> ~/.config/rclone/rclone.conf
+cn-core credentials --format aws --profile nano >> ~/.aws/credentials
+`,
+	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().StringVar(&credentialsFormat, "format", "env", "Output format. Valid choices are: "+strings.Join(validValueCredentialsFormat, ", ")+".")
+	cmd.Flags().StringVar(&credentialsProfile, "profile", cnCoreRgwUserUID, "Specify the name of the profile, remote or alias.")
+	cmd.Flags().StringVar(&credentialsUser, "user", cnCoreRgwUserUID, "Specify the user, created with 'cn-core user create'.")
+	cmd.Flags().StringVar(&credentialsTenant, "tenant", "", "Specify the tenant of the user.")
+	addConfigFlags(cmd.Flags())
+
+	return cmd
+}
+
+func printCredentialsCmd(cmd *cobra.Command, args []string) {
+	if err := validateCredentialsFormat(credentialsFormat); err != nil {
+		log.Fatal(err)
+	}
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+
+	creds, err := loadCredentials(userDetailsFile(credentialsTenant, credentialsUser))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := printCredentials(os.Stdout, credentialsFormat, newS3Export(credentialsProfile, creds)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func validateCredentialsFormat(format string) error {
+	for _, valid := range validValueCredentialsFormat {
+		if format == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("credentials: unknown format %q, valid choices are: %s", format, strings.Join(validValueCredentialsFormat, ", "))
+}
+
+func newS3Export(profile string, creds s3Credentials) s3Export {
+	export := s3Export{
+		Profile:   profile,
+		Endpoint:  rgwEndpoint(),
+		AccessKey: creds.AccessKey,
+		SecretKey: creds.SecretKey,
+	}
+	if conf.rgwTLS() {
+		_, _, export.CABundle = conf.rgwTLSFiles()
+	}
+	return export
+}
+
+// credentialsTemplates are the text formats of 'cn-core credentials', values are quoted where a shell reads them
+var credentialsTemplates = map[string]string{
+	"env": `export AWS_ACCESS_KEY_ID={{quote .AccessKey}}
+export AWS_SECRET_ACCESS_KEY={{quote .SecretKey}}
+export AWS_ENDPOINT_URL={{quote .Endpoint}}
+{{- if .CABundle}}
+export AWS_CA_BUNDLE={{quote .CABundle}}
+{{- end}}
+`,
+	"aws": `# aws --profile {{.Profile}} --endpoint-url {{.Endpoint}} s3 ls
+[{{.Profile}}]
+aws_access_key_id = {{.AccessKey}}
+aws_secret_access_key = {{.SecretKey}}
+`,
+	"rclone": `[{{.Profile}}]
+type = s3
+provider = Ceph
+access_key_id = {{.AccessKey}}
+secret_access_key = {{.SecretKey}}
+endpoint = {{.Endpoint}}
+`,
+	"mc": `mc alias set {{quote .Profile}} {{quote .Endpoint}} {{quote .AccessKey}} {{quote .SecretKey}}
+`,
+}
+
+// printCredentials writes the credentials in one of validValueCredentialsFormat
+func printCredentials(w io.Writer, format string, export s3Export) error {
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+		return nil
+	case "s3cmd":
+		// the template of /root/.s3cfg, pointed at the endpoint instead of the container hostname
+		ctx := clientContext{
+			S3Host:    strings.TrimPrefix(strings.TrimPrefix(export.Endpoint, "https://"), "http://"),
+			AccessKey: export.AccessKey,
+			SecretKey: export.SecretKey,
+			TLS:       strings.HasPrefix(export.Endpoint, "https://"),
+			CABundle:  export.CABundle,
+		}
+		return template.Must(template.New("s3cmd").Parse(s3cmdTemplate)).Execute(w, ctx)
+	}
+
+	text, ok := credentialsTemplates[format]
+	if !ok {
+		return validateCredentialsFormat(format)
+	}
+	var out bytes.Buffer
+	t := template.Must(template.New(format).Funcs(template.FuncMap{"quote": shellQuote}).Parse(text))
+	if err := t.Execute(&out, export); err != nil {
+		return err
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
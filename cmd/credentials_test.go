@@ -0,0 +1,122 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	path := filepath.Join(root, cnUserDetailsFile)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testUserCn), 0600))
+	creds, err := cnCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, s3Credentials{User: "cn", AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey"}, creds)
+
+	// none of these panic
+	for content, reason := range map[string]string{
+		testUserCn[:40]:                                    "failed to parse the user credentials",
+		`{"user_id": "cn", "keys": []}`:                    `user "cn" has no S3 key`,
+		`{"user_id": "cn", "keys": [{"access_key": "A"}]}`: `the first S3 key of user "cn"`,
+	} {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		_, err := cnCredentials()
+		assert.Contains(t, err.Error(), reason)
+	}
+
+	assert.Nil(t, os.Remove(path))
+	_, err = cnCredentials()
+	assert.Contains(t, err.Error(), "failed to read the user credentials")
+}
+
+func TestPrintCredentials(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.ExposedIP = "192.168.0.10"
+	export := newS3Export("nano", s3Credentials{User: "cn", AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cn/Secret'Key"})
+
+	for format, expected := range map[string]string{
+		"env": `export AWS_ACCESS_KEY_ID=CN4CCESSKEY0000000000
+export AWS_SECRET_ACCESS_KEY='cn/Secret'\''Key'
+export AWS_ENDPOINT_URL=http://192.168.0.10:8000
+`,
+		"json": `{
+  "profile": "nano",
+  "endpoint": "http://192.168.0.10:8000",
+  "access_key": "CN4CCESSKEY0000000000",
+  "secret_key": "cn/Secret'Key"
+}
+`,
+		"aws": `# aws --profile nano --endpoint-url http://192.168.0.10:8000 s3 ls
+[nano]
+aws_access_key_id = CN4CCESSKEY0000000000
+aws_secret_access_key = cn/Secret'Key
+`,
+		"rclone": `[nano]
+type = s3
+provider = Ceph
+access_key_id = CN4CCESSKEY0000000000
+secret_access_key = cn/Secret'Key
+endpoint = http://192.168.0.10:8000
+`,
+		"s3cmd": `[default]
+access_key = CN4CCESSKEY0000000000
+secret_key = cn/Secret'Key
+host_base = 192.168.0.10:8000
+host_bucket = 192.168.0.10:8000
+use_https = False
+`,
+		"mc": `mc alias set nano http://192.168.0.10:8000 CN4CCESSKEY0000000000 'cn/Secret'\''Key'
+`,
+	} {
+		var out bytes.Buffer
+		assert.Nil(t, printCredentials(&out, format, export), format)
+		assert.Equal(t, expected, out.String(), format)
+	}
+
+	var out bytes.Buffer
+	assert.EqualError(t, printCredentials(&out, "yaml", export), `credentials: unknown format "yaml", valid choices are: env, json, aws, rclone, s3cmd, mc`)
+
+	// with TLS the clients trust the local CA
+	conf.RgwTLS = "true"
+	export = newS3Export("nano", s3Credentials{AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey"})
+	out.Reset()
+	assert.Nil(t, printCredentials(&out, "env", export))
+	assert.Equal(t, `export AWS_ACCESS_KEY_ID=CN4CCESSKEY0000000000
+export AWS_SECRET_ACCESS_KEY=cnSecretKey
+export AWS_ENDPOINT_URL=https://192.168.0.10:8443
+export AWS_CA_BUNDLE=/etc/ceph/tls/ca.crt
+`, out.String())
+	out.Reset()
+	assert.Nil(t, printCredentials(&out, "s3cmd", export))
+	assert.Contains(t, out.String(), "host_base = 192.168.0.10:8443\nhost_bucket = 192.168.0.10:8443\nuse_https = True\nca_certs_file = /etc/ceph/tls/ca.crt\n")
+}
@@ -0,0 +1,150 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ceph/cn-core/internal/ceph"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// adminSocketDir is where the daemons create their admin socket, the run dir of ceph
+	adminSocketDir = "/var/run/ceph"
+)
+
+var (
+	daemonTimeout int
+)
+
+// cliDaemon is the Cobra CLI call
+func cliDaemon() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon <mon|mgr|osd.N|rgw> <command...>",
+		Short: "Send a command to the admin socket of a daemon",
+		Long: `Send a command to the admin socket of a daemon, without going through the monitors.
+
+The words of the command make its prefix, e.g: perf dump, and the name=value words its arguments, e.g: var=osd_memory_target.
+'help' lists the commands the daemon knows.`,
+		Args:    cobra.MinimumNArgs(2),
+		Run:     daemonCommand,
+		Example: "cn-core daemon osd.0 perf dump\ncn-core daemon mon config get var=mon_allow_pool_delete\n",
+	}
+	cmd.Flags().IntVar(&daemonTimeout, "timeout", 5, "Seconds to wait for the daemon to answer.")
+
+	return cmd
+}
+
+// daemonCommand prints the answer of the daemon
+func daemonCommand(cmd *cobra.Command, args []string) {
+	if err := sendDaemonCommand(os.Stdout, args[0], args[1:], time.Duration(daemonTimeout)*time.Second); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// sendDaemonCommand sends the words of a command to a daemon and writes its answer, indented when it is JSON
+func sendDaemonCommand(w io.Writer, name string, words []string, timeout time.Duration) error {
+	entity, err := daemonEntity(name)
+	if err != nil {
+		return err
+	}
+	prefix, args := parseDaemonCommand(words)
+
+	out, err := adminSocket(entity, timeout).Command(prefix, args)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, out, "", "  ") == nil {
+		out = indented.Bytes()
+	}
+	_, err = fmt.Fprintln(w, strings.TrimRight(string(out), "\n"))
+	return err
+}
+
+// daemonEntity returns the entity a daemon runs as, the mon, the mgr and rgw are named after the host
+// Full entity names, e.g: mon.vm or client.rgw.vm, are taken as they are
+func daemonEntity(name string) (string, error) {
+	switch name {
+	case "mon", "mgr", "rgw":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		if name == "rgw" {
+			return "client.rgw." + hostname, nil
+		}
+		return name + "." + hostname, nil
+	}
+
+	if id := strings.TrimPrefix(name, "osd."); id != name {
+		if _, err := strconv.Atoi(id); err == nil {
+			return name, nil
+		}
+	}
+	for _, prefix := range []string{"mon.", "mgr.", "client.rgw."} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("daemon: unknown daemon %q, valid choices are: mon, mgr, osd.N, rgw", name)
+}
+
+// parseDaemonCommand splits the words of a command between its prefix and its name=value arguments
+func parseDaemonCommand(words []string) (string, map[string]interface{}) {
+	var prefix []string
+	args := map[string]interface{}{}
+	for _, word := range words {
+		if i := strings.Index(word, "="); i > 0 {
+			args[word[:i]] = word[i+1:]
+			continue
+		}
+		prefix = append(prefix, word)
+	}
+	return strings.Join(prefix, " "), args
+}
+
+// adminSocket returns the admin socket of a daemon, it is named after the cluster and the entity
+func adminSocket(entity string, timeout time.Duration) *ceph.AdminSocket {
+	return ceph.NewAdminSocket(hostPath(filepath.Join(adminSocketDir, "ceph-"+entity+".asok")), timeout)
+}
+
+// osdAdminSockets returns the names of the OSDs with an admin socket, e.g: osd.0
+func osdAdminSockets() []string {
+	paths, _ := filepath.Glob(hostPath(filepath.Join(adminSocketDir, "ceph-osd.*.asok")))
+	var names []string
+	for _, path := range paths {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "ceph-"), ".asok"))
+	}
+	return names
+}
@@ -0,0 +1,149 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveTestAdminSocket serves the admin socket of a daemon under the test root, it knows version and perf dump
+// Like a daemon that crashed, the socket is left behind once closed
+func serveTestAdminSocket(t *testing.T, root, entity string) func() {
+	path := filepath.Join(root, adminSocketDir, "ceph-"+entity+".asok")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	assert.Nil(t, err)
+	listener.SetUnlinkOnClose(false)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, err := bufio.NewReader(conn).ReadBytes(0)
+			var request map[string]string
+			if err == nil {
+				err = json.Unmarshal(line[:len(line)-1], &request)
+			}
+			var answer string
+			switch {
+			case err != nil:
+			case request["prefix"] == "version":
+				answer = `{"version":"14.2.8"}`
+			case request["prefix"] == "perf dump":
+				answer = `{"osd":{"op":12}}`
+			case request["prefix"] == "config get":
+				answer = `{"` + request["var"] + `":"4294967296"}`
+			}
+			if answer != "" {
+				binary.Write(conn, binary.BigEndian, uint32(len(answer)))
+				conn.Write([]byte(answer))
+			}
+			conn.Close()
+		}
+	}()
+	return func() { listener.Close() }
+}
+
+func TestDaemonEntity(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+
+	for name, want := range map[string]string{
+		"mon":           "mon." + hostname,
+		"mgr":           "mgr." + hostname,
+		"rgw":           "client.rgw." + hostname,
+		"osd.3":         "osd.3",
+		"mon.vm":        "mon.vm",
+		"client.rgw.vm": "client.rgw.vm",
+	} {
+		entity, err := daemonEntity(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, want, entity)
+	}
+	for _, name := range []string{"osd", "osd.x", "mds", "mon."} {
+		_, err := daemonEntity(name)
+		assert.EqualError(t, err, `daemon: unknown daemon "`+name+`", valid choices are: mon, mgr, osd.N, rgw`)
+	}
+
+	prefix, args := parseDaemonCommand([]string{"config", "get", "var=osd_memory_target"})
+	assert.Equal(t, "config get", prefix)
+	assert.Equal(t, map[string]interface{}{"var": "osd_memory_target"}, args)
+}
+
+func TestSendDaemonCommand(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+	defer serveTestAdminSocket(t, root, "osd.0")()
+
+	var out bytes.Buffer
+	assert.Nil(t, sendDaemonCommand(&out, "osd.0", []string{"config", "get", "var=osd_memory_target"}, time.Second))
+	assert.Equal(t, "{\n  \"osd_memory_target\": \"4294967296\"\n}\n", out.String())
+
+	err := sendDaemonCommand(&out, "osd.1", []string{"perf", "dump"}, time.Second)
+	assert.Contains(t, err.Error(), root+"/var/run/ceph/ceph-osd.1.asok perf dump: dial unix")
+}
+
+func TestLivezAdminSockets(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+	defer serveTestAdminSocket(t, root, "mon."+hostname)()
+	defer serveTestAdminSocket(t, root, "osd.0")()
+
+	p := newReadinessProbes()
+	p.expect("mon", "osd", "rgw")
+	p.markBootstrapped("mon")
+	p.markBootstrapped("osd")
+	assert.Equal(t, []string{"mon", "osd.0"}, p.liveDaemons())
+	code, body := getProbe(t, p, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	// a daemon that died leaves its socket behind, nothing answers on it anymore
+	serveTestAdminSocket(t, root, "osd.1")()
+	code, body = getProbe(t, p, "/livez")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "[-]osd.1 not alive: ")
+	assert.Contains(t, body, "connection refused")
+
+	// rgw is checked once bootstrapped, it has no socket yet
+	assert.Nil(t, os.Remove(filepath.Join(root, adminSocketDir, "ceph-osd.1.asok")))
+	p.markBootstrapped("rgw")
+	code, body = getProbe(t, p, "/livez")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "[-]rgw not alive: ")
+}
@@ -0,0 +1,507 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver"
+	"github.com/spf13/cobra"
+)
+
+// dumpVersionFormat names the previous versions of an object in the order they were written, e.g: 000001
+const dumpVersionFormat = "%06d"
+
+var (
+	dumpOut      string
+	dumpAllUsers bool
+)
+
+// dumpReport counts what a dump wrote
+type dumpReport struct {
+	Buckets  int
+	Objects  int
+	Versions int
+	Bytes    int64
+	Skipped  int
+}
+
+func (r *dumpReport) String() string {
+	return fmt.Sprintf("%d bucket(s), %d object(s), %d previous version(s) (%s), %d skipped",
+		r.Buckets, r.Objects, r.Versions, formatBytes(uint64(r.Bytes)), r.Skipped)
+}
+
+// accessControlPolicy is the part of an ACL document needed to tell if it is the default one
+type accessControlPolicy struct {
+	Owner struct {
+		ID string `xml:"ID"`
+	} `xml:"Owner"`
+	Grants []struct {
+		Grantee struct {
+			ID string `xml:"ID"`
+		} `xml:"Grantee"`
+		Permission string `xml:"Permission"`
+	} `xml:"AccessControlList>Grant"`
+}
+
+// cliS3 is the Cobra CLI call
+func cliS3() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "s3",
+		Short: "Work with the buckets and objects of Rados Gateway",
+		Args:  cobra.NoArgs,
+	}
+
+	dump := &cobra.Command{
+		Use:   "dump",
+		Short: "Snapshot the buckets in the layout of the seed directory",
+		Args:  cobra.NoArgs,
+		Run:   dumpS3Cmd,
+		Example: `cn-core s3 dump --out /tmp/seed
+cn-core s3 dump --out /tmp/s3.tar.gz --all-users
+`,
+	}
+	dump.Flags().SortFlags = false
+	dump.Flags().StringVar(&dumpOut, "out", "", "Write to this directory, it must be empty, or to an archive when it ends with .tar, .tar.gz or .tgz.")
+	dump.Flags().BoolVar(&dumpAllUsers, "all-users", false, "Dump the buckets of every user instead of the ones of the cn user.")
+	addConfigFlags(dump.Flags())
+
+	cmd.AddCommand(dump)
+	return cmd
+}
+
+func dumpS3Cmd(cmd *cobra.Command, args []string) {
+	if dumpOut == "" {
+		log.Fatal("s3 dump: --out is required")
+	}
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+
+	owners, err := dumpOwners(dumpAllUsers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := newDumpWriter(dumpOut)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := dumpS3(owners, w)
+	if err == nil {
+		err = w.commit()
+	}
+	if err != nil {
+		w.abort()
+		log.Fatal(err)
+	}
+	log.Printf("s3 dump: %s written to %s", report, dumpOut)
+}
+
+// dumpOwners returns the credentials of the users whose buckets are dumped
+// The Admin Ops API gives the keys of every user, a user without key has no bucket to dump
+func dumpOwners(allUsers bool) ([]s3Credentials, error) {
+	if !allUsers {
+		creds, err := cnCredentials()
+		if err != nil {
+			return nil, err
+		}
+		return []s3Credentials{creds}, nil
+	}
+
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return nil, err
+	}
+	uids, err := admin.users()
+	if err != nil {
+		return nil, fmt.Errorf("s3 dump: failed to list the users: %v", err)
+	}
+
+	var owners []s3Credentials
+	for _, uid := range uids {
+		info, err := admin.user(uid)
+		if err != nil {
+			return nil, fmt.Errorf("s3 dump: failed to get user %s: %v", uid, err)
+		}
+		if len(info.Keys) == 0 || info.Keys[0].SecretKey == "" {
+			log.Printf("s3 dump: skipping user %s, it has no S3 key", uid)
+			continue
+		}
+		owners = append(owners, s3Credentials{User: info.UserID, AccessKey: info.Keys[0].AccessKey, SecretKey: info.Keys[0].SecretKey})
+	}
+	return owners, nil
+}
+
+// dumpS3 writes the buckets of the owners, the first failure stops the dump
+// Buckets of different tenants may share a name, but not a directory of the dump
+func dumpS3(owners []s3Credentials, w dumpWriter) (*dumpReport, error) {
+	report := &dumpReport{}
+	owned := map[string]string{}
+	for _, owner := range owners {
+		client := localS3Client(owner)
+		buckets, err := client.listBuckets()
+		if err != nil {
+			return nil, fmt.Errorf("s3 dump: failed to list the buckets of %s: %v", owner.User, err)
+		}
+		for _, bucket := range buckets {
+			if other, ok := owned[bucket]; ok {
+				return nil, fmt.Errorf("s3 dump: bucket %s is owned by both %s and %s", bucket, other, owner.User)
+			}
+			owned[bucket] = owner.User
+			if err := dumpBucket(client, owner.User, bucket, w, report); err != nil {
+				return nil, fmt.Errorf("s3 dump: bucket %s: %v", bucket, err)
+			}
+			report.Buckets++
+		}
+	}
+	return report, nil
+}
+
+// dumpBucket writes the settings of a bucket next to its directory, then its objects
+func dumpBucket(client *s3Client, owner, bucket string, w dumpWriter, report *dumpReport) error {
+	settings := seedBucket{Owner: owner}
+	doc, err := client.getDocument(bucket, "", "", "versioning")
+	if err != nil {
+		return fmt.Errorf("versioning: %v", err)
+	}
+	if len(doc) > 0 {
+		var versioning versioningConfiguration
+		if err := xml.Unmarshal(doc, &versioning); err != nil {
+			return fmt.Errorf("failed to parse the versioning: %v", err)
+		}
+		settings.Versioning = versioning.Status
+	}
+	for _, doc := range []struct {
+		subresource string
+		content     *string
+	}{
+		{"policy", &settings.Policy}, {"acl", &settings.ACL}, {"cors", &settings.CORS}, {"lifecycle", &settings.Lifecycle},
+	} {
+		content, err := client.getDocument(bucket, "", "", doc.subresource)
+		if err != nil {
+			return fmt.Errorf("%s: %v", doc.subresource, err)
+		}
+		*doc.content = string(content)
+	}
+	if isDefaultACL([]byte(settings.ACL)) {
+		settings.ACL = ""
+	}
+
+	content, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.writeFile(bucket+seedBucketSuffix, time.Now(), int64(len(content)), bytes.NewReader(content)); err != nil {
+		return err
+	}
+	// an empty bucket is still a directory
+	if err := w.mkdir(bucket); err != nil {
+		return err
+	}
+
+	versions, err := client.listObjectVersions(bucket)
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{}
+	for start := 0; start < len(versions); {
+		end := start + 1
+		for end < len(versions) && versions[end].Key == versions[start].Key {
+			end++
+		}
+		if err := dumpObject(client, bucket, versions[start:end], files, w, report); err != nil {
+			return fmt.Errorf("%s: %v", versions[start].Key, err)
+		}
+		start = end
+	}
+	return nil
+}
+
+// dumpObject writes the versions of a key, from the newest
+// The previous versions go in the versions directory of the bucket, from the oldest, the delete markers are lost
+func dumpObject(client *s3Client, bucket string, versions []objectVersion, files map[string]bool, w dumpWriter, report *dumpReport) error {
+	key := versions[0].Key
+	reason := unsafeSeedKey(key, files)
+	if reason == "" && versions[0].DeleteMarker {
+		reason = "it was deleted"
+	}
+	if reason != "" {
+		log.Printf("s3 dump: skipping %s/%s, %s", bucket, key, reason)
+		report.Skipped++
+		return nil
+	}
+
+	var previous []objectVersion
+	for i := len(versions) - 1; i > 0; i-- {
+		if !versions[i].DeleteMarker {
+			previous = append(previous, versions[i])
+		}
+	}
+	for i, version := range previous {
+		size, err := dumpObjectVersion(client, bucket, version, path.Join(bucket+seedVersionsSuffix, key, fmt.Sprintf(dumpVersionFormat, i+1)), w)
+		if err != nil {
+			return err
+		}
+		report.Versions++
+		report.Bytes += size
+	}
+
+	size, err := dumpObjectVersion(client, bucket, versions[0], path.Join(bucket, key), w)
+	if err != nil {
+		return err
+	}
+	files[key] = true
+	report.Objects++
+	report.Bytes += size
+	return nil
+}
+
+// dumpObjectVersion writes a version of an object and its sidecar, it returns the size of the object
+func dumpObjectVersion(client *s3Client, bucket string, version objectVersion, name string, w dumpWriter) (int64, error) {
+	// the latest version is read without its id, the version of an unversioned bucket is "null"
+	versionID := version.VersionID
+	if version.IsLatest {
+		versionID = ""
+	}
+	acl, err := client.getDocument(bucket, version.Key, versionID, "acl")
+	if err != nil {
+		return 0, fmt.Errorf("acl: %v", err)
+	}
+	resp, err := client.getObject(bucket, version.Key, versionID)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	meta := seedMeta{
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
+		CacheControl:    resp.Header.Get("Cache-Control"),
+		LastModified:    version.LastModified.UTC().Format(time.RFC3339),
+	}
+	if version.VersionID != "null" {
+		meta.VersionID = version.VersionID
+	}
+	if !isDefaultACL(acl) {
+		meta.ACL = string(acl)
+	}
+	for header, values := range resp.Header {
+		if strings.HasPrefix(header, "X-Amz-Meta-") {
+			if meta.Metadata == nil {
+				meta.Metadata = map[string]string{}
+			}
+			meta.Metadata[strings.ToLower(strings.TrimPrefix(header, "X-Amz-Meta-"))] = values[0]
+		}
+	}
+
+	size := resp.ContentLength
+	if size < 0 {
+		size = version.Size
+	}
+	if err := w.writeFile(name, version.LastModified, size, resp.Body); err != nil {
+		return 0, err
+	}
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return size, w.writeFile(name+seedMetaSuffix, version.LastModified, int64(len(content)), bytes.NewReader(content))
+}
+
+// unsafeSeedKey tells why a key cannot be a file of the seed directory, it is empty when it can
+// files are the keys already written, a key cannot be below one of them
+func unsafeSeedKey(key string, files map[string]bool) string {
+	switch {
+	case strings.HasSuffix(key, "/"):
+		return "it is a directory marker"
+	case strings.HasSuffix(key, seedMetaSuffix):
+		return "it would be read as a sidecar"
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "it is not a relative path"
+		}
+		if prefix := strings.Join(segments[:i], "/"); i > 0 && files[prefix] {
+			return "it is below the object " + prefix
+		}
+	}
+	return ""
+}
+
+// isDefaultACL tells if an ACL document only grants full control to the owner, what every bucket and object gets
+func isDefaultACL(doc []byte) bool {
+	if len(doc) == 0 {
+		return true
+	}
+	var acl accessControlPolicy
+	if err := xml.Unmarshal(doc, &acl); err != nil {
+		return false
+	}
+	return len(acl.Grants) == 1 && acl.Grants[0].Grantee.ID == acl.Owner.ID && acl.Grants[0].Permission == "FULL_CONTROL"
+}
+
+// dumpWriter writes the files of a dump, names are slash separated
+type dumpWriter interface {
+	mkdir(name string) error
+	writeFile(name string, modTime time.Time, size int64, r io.Reader) error
+	// commit makes the dump complete, abort removes what an archive wrote so far
+	commit() error
+	abort()
+}
+
+// newDumpWriter picks the writer from the name of the output, anything but an archive is a directory
+func newDumpWriter(out string) (dumpWriter, error) {
+	var archive archiver.Writer
+	switch {
+	case strings.HasSuffix(out, ".tar"):
+		archive = archiver.NewTar()
+	case strings.HasSuffix(out, ".tar.gz"), strings.HasSuffix(out, ".tgz"):
+		archive = archiver.NewTarGz()
+	default:
+		entries, err := ioutil.ReadDir(out)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("s3 dump: %v", err)
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("s3 dump: %s is not empty", out)
+		}
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return nil, fmt.Errorf("s3 dump: %v", err)
+		}
+		return dirDumpWriter(out), nil
+	}
+
+	// like backups, the archive only gets its name once complete
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("s3 dump: %v", err)
+	}
+	if err := archive.Create(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("s3 dump: %v", err)
+	}
+	return &archiveDumpWriter{path: out, file: f, archive: archive}, nil
+}
+
+// dirDumpWriter writes below a directory, an aborted dump is left as is
+type dirDumpWriter string
+
+func (d dirDumpWriter) mkdir(name string) error {
+	return os.MkdirAll(filepath.Join(string(d), filepath.FromSlash(name)), 0755)
+}
+
+func (d dirDumpWriter) writeFile(name string, modTime time.Time, size int64, r io.Reader) error {
+	target := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+func (d dirDumpWriter) commit() error { return nil }
+func (d dirDumpWriter) abort()        {}
+
+// archiveDumpWriter writes a tar archive, compressed or not
+type archiveDumpWriter struct {
+	path    string
+	file    *os.File
+	archive archiver.Writer
+}
+
+func (a *archiveDumpWriter) mkdir(name string) error {
+	return a.archive.Write(archiver.File{FileInfo: dumpFileInfo{name: name, dir: true, modTime: time.Now()}})
+}
+
+func (a *archiveDumpWriter) writeFile(name string, modTime time.Time, size int64, r io.Reader) error {
+	return a.archive.Write(archiver.File{
+		FileInfo:   dumpFileInfo{name: name, size: size, modTime: modTime},
+		ReadCloser: ioutil.NopCloser(r),
+	})
+}
+
+func (a *archiveDumpWriter) commit() error {
+	if err := a.archive.Close(); err != nil {
+		return fmt.Errorf("s3 dump: %v", err)
+	}
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("s3 dump: %v", err)
+	}
+	if err := os.Rename(a.file.Name(), a.path); err != nil {
+		return fmt.Errorf("s3 dump: %v", err)
+	}
+	return nil
+}
+
+func (a *archiveDumpWriter) abort() {
+	a.archive.Close()
+	a.file.Close()
+	os.Remove(a.file.Name())
+}
+
+// dumpFileInfo describes a file of the archive, which is not a file on disk
+type dumpFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (d dumpFileInfo) Name() string       { return d.name }
+func (d dumpFileInfo) Size() int64        { return d.size }
+func (d dumpFileInfo) ModTime() time.Time { return d.modTime }
+func (d dumpFileInfo) IsDir() bool        { return d.dir }
+func (d dumpFileInfo) Sys() interface{}   { return nil }
+
+func (d dumpFileInfo) Mode() os.FileMode {
+	if d.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,230 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPublicACL = `<AccessControlPolicy><Owner><ID>cn</ID></Owner><AccessControlList>` +
+	`<Grant><Grantee><ID>cn</ID></Grantee><Permission>FULL_CONTROL</Permission></Grant>` +
+	`<Grant><Grantee><URI>http://acs.amazonaws.com/groups/global/AllUsers</URI></Grantee><Permission>READ</Permission></Grant>` +
+	`</AccessControlList></AccessControlPolicy>`
+
+var testCnCredentials = s3Credentials{User: "cn", AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey"}
+
+func TestDumpS3(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	fake.pageSize = 2
+
+	client := localS3Client(testCnCredentials)
+	assert.Nil(t, createBucket(client, "photos", false))
+	assert.Nil(t, client.putXML("photos", "versioning", versioningConfiguration{XMLNS: s3XMLNS, Status: "Enabled"}))
+	assert.Nil(t, client.putDocument("photos", "", "policy", []byte(`{"Version": "2012-10-17"}`)))
+	jpeg := http.Header{"Content-Type": {"image/jpeg"}, "X-Amz-Meta-Author": {"alice"}}
+	assert.Nil(t, client.putObject("photos", "cat.jpg", jpeg, []byte("meow")))
+	assert.Nil(t, client.putObject("photos", "cat.jpg", jpeg, []byte("purr")))
+	assert.Nil(t, client.putDocument("photos", "cat.jpg", "acl", []byte(testPublicACL)))
+	assert.Nil(t, client.putObject("photos", "docs/read me.txt", http.Header{"Content-Type": {"text/plain"}}, []byte("hello")))
+	// none of these can be reloaded
+	assert.Nil(t, client.putObject("photos", "gone.txt", nil, []byte("bye")))
+	fake.store("photos", "gone.txt", &fakeObject{deleteMarker: true})
+	assert.Nil(t, client.putObject("photos", "albums/", nil, nil))
+	assert.Nil(t, client.putObject("photos", "cat.jpg/whiskers", nil, []byte("?")))
+	assert.Nil(t, createBucket(client, "empty", false))
+	// only the buckets of cn are dumped
+	assert.Nil(t, createBucket(localS3Client(s3Credentials{AccessKey: "ALICEACCESSKEY000000"}), "invoices", false))
+
+	w, err := newDumpWriter(filepath.Join(root, "seed"))
+	assert.Nil(t, err)
+	report, err := dumpS3([]s3Credentials{testCnCredentials}, w)
+	assert.Nil(t, err)
+	assert.Nil(t, w.commit())
+	fake.close()
+	assert.Equal(t, "2 bucket(s), 2 object(s), 1 previous version(s) (13B), 3 skipped", report.String())
+
+	assert.Equal(t, "purr", readTestFile(t, root, "/seed/photos/cat.jpg"))
+	assert.Equal(t, "meow", readTestFile(t, root, "/seed/photos.cn-versions/cat.jpg/000001"))
+	assert.Equal(t, "hello", readTestFile(t, root, "/seed/photos/docs/read me.txt"))
+	info, err := os.Stat(filepath.Join(root, "/seed/photos/cat.jpg"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 2, 0, time.UTC), info.ModTime().UTC())
+	info, err = os.Stat(filepath.Join(root, "/seed/empty"))
+	assert.True(t, err == nil && info.IsDir())
+	_, err = os.Stat(filepath.Join(root, "/seed/invoices"))
+	assert.True(t, os.IsNotExist(err))
+
+	var meta seedMeta
+	assert.Nil(t, json.Unmarshal([]byte(readTestFile(t, root, "/seed/photos/cat.jpg.cn-meta.json")), &meta))
+	assert.Equal(t, seedMeta{ContentType: "image/jpeg", Metadata: map[string]string{"author": "alice"}, ACL: testPublicACL,
+		VersionID: "v2", LastModified: "2019-01-01T00:00:02Z"}, meta)
+	var settings seedBucket
+	assert.Nil(t, json.Unmarshal([]byte(readTestFile(t, root, "/seed/photos.cn-bucket.json")), &settings))
+	assert.Equal(t, seedBucket{Owner: "cn", Versioning: "Enabled", Policy: `{"Version": "2012-10-17"}`}, settings)
+
+	// the seed importer loads the dump back, with the previous versions and the settings
+	reloaded := newFakeS3(t)
+	defer reloaded.close()
+	client = localS3Client(testCnCredentials)
+	seedReport, err := importSeed(client, "/seed")
+	assert.Nil(t, err)
+	assert.Equal(t, "2 object(s) uploaded (9B), 0 already present, 0 failed", seedReport.String())
+	assert.True(t, reloaded.buckets["empty"])
+	assert.Equal(t, `{"Version": "2012-10-17"}`, reloaded.docs["photos?policy"])
+	cat := reloaded.objects["photos/cat.jpg"]
+	assert.Equal(t, "purr", string(cat.data))
+	assert.Equal(t, "alice", cat.header.Get("X-Amz-Meta-Author"))
+	assert.Equal(t, testPublicACL, cat.acl)
+	if assert.Len(t, reloaded.versions["photos/cat.jpg"], 1) {
+		assert.Equal(t, "meow", string(reloaded.versions["photos/cat.jpg"][0].data))
+	}
+
+	seedReport, err = importSeed(client, "/seed")
+	assert.Nil(t, err)
+	assert.Equal(t, "0 object(s) uploaded (0B), 2 already present, 0 failed", seedReport.String())
+	assert.Len(t, reloaded.versions["photos/cat.jpg"], 1)
+}
+
+func TestDumpS3Archive(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	_, err := createUser("alice", userOptions{tenant: "acme"}, false)
+	assert.Nil(t, err)
+	fake.users["nokey"] = &rgwUserInfo{UserID: "nokey"}
+
+	owners, err := dumpOwners(true)
+	assert.Nil(t, err)
+	assert.Equal(t, []s3Credentials{{User: "acme$alice", AccessKey: "ALICEACCESSKEY000000", SecretKey: "aliceSecret"}, testCnCredentials}, owners)
+
+	assert.Nil(t, createBucket(localS3Client(owners[1]), "photos", false))
+	assert.Nil(t, localS3Client(owners[1]).putObject("photos", "cat.jpg", nil, []byte("meow")))
+	assert.Nil(t, createBucket(localS3Client(owners[0]), "invoices", false))
+	assert.Nil(t, localS3Client(owners[0]).putObject("invoices", "2019/01.pdf", nil, []byte("%PDF")))
+
+	archive := filepath.Join(root, "s3.tar.gz")
+	w, err := newDumpWriter(archive)
+	assert.Nil(t, err)
+	report, err := dumpS3(owners, w)
+	assert.Nil(t, err)
+	assert.Nil(t, w.commit())
+	assert.Equal(t, "2 bucket(s), 2 object(s), 0 previous version(s) (8B), 0 skipped", report.String())
+	_, err = os.Stat(archive + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	f, err := os.Open(archive)
+	assert.Nil(t, err)
+	defer f.Close()
+	tgz := newTestTarGz(t, f)
+	var names []string
+	for {
+		file, err := tgz.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		names = append(names, file.Header.(*tar.Header).Name)
+	}
+	assert.Equal(t, []string{
+		"invoices.cn-bucket.json", "invoices/", "invoices/2019/01.pdf", "invoices/2019/01.pdf.cn-meta.json",
+		"photos.cn-bucket.json", "photos/", "photos/cat.jpg", "photos/cat.jpg.cn-meta.json",
+	}, names)
+
+	// a directory is only written when it is empty
+	_, err = newDumpWriter(root)
+	assert.EqualError(t, err, "s3 dump: "+root+" is not empty")
+
+	// the buckets are reloaded by their owner, who is created again in a fresh cluster
+	w, err = newDumpWriter(filepath.Join(root, "seed"))
+	assert.Nil(t, err)
+	_, err = dumpS3(owners, w)
+	assert.Nil(t, err)
+	assert.Nil(t, w.commit())
+	fake.close()
+	assert.Nil(t, os.RemoveAll(filepath.Join(root, cnUsersDir)))
+
+	reloaded := newFakeS3(t)
+	defer reloaded.close()
+	seedReport, err := importSeed(localS3Client(testCnCredentials), "/seed")
+	assert.Nil(t, err)
+	assert.Equal(t, "2 object(s) uploaded (8B), 0 already present, 0 failed", seedReport.String())
+	assert.Equal(t, "ALICEACCESSKEY000000", reloaded.owners["invoices"])
+	assert.Equal(t, testCnCredentials.AccessKey, reloaded.owners["photos"])
+	assert.Equal(t, "%PDF", string(reloaded.objects["invoices/2019/01.pdf"].data))
+}
+
+func TestDumpS3GzipObject(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(bytes.Repeat([]byte("meow "), 240))
+	assert.Nil(t, err)
+	assert.Nil(t, gz.Close())
+
+	client := localS3Client(testCnCredentials)
+	assert.Nil(t, createBucket(client, "logs", false))
+	header := http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}}
+	assert.Nil(t, client.putObject("logs", "cat.log", header, gzipped.Bytes()))
+
+	// the object is dumped as stored, the archive header is sized from the listing
+	for _, out := range []string{"seed", "logs.tar.gz"} {
+		w, err := newDumpWriter(filepath.Join(root, out))
+		assert.Nil(t, err)
+		report, err := dumpS3([]s3Credentials{testCnCredentials}, w)
+		assert.Nil(t, err, out)
+		assert.Nil(t, w.commit())
+		assert.Equal(t, fmt.Sprintf("1 bucket(s), 1 object(s), 0 previous version(s) (%dB), 0 skipped", gzipped.Len()), report.String())
+	}
+	assert.Equal(t, gzipped.String(), readTestFile(t, root, "/seed/logs/cat.log"))
+	var meta seedMeta
+	assert.Nil(t, json.Unmarshal([]byte(readTestFile(t, root, "/seed/logs/cat.log.cn-meta.json")), &meta))
+	assert.Equal(t, "gzip", meta.ContentEncoding)
+
+	// and reloaded as stored
+	reloaded := newFakeS3(t)
+	defer reloaded.close()
+	_, err = importSeed(localS3Client(testCnCredentials), "/seed")
+	assert.Nil(t, err)
+	cat := reloaded.objects["logs/cat.log"]
+	assert.Equal(t, gzipped.Bytes(), cat.data)
+	assert.Equal(t, "gzip", cat.header.Get("Content-Encoding"))
+}
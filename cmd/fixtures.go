@@ -0,0 +1,374 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const s3XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// fixturesFile lists the buckets a test suite expects, see the fixtures section of the README
+type fixturesFile struct {
+	Buckets []bucketFixture `yaml:"buckets"`
+}
+
+type bucketFixture struct {
+	Name       string             `yaml:"name"`
+	Owner      string             `yaml:"owner"`
+	Tenant     string             `yaml:"tenant"`
+	Versioning string             `yaml:"versioning"`
+	ObjectLock *objectLockFixture `yaml:"object_lock"`
+	CORS       []corsRule         `yaml:"cors"`
+	Policy     string             `yaml:"policy"`
+	Lifecycle  []lifecycleFixture `yaml:"lifecycle"`
+}
+
+// objectLockFixture enables object lock, with a default retention when mode is set
+type objectLockFixture struct {
+	Mode  string `yaml:"mode"`
+	Days  int    `yaml:"days"`
+	Years int    `yaml:"years"`
+}
+
+// corsRule is both the fixture and the S3 document
+type corsRule struct {
+	ID             string   `yaml:"id" xml:"ID,omitempty"`
+	AllowedOrigins []string `yaml:"allowed_origins" xml:"AllowedOrigin"`
+	AllowedMethods []string `yaml:"allowed_methods" xml:"AllowedMethod"`
+	AllowedHeaders []string `yaml:"allowed_headers" xml:"AllowedHeader"`
+	ExposeHeaders  []string `yaml:"expose_headers" xml:"ExposeHeader"`
+	MaxAgeSeconds  int      `yaml:"max_age_seconds" xml:"MaxAgeSeconds,omitempty"`
+}
+
+type lifecycleFixture struct {
+	ID                           string `yaml:"id"`
+	Prefix                       string `yaml:"prefix"`
+	Disabled                     bool   `yaml:"disabled"`
+	ExpirationDays               int    `yaml:"expiration_days"`
+	NoncurrentExpirationDays     int    `yaml:"noncurrent_expiration_days"`
+	AbortIncompleteMultipartDays int    `yaml:"abort_incomplete_multipart_days"`
+}
+
+// S3 documents of the bucket settings
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Status  string   `xml:"Status"`
+}
+
+type objectLockConfiguration struct {
+	XMLName           xml.Name          `xml:"ObjectLockConfiguration"`
+	XMLNS             string            `xml:"xmlns,attr"`
+	ObjectLockEnabled string            `xml:"ObjectLockEnabled"`
+	DefaultRetention  *defaultRetention `xml:"Rule>DefaultRetention,omitempty"`
+}
+
+type defaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	XMLNS   string     `xml:"xmlns,attr"`
+	Rules   []corsRule `xml:"CORSRule"`
+}
+
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type lifecycleRule struct {
+	ID                             string          `xml:"ID,omitempty"`
+	Prefix                         string          `xml:"Filter>Prefix"`
+	Status                         string          `xml:"Status"`
+	Expiration                     *lifecycleDays  `xml:"Expiration,omitempty"`
+	NoncurrentVersionExpiration    *noncurrentDays `xml:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *abortDays      `xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+type lifecycleDays struct {
+	Days int `xml:"Days"`
+}
+
+type noncurrentDays struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+}
+
+type abortDays struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// fixtureResult is the outcome of one setting of one bucket
+type fixtureResult struct {
+	Bucket string
+	Item   string
+	Err    error
+}
+
+type fixtureReport []fixtureResult
+
+func (r fixtureReport) failures() fixtureReport {
+	var failed fixtureReport
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r fixtureReport) String() string {
+	var b strings.Builder
+	for _, result := range r {
+		status := "ok"
+		if result.Err != nil {
+			status = result.Err.Error()
+		}
+		fmt.Fprintf(&b, "  %s: %s: %s\n", result.Bucket, result.Item, status)
+	}
+	return b.String()
+}
+
+// loadFixtures reads and validates a fixtures file
+func loadFixtures(path string) (fixturesFile, error) {
+	var fixtures fixturesFile
+	content, err := ioutil.ReadFile(hostPath(path))
+	if err != nil {
+		return fixtures, fmt.Errorf("fixtures: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(content, &fixtures); err != nil {
+		return fixtures, fmt.Errorf("fixtures: failed to parse %s: %v", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, b := range fixtures.Buckets {
+		if err := b.validate(); err != nil {
+			return fixtures, fmt.Errorf("fixtures: bucket #%d %q: %v", i+1, b.Name, err)
+		}
+		// tenants have their own bucket namespace
+		name := b.Tenant + "/" + b.Name
+		if seen[name] {
+			return fixtures, fmt.Errorf("fixtures: bucket %q is listed twice", b.Name)
+		}
+		seen[name] = true
+	}
+	return fixtures, nil
+}
+
+func (b bucketFixture) validate() error {
+	if !bucketNameRegexp.MatchString(b.Name) {
+		return fmt.Errorf("name must be 3 to 63 lowercase letters, digits, dots or hyphens")
+	}
+	if b.Tenant != "" && b.Owner == "" {
+		return fmt.Errorf("tenant is only used with owner")
+	}
+	switch b.Versioning {
+	case "", "enabled", "suspended":
+	default:
+		return fmt.Errorf("versioning must be enabled or suspended, got %q", b.Versioning)
+	}
+	if lock := b.ObjectLock; lock != nil {
+		if b.Versioning == "suspended" {
+			return fmt.Errorf("object_lock needs versioning enabled")
+		}
+		switch lock.Mode {
+		case "":
+			if lock.Days != 0 || lock.Years != 0 {
+				return fmt.Errorf("object_lock days and years need a mode")
+			}
+		case "GOVERNANCE", "COMPLIANCE":
+			if (lock.Days > 0) == (lock.Years > 0) {
+				return fmt.Errorf("object_lock needs either days or years")
+			}
+		default:
+			return fmt.Errorf("object_lock mode must be GOVERNANCE or COMPLIANCE, got %q", lock.Mode)
+		}
+	}
+	for _, rule := range b.CORS {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return fmt.Errorf("cors rules need allowed_origins and allowed_methods")
+		}
+	}
+	if b.Policy != "" && !json.Valid([]byte(b.Policy)) {
+		return fmt.Errorf("policy is not a JSON document")
+	}
+	for _, rule := range b.Lifecycle {
+		if rule.ExpirationDays == 0 && rule.NoncurrentExpirationDays == 0 && rule.AbortIncompleteMultipartDays == 0 {
+			return fmt.Errorf("lifecycle rule %q has no action", rule.ID)
+		}
+	}
+	return nil
+}
+
+// applyFixtures creates the buckets of the fixtures file and applies their settings
+// Every item is applied again on restart, so the buckets follow the file; settings left out of it are not removed
+func applyFixtures() {
+	fixtures, err := loadFixtures(conf.Fixtures)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := waitRgw(rgwWaitTimeout); err != nil {
+		log.Fatalf("init rgw: cannot apply the fixtures: %v", err)
+	}
+	log.Println("init rgw: applying fixtures from " + conf.Fixtures)
+	report := fixtures.apply()
+	if failed := report.failures(); len(failed) > 0 {
+		log.Fatalf("init rgw: %d of %d fixture item(s) failed:\n%s", len(failed), len(report), failed)
+	}
+	log.Printf("init rgw: %d fixture item(s) applied to %d bucket(s)", len(report), len(fixtures.Buckets))
+}
+
+// apply goes through every bucket, a bucket that cannot be created is reported and the next one is applied
+func (f fixturesFile) apply() fixtureReport {
+	report := fixtureReport{}
+	clients := map[string]*s3Client{}
+	for _, b := range f.Buckets {
+		owner := b.Tenant + "$" + b.Owner
+		client, ok := clients[owner]
+		if !ok {
+			creds, err := fixtureOwnerCredentials(b.Tenant, b.Owner)
+			if err != nil {
+				report = append(report, fixtureResult{Bucket: b.Name, Item: "owner", Err: err})
+				continue
+			}
+			client = localS3Client(creds)
+			clients[owner] = client
+		}
+		report = append(report, b.apply(client)...)
+	}
+	return report
+}
+
+// fixtureOwnerCredentials returns the keys of the owner of a bucket, the owner is created when it does not exist
+func fixtureOwnerCredentials(tenant, uid string) (s3Credentials, error) {
+	if uid == "" {
+		uid = cnCoreRgwUserUID
+	}
+	path := userDetailsFile(tenant, uid)
+	if _, err := stat(path); os.IsNotExist(err) && path != cnUserDetailsFile {
+		log.Println("init rgw: creating fixture owner " + uid)
+		// during a dry run the request is only recorded, there is no answer to parse
+		if _, err := createUser(uid, userOptions{tenant: tenant}, false); err != nil && !dryRun {
+			return s3Credentials{}, err
+		}
+	}
+	if dryRun {
+		return s3Credentials{User: uid, AccessKey: "<access key>", SecretKey: "<secret key>"}, nil
+	}
+	return loadCredentials(path)
+}
+
+func (b bucketFixture) apply(client *s3Client) fixtureReport {
+	result := func(item string, err error) fixtureResult {
+		return fixtureResult{Bucket: b.Name, Item: item, Err: err}
+	}
+
+	err := createBucket(client, b.Name, b.ObjectLock != nil)
+	report := fixtureReport{result("create", err)}
+	if err != nil {
+		return report
+	}
+
+	if b.Versioning != "" {
+		// the S3 status is capitalized
+		status := strings.ToUpper(b.Versioning[:1]) + b.Versioning[1:]
+		err := client.putXML(b.Name, "versioning", versioningConfiguration{XMLNS: s3XMLNS, Status: status})
+		report = append(report, result("versioning", err))
+	}
+	if lock := b.ObjectLock; lock != nil {
+		config := objectLockConfiguration{XMLNS: s3XMLNS, ObjectLockEnabled: "Enabled"}
+		if lock.Mode != "" {
+			config.DefaultRetention = &defaultRetention{Mode: lock.Mode, Days: lock.Days, Years: lock.Years}
+		}
+		err := client.putXML(b.Name, "object-lock", config)
+		if isS3Error(err, "InvalidBucketState") {
+			err = fmt.Errorf("%v, object lock can only be enabled when the bucket is created", err)
+		}
+		report = append(report, result("object-lock", err))
+	}
+	if len(b.CORS) > 0 {
+		err := client.putXML(b.Name, "cors", corsConfiguration{XMLNS: s3XMLNS, Rules: b.CORS})
+		report = append(report, result("cors", err))
+	}
+	if b.Policy != "" {
+		err := client.putDocument(b.Name, "", "policy", []byte(b.Policy))
+		report = append(report, result("policy", err))
+	}
+	if len(b.Lifecycle) > 0 {
+		err := client.putXML(b.Name, "lifecycle", b.lifecycleConfiguration())
+		report = append(report, result("lifecycle", err))
+	}
+	return report
+}
+
+// createBucket creates a bucket, a bucket the user already owns is left as is
+func createBucket(client *s3Client, name string, objectLock bool) error {
+	header := http.Header{}
+	if objectLock {
+		header.Set("X-Amz-Bucket-Object-Lock-Enabled", "true")
+	}
+	_, err := client.call(s3Request{method: http.MethodPut, bucket: name, header: header})
+	if isS3Error(err, "BucketAlreadyOwnedByYou") {
+		return nil
+	}
+	return err
+}
+
+func (b bucketFixture) lifecycleConfiguration() lifecycleConfiguration {
+	config := lifecycleConfiguration{XMLNS: s3XMLNS}
+	for _, rule := range b.Lifecycle {
+		r := lifecycleRule{ID: rule.ID, Prefix: rule.Prefix, Status: "Enabled"}
+		if rule.Disabled {
+			r.Status = "Disabled"
+		}
+		if rule.ExpirationDays > 0 {
+			r.Expiration = &lifecycleDays{Days: rule.ExpirationDays}
+		}
+		if rule.NoncurrentExpirationDays > 0 {
+			r.NoncurrentVersionExpiration = &noncurrentDays{NoncurrentDays: rule.NoncurrentExpirationDays}
+		}
+		if rule.AbortIncompleteMultipartDays > 0 {
+			r.AbortIncompleteMultipartUpload = &abortDays{DaysAfterInitiation: rule.AbortIncompleteMultipartDays}
+		}
+		config.Rules = append(config.Rules, r)
+	}
+	return config
+}
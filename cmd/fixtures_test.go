@@ -0,0 +1,177 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFixtures = `buckets:
+  - name: photos
+    versioning: enabled
+    cors:
+      - allowed_origins: ["*"]
+        allowed_methods: [GET, PUT]
+        max_age_seconds: 3000
+    policy: |
+      {"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}
+    lifecycle:
+      - id: expire-tmp
+        prefix: tmp/
+        expiration_days: 1
+        abort_incomplete_multipart_days: 2
+  - name: invoices
+    owner: alice
+    tenant: acme
+    object_lock:
+      mode: GOVERNANCE
+      days: 30
+`
+
+func writeTestFixtures(t *testing.T, root, content string) {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "fixtures.yaml"), []byte(content), 0644))
+	conf.Fixtures = "/fixtures.yaml"
+}
+
+func TestLoadFixtures(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+
+	writeTestFixtures(t, root, testFixtures)
+	fixtures, err := loadFixtures(conf.Fixtures)
+	assert.Nil(t, err)
+	assert.Len(t, fixtures.Buckets, 2)
+	assert.Equal(t, &objectLockFixture{Mode: "GOVERNANCE", Days: 30}, fixtures.Buckets[1].ObjectLock)
+
+	for content, reason := range map[string]string{
+		"buckets:\n  - name: Photos\n":                                                 "name must be 3 to 63 lowercase letters",
+		"buckets:\n  - name: photos\n    versioning: on\n":                             "versioning must be enabled or suspended",
+		"buckets:\n  - name: photos\n    tenant: acme\n":                               "tenant is only used with owner",
+		"buckets:\n  - name: photos\n    object_lock: {mode: GOVERNANCE}\n":            "object_lock needs either days or years",
+		"buckets:\n  - name: photos\n    policy: '{\"Version\"'\n":                     "policy is not a JSON document",
+		"buckets:\n  - name: photos\n    lifecycle: [{id: noop}]\n":                    `lifecycle rule "noop" has no action`,
+		"buckets:\n  - name: photos\n  - name: photos\n":                               `bucket "photos" is listed twice`,
+		"buckets:\n  - name: photos\n    acl: private\n":                               "field acl not found",
+		"buckets:\n  - name: photos\n    cors: [{allowed_origins: ['*']}]\n":           "cors rules need allowed_origins and allowed_methods",
+		"buckets:\n  - name: photos\n    object_lock: {}\n    versioning: suspended\n": "object_lock needs versioning enabled",
+	} {
+		writeTestFixtures(t, root, content)
+		_, err := loadFixtures(conf.Fixtures)
+		if assert.NotNil(t, err, content) {
+			assert.Contains(t, err.Error(), reason)
+		}
+	}
+}
+
+func TestApplyFixtures(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	writeTestFixtures(t, root, testFixtures)
+
+	fixtures, err := loadFixtures(conf.Fixtures)
+	assert.Nil(t, err)
+	report := fixtures.apply()
+	assert.Empty(t, report.failures())
+	// the owner is created on the first start only
+	assert.Equal(t, []string{
+		"PUT /photos",
+		"PUT /photos?versioning=",
+		"PUT /photos?cors=",
+		"PUT /photos?policy=",
+		"PUT /photos?lifecycle=",
+		"PUT /admin/user?display-name=alice&format=json&generate-key=true&key-type=s3&uid=acme%24alice",
+		"PUT /invoices",
+		"PUT /invoices?object-lock=",
+	}, fake.requests)
+	assert.Equal(t, "ALICEACCESSKEY000000", fake.owners["invoices"])
+	assert.Equal(t, []string{"object-lock=true"}, fake.headers["PUT /invoices"])
+
+	assert.Equal(t, `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`, fake.docs["photos?versioning"])
+	assert.Equal(t, `<CORSConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><AllowedMethod>PUT</AllowedMethod><MaxAgeSeconds>3000</MaxAgeSeconds></CORSRule></CORSConfiguration>`, fake.docs["photos?cors"])
+	assert.Equal(t, `<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Rule><ID>expire-tmp</ID><Filter><Prefix>tmp/</Prefix></Filter><Status>Enabled</Status><Expiration><Days>1</Days></Expiration><AbortIncompleteMultipartUpload><DaysAfterInitiation>2</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule></LifecycleConfiguration>`, fake.docs["photos?lifecycle"])
+	assert.Equal(t, `<ObjectLockConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>30</Days></DefaultRetention></Rule></ObjectLockConfiguration>`, fake.docs["invoices?object-lock"])
+	assert.Contains(t, fake.docs["photos?policy"], `"Resource": "arn:aws:s3:::photos/*"`)
+
+	// a restart applies the same settings to the existing buckets
+	fake.requests = nil
+	report = fixtures.apply()
+	assert.Empty(t, report.failures())
+	assert.Len(t, fake.requests, 7)
+	assert.Equal(t, "  photos: create: ok\n", report[:1].String())
+}
+
+func TestFixturesReport(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	writeTestFixtures(t, root, `buckets:
+  - name: photos
+    versioning: enabled
+    cors: [{allowed_origins: ["*"], allowed_methods: [GET]}]
+  - name: shared
+    object_lock: {}
+  - name: taken
+  - name: videos
+`)
+	fake.buckets["shared"], fake.owners["shared"] = true, "CN4CCESSKEY0000000000"
+	fake.buckets["taken"], fake.owners["taken"] = true, "SOMEONEELSE000000000"
+	fake.errors["PUT /photos?cors="] = s3Error{StatusCode: http.StatusBadRequest, Code: "MalformedXML"}
+	fake.errors["PUT /shared?object-lock="] = s3Error{StatusCode: http.StatusConflict, Code: "InvalidBucketState", Message: "bucket object lock not enabled"}
+
+	fixtures, err := loadFixtures(conf.Fixtures)
+	assert.Nil(t, err)
+	report := fixtures.apply()
+
+	// a failure does not stop the next items and buckets
+	assert.Len(t, report, 7)
+	assert.Equal(t, `  photos: cors: MalformedXML (400)
+  shared: object-lock: InvalidBucketState (409): bucket object lock not enabled, object lock can only be enabled when the bucket is created
+  taken: create: BucketAlreadyExists (409)
+`, report.failures().String())
+	assert.True(t, fake.buckets["videos"])
+}
+
+func TestFixturesDryRun(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	writeTestFixtures(t, root, testFixtures)
+	dryRun, runner, plan = true, planRunner{}, &bootstrapPlan{daemon: "rgw"}
+	defer func() { dryRun, runner, plan = false, execRunner{}, &bootstrapPlan{} }()
+
+	fixtures, err := loadFixtures(conf.Fixtures)
+	assert.Nil(t, err)
+	assert.Empty(t, fixtures.apply().failures())
+
+	// the owner would be created before its bucket
+	assert.Equal(t, "PUT /admin/user?display-name=alice&format=json&generate-key=true&key-type=s3&uid=acme%24alice", describeStep(plan.Steps[5]))
+	assert.Equal(t, "PUT /invoices?object-lock=", describeStep(plan.Steps[7]))
+	assert.Len(t, plan.Steps, 8)
+}
@@ -33,12 +33,17 @@ import (
 
 var (
 	daemon           string
-	dashExposedIP    string
+	planFormat       string
+	recordTranscript string
+	replayTranscript string
+	repair           bool
 	validValueDaemon = []string{"mon", "mgr", "osd", "rgw", "dash", "health"}
 )
 
 const (
 	cnMemMin         uint64 = 512         // minimum amount of memory in MB to run cn-core
+	osdMemMin        uint64 = 256         // smallest share of memory in MB tuneMemory accepts for an OSD, for a 128MB osd_memory_base
+	osdMemReserve    uint64 = 256         // memory in MB the mon and mgr use when the OSDs start, cnMemMin leaves osdMemMin to one OSD
 	bluestoreSizeMin uint64 = 10737418240 // minimum amount of space for BlueStore in bytes
 )
 
@@ -50,64 +55,179 @@ func cliInitCluster() *cobra.Command {
 		Args:  cobra.NoArgs,
 		Run:   initCluster,
 		Example: "cn-core init\n" +
-			"cn-core init --daemon mon \n",
+			"cn-core init --daemon mon \n" +
+			"cn-core init --config /etc/cn-core/cn-core.yaml\n" +
+			"cn-core init --dry-run --format json\n" +
+			"cn-core init --repair\n",
 	}
 	cmd.Flags().SortFlags = false
 	cmd.Flags().StringVarP(&daemon, "daemon", "d", "", "Specify which daemon to bootstrap. Valid choices are: "+strings.Join(validValueDaemon, ", ")+".")
-	cmd.Flags().StringVar(&rgwPort, "rgw-port", rgwPort, "Specify binding port for Rados Gateway.")
-	cmd.Flags().StringVar(&dashPort, "dash-port", dashPort, "Specify binding port for Sree dashboard.")
-	cmd.Flags().StringVar(&dashExposedIP, "dash-exposed-ip", dashExposedIP, "Specify binding port for Sree dashboard.")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the bootstrap plan without executing it.")
+	cmd.Flags().StringVar(&planFormat, "format", "text", "Specify the format of the dry run plan. Valid choices are: text, json.")
+	cmd.Flags().StringVar(&recordTranscript, "record-transcript", "", "Record every command run during the bootstrap to a transcript file.")
+	cmd.Flags().StringVar(&replayTranscript, "replay-transcript", "", "Replay the commands from a transcript file instead of running them.")
+	cmd.Flags().BoolVar(&repair, "repair", false, "Roll back the bootstrap steps an interrupted init left behind and exit.")
+	addConfigFlags(cmd.Flags())
 
 	return cmd
 }
 
 // initCluster initialize the Ceph cluster
 func initCluster(cmd *cobra.Command, args []string) {
-	memLimit := getMemLimit()
-	err := validateAvaibleMemory(cnMemMin, memLimit)
+	// resolve and validate the configuration before touching any daemon
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+
+	if conf.supervise() {
+		sup = newSupervisor(conf.restartBudget())
+	}
+
+	if len(replayTranscript) > 0 {
+		r, err := loadTranscript(replayTranscript)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runner = r
+	}
+	if len(recordTranscript) > 0 {
+		runner = newRecordingRunner(runner, recordTranscript)
+	}
+
+	if dryRun {
+		if err := validatePlanFormat(planFormat); err != nil {
+			log.Fatal(err)
+		}
+		runner = planRunner{}
+		// the plan is the only output on stdout, the logs and the reason of a failure go to stderr
+		log.SetOutput(os.Stderr)
+		plan.Config = map[string]string{}
+		for _, opt := range conf.options() {
+			plan.Config[opt.key] = *opt.value
+		}
+		defer func() {
+			if err := printPlan(os.Stdout, planFormat); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}()
+	} else if !repair {
+		// as the container entrypoint we reap orphans and stop the daemons cleanly on 'docker stop'
+		handleSignals()
+
+		switch daemon {
+		case "health":
+		case "":
+			probes.expect(probeComponents...)
+		default:
+			probes.expect(daemon)
+		}
+		if port := conf.healthPort(); port != "" {
+			serveProbes(port)
+		}
+	}
+
+	memLimit, err := getMemLimit()
+	if err != nil {
+		log.Fatalf("init: failed to read the memory limit: %v", err)
+	}
+	err = validateAvaibleMemory(cnMemMin, memLimit)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// validate available bluestore block size, if the user has provided a dedicated directory
-	osdPathEnv := os.Getenv("OSD_PATH")
-	if len(osdPathEnv) > 0 {
-		err := validateAvailableBluestoreSize(bluestoreSizeMin, osdPathEnv)
+	if len(conf.OsdPath) > 0 {
+		err := validateAvailableBluestoreSize(bluestoreSizeMin, conf.OsdPath)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
+	// resume the bootstrap where a previous init was interrupted
+	journaled := probeComponents
+	switch daemon {
+	case "":
+	case "health":
+		journaled = nil
+	default:
+		journaled = []string{daemon}
+	}
+	if repair {
+		repairJournal(journaled...)
+		log.Println("init: repair done, the next init resumes the bootstrap")
+		return
+	}
+	recoverJournal(journaled...)
+
 	switch daemon {
 	case "mon":
-		bootstrapMon()
+		bootstrap("mon", bootstrapMon)
+		waitSupervisor()
 	case "mgr":
-		bootstrapMgr()
+		bootstrap("mgr", bootstrapMgr)
+		waitSupervisor()
 	case "osd":
-		bootstrapOsd()
+		bootstrap("osd", bootstrapOsd)
+		waitSupervisor()
 	case "rgw":
-		bootstrapRgw()
+		bootstrap("rgw", bootstrapRgw)
+		waitSupervisor()
 	case "dash":
-		bootstrapSree()
+		bootstrap("dash", bootstrapDashboard)
+		waitSupervisor()
 	case "health":
+		plan.daemon = "health"
 		err := cephHealth()
 		if err != nil {
 			log.Fatal(err)
 		}
 	default:
 		log.Printf("init: no daemon was selected. Deploying %s.\n", strings.Join(validValueDaemon, ", "))
-		bootstrapMon()
-		bootstrapMgr()
-		bootstrapOsd()
-		bootstrapRgw()
-		bootstrapSree()
+		bootstrapCluster()
 
 		// This makes cn happy when looking for the container status
-		fmt.Println("SUCCESS")
+		if !dryRun {
+			fmt.Println("SUCCESS")
+		}
 
 		// bootstrap is done, now watching ceph status
+		plan.daemon = "health"
+		if supervising() && !dryRun {
+			// the supervised daemons decide when cn-core stops, not the health watcher
+			go func() {
+				if err := cephHealth(); err != nil {
+					log.Printf("init: ceph health watcher stopped: %v", err)
+				}
+			}()
+			waitSupervisor()
+		}
 		err := cephHealth()
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+// waitSupervisor keeps cn-core running as long as the supervised daemons are healthy
+func waitSupervisor() {
+	if !supervising() || dryRun {
+		return
+	}
+
+	err := sup.wait()
+	for _, status := range sup.status() {
+		log.Printf("supervisor: %s restarted %d time(s)", status.Name, status.Restarts)
+	}
+	log.Fatal(err)
+}
+
+// bootstrapCluster bootstraps every daemon in order
+func bootstrapCluster() {
+	bootstrap("mon", bootstrapMon)
+	bootstrap("mgr", bootstrapMgr)
+	bootstrap("osd", bootstrapOsd)
+	bootstrap("rgw", bootstrapRgw)
+	bootstrap("dash", bootstrapDashboard)
+}
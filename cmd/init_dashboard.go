@@ -0,0 +1,172 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+
+	"github.com/ceph/cn-core/internal/ceph"
+)
+
+const (
+	dashboardSree = "sree"
+	dashboardCeph = "ceph"
+	dashboardNone = "none"
+
+	dashboardUser            = "admin"
+	dashboardUserDetailsFile = "/opt/ceph-container/tmp/cn_dashboard_user_details"
+)
+
+var validValueDashboard = []string{dashboardSree, dashboardCeph, dashboardNone}
+
+// dashboardUserDetails is the admin account of the mgr dashboard
+type dashboardUserDetails struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// bootstrapDashboard bootstraps the dashboard selected by the configuration
+func bootstrapDashboard() {
+	switch conf.Dashboard {
+	case dashboardCeph:
+		bootstrapCephDashboard()
+	case dashboardNone:
+		skipStep("dashboard is disabled")
+	default:
+		bootstrapSree()
+	}
+}
+
+// bootstrapCephDashboard serves the mgr dashboard module on the dashboard port
+// The settings are applied on every start since the ports and the addresses may have changed
+func bootstrapCephDashboard() {
+	client := dashboardClient()
+
+	log.Println("init dashboard: configure ceph dashboard")
+	for _, setting := range [][2]string{
+		{"mgr/dashboard/ssl", "false"},
+		{"mgr/dashboard/server_addr", conf.bindAddress()},
+		{"mgr/dashboard/server_port", conf.DashPort},
+	} {
+		if err := client.ConfigSet("mgr", setting[0], setting[1]); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// the module reads its settings when it loads, reload it in case the manager already runs it
+	log.Println("init dashboard: running ceph dashboard on port " + conf.DashPort)
+	if err := client.MgrModuleDisable("dashboard"); err != nil {
+		log.Fatal(err)
+	}
+	if err := client.MgrModuleEnable("dashboard", true); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := stat(dashboardUserDetailsFile); os.IsNotExist(err) {
+		runStep("dash", journalStep{Name: "admin-user", Paths: []string{dashboardUserDetailsFile}}, func() {
+			createDashboardUser(client)
+		})
+	} else {
+		skipStep("dashboard user details " + dashboardUserDetailsFile + " already exist")
+	}
+
+	// the object gateway page uses the cn user, it does not exist when the dashboard is bootstrapped alone
+	creds := s3Credentials{User: cnCoreRgwUserUID, AccessKey: "<access key>", SecretKey: "<secret key>"}
+	if !dryRun {
+		if _, err := stat(cnUserDetailsFile); os.IsNotExist(err) {
+			skipStep("no " + cnUserDetailsFile + ", the object gateway page is not configured")
+			return
+		}
+		var err error
+		if creds, err = cnCredentials(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	rgwHost, rgwPort, _ := net.SplitHostPort(conf.localAddr(conf.RgwPort))
+	log.Println("init dashboard: configure the object gateway page")
+	for _, setting := range [][2]string{
+		{"rgw-api-host", rgwHost},
+		{"rgw-api-port", rgwPort},
+		{"rgw-api-scheme", "http"},
+		{"rgw-api-user-id", cnCoreRgwUserUID},
+		{"rgw-api-access-key", creds.AccessKey},
+	} {
+		if err := client.DashboardSet(setting[0], setting[1]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := client.DashboardSetSecret("rgw-api-secret-key", creds.SecretKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dashboardClient returns a ceph client aware of the release, the dashboard of Nautilus takes the secrets as arguments
+func dashboardClient() *ceph.Client {
+	client := cephClient()
+	version, err := client.Version()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dryRun {
+		// nothing answers during a dry run, the secrets are planned on the standard input
+		return client
+	}
+	release, err := ceph.ParseRelease(version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client.WithRelease(release)
+}
+
+// createDashboardUser creates the admin account of the mgr dashboard with a generated password
+// The password is reset when the account exists, e.g: the details were lost with an interrupted bootstrap
+func createDashboardUser(client *ceph.Client) {
+	log.Println("init dashboard: creating dashboard user " + dashboardUser)
+
+	details := dashboardUserDetails{User: dashboardUser, Password: "<password>"}
+	if !dryRun {
+		details.Password = generateSecret()
+	}
+	content, err := json.MarshalIndent(details, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeFile(dashboardUserDetailsFile, content, 0600); err != nil {
+		log.Fatal(err)
+	}
+
+	if dryRun {
+		// nothing answers during a dry run, the account is assumed missing
+		err = client.DashboardCreateUser(dashboardUser, details.Password, "administrator")
+	} else if _, err = client.DashboardUser(dashboardUser); err == nil {
+		err = client.DashboardSetPassword(dashboardUser, details.Password)
+	} else if ceph.IsNotFound(err) {
+		err = client.DashboardCreateUser(dashboardUser, details.Password, "administrator")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
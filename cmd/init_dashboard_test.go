@@ -0,0 +1,147 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versions of the images the dashboard is tested with, Nautilus takes the secrets as arguments
+const (
+	testNautilusVersion = "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)\n"
+	testPacificVersion  = "ceph version 16.2.7 (dd0603118f56ab514f133c8d2e3adfc983942503) pacific (stable)\n"
+)
+
+func cephDashboardTranscript(version string, entries ...transcriptEntry) []transcriptEntry {
+	configure := []transcriptEntry{
+		{Args: []string{"ceph", "--version"}, Output: version},
+		{Args: []string{"ceph", "config", "set", "mgr", "mgr/dashboard/ssl", "false"}},
+		{Args: []string{"ceph", "config", "set", "mgr", "mgr/dashboard/server_addr", "0.0.0.0"}},
+		{Args: []string{"ceph", "config", "set", "mgr", "mgr/dashboard/server_port", "5000"}},
+		{Args: []string{"ceph", "mgr", "module", "disable", "dashboard"}},
+		{Args: []string{"ceph", "mgr", "module", "enable", "dashboard", "--force"}},
+	}
+	return append(configure, entries...)
+}
+
+func TestBootstrapCephDashboard(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.Dashboard = dashboardCeph
+
+	replay := &replayRunner{entries: cephDashboardTranscript(testNautilusVersion,
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-show", "admin", "--format", "json"}, ExitCode: 2, Output: "Error ENOENT: User 'admin' does not exist\n"},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-create", "admin", transcriptAnyArg, "administrator"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-host", "127.0.0.1"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-port", "8000"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-scheme", "http"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-user-id", "cn"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-access-key", "CN4CCESSKEY0000000000"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-secret-key", "cnSecretKey"}},
+	)}
+	runner = replay
+	defer func() { runner = execRunner{} }()
+
+	bootstrapDashboard()
+	assert.Equal(t, 0, replay.remaining())
+
+	var details dashboardUserDetails
+	assert.Nil(t, json.Unmarshal([]byte(readTestFile(t, root, dashboardUserDetailsFile)), &details))
+	assert.Equal(t, "admin", details.User)
+	assert.NotEmpty(t, details.Password)
+	info, err := os.Stat(filepath.Join(root, dashboardUserDetailsFile))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Sree is left alone
+	_, err = os.Stat(filepath.Join(root, dashboardDir+"sree.cfg"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBootstrapCephDashboardExistingUser(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.Dashboard = dashboardCeph
+	assert.Nil(t, os.Remove(filepath.Join(root, cnUserDetailsFile)))
+
+	// the account survived the details, e.g: an interrupted bootstrap, its password is reset
+	replay := &replayRunner{entries: cephDashboardTranscript(testNautilusVersion,
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-show", "admin", "--format", "json"}, Output: `{"username": "admin"}`},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-set-password", "admin", transcriptAnyArg}},
+	)}
+	runner = replay
+	defer func() { runner = execRunner{} }()
+
+	bootstrapDashboard()
+	assert.Equal(t, 0, replay.remaining())
+
+	// the next start keeps the password
+	replay = &replayRunner{entries: cephDashboardTranscript(testNautilusVersion)}
+	runner = replay
+	bootstrapDashboard()
+	assert.Equal(t, 0, replay.remaining())
+}
+
+func TestBootstrapCephDashboardPacific(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.Dashboard = dashboardCeph
+
+	// the secrets are read on the standard input, never on the command line
+	replay := &replayRunner{entries: cephDashboardTranscript(testPacificVersion,
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-show", "admin", "--format", "json"}, ExitCode: 2, Output: "Error ENOENT: User 'admin' does not exist\n"},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "ac-user-create", "admin", "administrator", "-i", "-"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-host", "127.0.0.1"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-port", "8000"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-scheme", "http"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-user-id", "cn"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-access-key", "CN4CCESSKEY0000000000"}},
+		transcriptEntry{Args: []string{"ceph", "dashboard", "set-rgw-api-secret-key", "-i", "-"}},
+	)}
+	runner = replay
+
+	bootstrapDashboard()
+	assert.Equal(t, 0, replay.remaining())
+}
+
+func TestBootstrapDashboardNone(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.Dashboard = dashboardNone
+
+	replay := &replayRunner{}
+	runner = replay
+	defer func() { runner = execRunner{} }()
+
+	bootstrapDashboard()
+	files, err := ioutil.ReadDir(filepath.Join(root, dashboardDir))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	assert.Nil(t, checkDash())
+}
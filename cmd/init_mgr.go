@@ -23,7 +23,6 @@
 package cmd
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"os/exec"
@@ -41,18 +40,22 @@ func bootstrapMgr() {
 	mgrKeyringPath := mgrDataPath + "/keyring"
 
 	// if there is no key, we assume there is no monitor
-	if _, err := os.Stat(mgrKeyringPath); os.IsNotExist(err) {
-		// run prereq
-		mgrPreReq(mgrDataPath, monKeyringPath)
-
-		// generate mgr keyring
-		generateMgrKeyring(hostname, mgrKeyringPath)
-
-		// chown mgr keyring
-		err = os.Chown(mgrKeyringPath, cephUID, cephGID)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if _, err := stat(mgrKeyringPath); os.IsNotExist(err) {
+		runStep("mgr", journalStep{Name: "keyring", Paths: []string{adminKeyringPath, mgrKeyringPath}}, func() {
+			// run prereq
+			mgrPreReq(mgrDataPath, monKeyringPath)
+
+			// generate mgr keyring
+			generateMgrKeyring(hostname, mgrKeyringPath)
+
+			// chown mgr keyring
+			err := chown(mgrKeyringPath, cephUID, cephGID)
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+	} else {
+		skipStep("manager keyring " + mgrKeyringPath + " already exists")
 	}
 
 	// start ceph mgr!
@@ -61,24 +64,21 @@ func bootstrapMgr() {
 
 func mgrPreReq(mgrDataPath, monKeyringPath string) {
 	log.Println("init mgr: run prerequisites")
-	if _, err := os.Stat(mgrDataPath); os.IsNotExist(err) {
-		err = os.MkdirAll(mgrDataPath, 0755)
+	if _, err := stat(mgrDataPath); os.IsNotExist(err) {
+		err = mkdirAll(mgrDataPath, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = os.Chown(mgrDataPath, cephUID, cephGID)
+		err = chown(mgrDataPath, cephUID, cephGID)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	out, args, err := fetchAdminKeyring(monKeyringPath)
-	if err != nil {
-		fmt.Printf("The command was: %s\n", args)
-		fmt.Printf("The error was: %s\n", out)
+	if err := fetchAdminKeyring(monKeyringPath); err != nil {
 		log.Fatal(err)
 	}
-	err = os.Chown(adminKeyringPath, cephUID, cephGID)
+	err := chown(adminKeyringPath, cephUID, cephGID)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -87,12 +87,7 @@ func mgrPreReq(mgrDataPath, monKeyringPath string) {
 func generateMgrKeyring(hostname, mgrKeyringPath string) {
 	log.Println("init mgr: generating manager keyring")
 
-	cmd := exec.Command("ceph", "auth", "get-or-create", "mgr."+hostname, "mon", `allow *`, "-o", mgrKeyringPath)
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+	if err := cephClient().AuthGetOrCreate("mgr."+hostname, mgrKeyringPath, "mon", `allow *`); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -102,10 +97,13 @@ func mgrStart(hostname string) {
 
 	cmd := exec.Command("ceph-mgr", "--setuser", "ceph", "--setgroup", "ceph", "-i", hostname)
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+	cmd.Args = append(cmd.Args, "-f")
+	if supervising() {
+		superviseDaemon("mgr", cmd, true)
+		return
+	}
+
+	if err := startCommand(cmd); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -24,7 +24,6 @@ package cmd
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -36,27 +35,14 @@ const (
 	key = %s
 	caps mon = "allow *"
 `
-	cephConfTemplate = `
-[global]
-fsid = %s
-mon host = [v2:127.0.0.1:3300,v1:127.0.0.1:6789]
-public network = 0.0.0.0/0
-cluster network = 0.0.0.0/0
-log file = /dev/null
-
-`
-
 	monMapPath            = "/etc/ceph/monmap"
 	monInitialKeyringPath = "/etc/ceph/initial-mon-keyring"
-	monIP                 = "127.0.0.1"
-	monListenIPPort       = monIP + ":" + monPort
 	rgwEngine             = "beast"
 	monPort               = "3300"
+	monV1Port             = "6789"
 	osdPoolDefaultSize    = "1"
-)
-
-var (
-	rgwPort = "8000"
+	// legacyMonAddr is where monitors bootstrapped before mon_ip existed listen, their ceph.conf does not say it
+	legacyMonAddr = "127.0.0.1:3300"
 )
 
 func bootstrapMon() {
@@ -65,62 +51,99 @@ func bootstrapMon() {
 		log.Fatal("Failed to get the hostname.")
 	}
 
-	// Read ENV and search for a value for rgwPort
-	if rgwPortEnv := os.Getenv("RGW_FRONTEND_PORT"); rgwPortEnv != "" {
-		rgwPort = rgwPortEnv
-	}
-
-	// Keep this for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1356
-	rgwPortEnv := os.Getenv("RGW_CIVETWEB_PORT")
-	if len(rgwPortEnv) > 0 {
-		rgwPort = rgwPortEnv
-	}
-
 	monDataPath := cephDataPath + "/mon/ceph-" + hostname
 	monKeyringPath := monDataPath + "/keyring"
 
 	// if there is no key, we assume there is no monitor
-	if _, err := os.Stat(monKeyringPath); os.IsNotExist(err) {
-		// run prereq
-		monPreReq(monDataPath)
-
+	if _, err := stat(monKeyringPath); os.IsNotExist(err) {
 		// write mon initial keyring
-		writeKeyring(monInitialKeyringPath)
+		runStep("mon", journalStep{Name: "initial-keyring", Paths: []string{monInitialKeyringPath}}, func() {
+			if err := writeKeyring(monInitialKeyringPath); err != nil {
+				log.Fatal(err)
+			}
+		})
 
 		// write ceph.conf
-		fsid := writeCephConf(hostname, cephConfFilePath)
+		var fsid string
+		runStep("mon", journalStep{Name: "ceph-conf", Paths: []string{cephConfFilePath}}, func() {
+			fsid = writeCephConf(hostname, cephConfFilePath, "")
+
+			// chown ceph.conf
+			err := chown(cephConfFilePath, cephUID, cephGID)
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+		if fsid == "" {
+			// written by a previous run
+			fsid, err = readFsid()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 
-		// chown ceph.conf
-		err = os.Chown(cephConfFilePath, cephUID, cephGID)
+		// generate monmap
+		runStep("mon", journalStep{Name: "monmap", Paths: []string{monMapPath}}, func() {
+			generateMonMap(hostname, fsid, monMapPath)
+
+			// chown monmap
+			err := chown(monMapPath, cephUID, cephGID)
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+
+		// populate mon store, an interrupted mkfs is rolled back by removing the whole mon data directory
+		runStep("mon", journalStep{Name: "mkfs", Paths: []string{monDataPath}}, func() {
+			// run prereq
+			monPreReq(monDataPath)
+
+			monMkfs(hostname, monInitialKeyringPath, monDataPath, monMapPath)
+		})
+	} else {
+		skipStep("monitor keyring " + monKeyringPath + " already exists")
+
+		// the configuration or the drop-ins may have changed since the bootstrap
+		previous, err := readCephConf()
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		// generate monmap
-		generateMonMap(hostname, fsid, monMapPath)
-
-		// chown monmap
-		err = os.Chown(monMapPath, cephUID, cephGID)
+		fsid, _ := previous.get("global", "fsid")
+		if fsid == "" {
+			log.Fatalf("no fsid in %s", cephConfFilePath)
+		}
+		writeCephConf(hostname, cephConfFilePath, fsid)
+		err = chown(cephConfFilePath, cephUID, cephGID)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		// populate mon store
-		monMkfs(hostname, monInitialKeyringPath, monDataPath, monMapPath)
+		// a container restarted with a new address, e.g: with mon_ip auto, must move the monitor in its own monmap
+		if addr := previousMonAddr(previous, hostname); addr != conf.monAddr() {
+			monMoveAddr(hostname, monDataPath, addr)
+		}
 	}
 
 	// start ceph mon!
-	monStart(hostname, monDataPath)
+	monStart(hostname)
+}
+
+// previousMonAddr returns the address the monitor of a previous start listened on
+func previousMonAddr(previous *cephConfFile, hostname string) string {
+	if addr, _ := previous.get("mon."+hostname, "public addr"); addr != "" {
+		return addr
+	}
+	return legacyMonAddr
 }
 
 func monPreReq(monDataPath string) {
 	log.Println("init mon: run prerequisites")
-	if _, err := os.Stat(monDataPath); os.IsNotExist(err) {
-		err = os.MkdirAll(monDataPath, 0755)
+	if _, err := stat(monDataPath); os.IsNotExist(err) {
+		err = mkdirAll(monDataPath, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = os.Chown(monDataPath, cephUID, cephGID)
+		err = chown(monDataPath, cephUID, cephGID)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -138,11 +161,11 @@ func writeKeyring(monInitialKeyringPath string) error {
 	keyring := generateMonInitialKeyring()
 	keyringBytes := []byte(keyring)
 
-	if err := ioutil.WriteFile(monInitialKeyringPath, []byte(keyringBytes), 0600); err != nil {
+	if err := writeFile(monInitialKeyringPath, []byte(keyringBytes), 0600); err != nil {
 		return fmt.Errorf("failed to write monitor keyring to %s: %+v", monInitialKeyringPath, err)
 	}
 
-	err := os.Chown(monInitialKeyringPath, cephUID, cephGID)
+	err := chown(monInitialKeyringPath, cephUID, cephGID)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -153,14 +176,9 @@ func writeKeyring(monInitialKeyringPath string) error {
 func generateMonMap(hostname, fsid, monMapPath string) {
 	log.Println("init mon: generating monitor map")
 
-	cmd := exec.Command("monmaptool", "--create", "--add", hostname, monListenIPPort, "--fsid", fsid, monMapPath)
+	cmd := exec.Command("monmaptool", "--create", "--add", hostname, conf.monAddr(), "--fsid", fsid, monMapPath)
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
-		log.Fatal(err)
-	}
+	runCommandOrExit(cmd)
 }
 
 func monMkfs(hostname, monInitialKeyringPath, monDataPath, monMapPath string) {
@@ -168,24 +186,37 @@ func monMkfs(hostname, monInitialKeyringPath, monDataPath, monMapPath string) {
 
 	cmd := exec.Command("ceph-mon", "--setuser", "ceph", "--setgroup", "ceph", "--mkfs", "-i", hostname, "--inject-monmap", monMapPath, "--keyring", monInitialKeyringPath, "--mon-data", monDataPath)
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
-		log.Fatal(err)
+	runCommandOrExit(cmd)
+}
+
+// monMoveAddr rewrites the monmap of the store with the current address of the monitor
+func monMoveAddr(hostname, monDataPath, previousAddr string) {
+	log.Printf("init mon: moving monitor from %s to %s", previousAddr, conf.monAddr())
+
+	cmds := []*exec.Cmd{
+		exec.Command("ceph-mon", "--setuser", "ceph", "--setgroup", "ceph", "-i", hostname, "--mon-data", monDataPath, "--extract-monmap", monMapPath),
+		exec.Command("monmaptool", "--rm", hostname, monMapPath),
+		exec.Command("monmaptool", "--add", hostname, conf.monAddr(), monMapPath),
+		exec.Command("ceph-mon", "--setuser", "ceph", "--setgroup", "ceph", "-i", hostname, "--mon-data", monDataPath, "--inject-monmap", monMapPath),
+	}
+	for _, cmd := range cmds {
+		runCommandOrExit(cmd)
 	}
 }
 
-func monStart(hostname, monDataPath string) {
+func monStart(hostname string) {
 	log.Println("init mon: running monitor")
 
-	cmd := exec.Command("ceph-mon", "--setuser", "ceph", "--setgroup", "ceph", "-i", hostname, "--mon-data", monDataPath, "--public-addr", monListenIPPort, "--mon-initial-members", hostname,
-		"--osd-pool-default-size", osdPoolDefaultSize)
+	// the address, the data and the pool defaults come from ceph.conf, so the drop-ins and CEPH_CONF_ variables apply
+	cmd := exec.Command("ceph-mon", "--setuser", "ceph", "--setgroup", "ceph", "-i", hostname)
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+	cmd.Args = append(cmd.Args, "-f")
+	if supervising() {
+		superviseDaemon("mon", cmd, true)
+		return
+	}
+
+	if err := startCommand(cmd); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -25,23 +25,38 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
-	osdDataPath            = cephDataPath + "/osd/ceph-0"
-	osdKeyringPath         = osdDataPath + "/keyring"
+	osdBasePath            = cephDataPath + "/osd"
+	osdBootstrapKeyring    = cephDataPath + "/bootstrap-osd/ceph.keyring"
 	osdCrushChooseleafType = "0"
 	osdJournalSize         = "100"
 	osdObjectstore         = "bluestore"
+	bluestoreBlockSizeDef  = "10737418240"
 )
 
-var (
-	bluestoreBlockSize = "10737418240"
-)
+// lvmOsd is an OSD prepared by ceph-volume
+type lvmOsd struct {
+	ID      string
+	FSID    string
+	Devices []string
+}
+
+func osdDataPath(osdID string) string {
+	return osdBasePath + "/ceph-" + osdID
+}
+
+func osdKeyringPath(osdID string) string {
+	return osdDataPath(osdID) + "/keyring"
+}
 
 func bootstrapOsd() {
 	hostname, err := os.Hostname()
@@ -52,181 +67,320 @@ func bootstrapOsd() {
 	monDataPath := cephDataPath + "/mon/ceph-" + hostname
 	monKeyringPath := monDataPath + "/keyring"
 
-	// check for block device
-	osdDeviceEnv := os.Getenv("OSD_DEVICE")
-	bluestoreBlockSizeEnv := os.Getenv("BLUESTORE_BLOCK_SIZE")
-	if len(osdDeviceEnv) > 0 {
-
-		log.Println("init osd: checking for block device")
+	var osdIDs []string
 
-		testDev, err := getFileType(osdDeviceEnv)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if testDev == "blockdev" {
-			if len(bluestoreBlockSizeEnv) > 0 {
-				size := toBytes(bluestoreBlockSizeEnv)
-				// override the default value with the size indicated by the BLUESTORE_BLOCK_SIZE environment variable
-				bluestoreBlockSize = string(size)
-			} else {
-				// using blockdev command to fetch the actual size of the block device
-				cmd := exec.Command("blockdev", "--getsize64", osdDeviceEnv)
-
-				out, err := cmd.CombinedOutput()
-				if err != nil {
-					fmt.Printf("The command was: %s\n", cmd.Args)
-					fmt.Printf("The error was: %s\n", out)
-					log.Fatal(err)
-				} else {
-					// override the default value with the actual size of the block device available
-					bluestoreBlockSize = string(out)
-				}
-			}
-		} else {
-			log.Fatalf("Invalid %s, only block device is supported", osdDeviceEnv)
+	if devices := conf.osdDevices(); len(devices) > 0 {
+		// one OSD per block device, the device type is validated by loadConfig
+		osds := bootstrapOsdDevices(devices)
+		for _, device := range devices {
+			osdIDs = append(osdIDs, osds[device].ID)
 		}
+	} else {
+		osdIDs = bootstrapOsdDirectories(monKeyringPath, conf.osdCount())
+	}
 
+	// the memory is shared by all the OSDs
+	memAvailable, err := getAvailableRAM()
+	if err != nil {
+		log.Fatalf("init osd: failed to read the available memory: %v", err)
 	}
+	memAvailable = osdMemoryShare(memAvailable, len(osdIDs))
 
-	// if there is no key, we assume there is no monitor
-	if _, err := os.Stat(osdKeyringPath); os.IsNotExist(err) {
-		// run prereq
-		osdPreReq()
+	// start ceph osds!
+	for _, osdID := range osdIDs {
+		osdStart(osdID, memAvailable)
+	}
+}
 
-		if len(osdDeviceEnv) > 0 {
-			// export client.bootstrap-osd keyring to bootstrap-osd/ceph.keyring file
-			cmd := exec.Command("ceph", "auth", "export", "client.bootstrap-osd", "-o", "/var/lib/ceph/bootstrap-osd/ceph.keyring")
+// bootstrapOsdDirectories makes sure count file backed OSDs exist and returns their ids
+func bootstrapOsdDirectories(monKeyringPath string, count int) []string {
+	osdIDs := existingOsdDirectories()
+	if len(osdIDs) >= count {
+		skipStep(fmt.Sprintf("%d osd(s) already exist in %s", len(osdIDs), osdBasePath))
+		return osdIDs[:count]
+	}
 
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				fmt.Printf("The command was: %s\n", cmd.Args)
-				fmt.Printf("The error was: %s\n", out)
-				log.Fatal(err)
-			}
+	for i := len(osdIDs); i < count; i++ {
+		slot := "osd-" + strconv.Itoa(i)
 
-			log.Println("init osd: preparing block device")
+		// the uuid is kept in the journal, 'ceph osd new' returns the same id when it runs again with it
+		osdUUID := journalValue("osd", slot+"-uuid")
+		if osdUUID == "" {
+			osdUUID = generateUUID()
+			setJournalValue("osd", slot+"-uuid", osdUUID)
+		}
 
-			cmd = exec.Command("ceph-volume", "lvm", "prepare", "--data", osdDeviceEnv)
+		// let the monitor allocate the osd id
+		var osdID string
+		runStep("osd", journalStep{Name: slot + "-new"}, func() {
+			osdID = osdNew(osdUUID)
+			setJournalValue("osd", slot+"-id", osdID)
+		})
+		if osdID == "" {
+			osdID = journalValue("osd", slot+"-id")
+		}
+		if dryRun && osdID == "" {
+			osdID = fmt.Sprintf("<osd id %d>", i)
+		}
+
+		// an osd directory holding a keyring is considered bootstrapped, so an interrupted mkfs removes it
+		runStep("osd", journalStep{Name: slot + "-mkfs", Paths: []string{osdDataPath(osdID)}}, func() {
+			// run prereq
+			osdPreReq(osdDataPath(osdID))
 
-			out, err = cmd.CombinedOutput()
-			if err != nil {
-				fmt.Printf("The command was: %s\n", cmd.Args)
-				fmt.Printf("The error was: %s\n", out)
-				log.Fatal(err)
-			}
-		} else {
 			// generate osd keyring
-			generateOsdKeyring(monKeyringPath, osdKeyringPath)
+			generateOsdKeyring(monKeyringPath, osdID)
 
 			// chown osd keyring
-			err = os.Chown(osdKeyringPath, cephUID, cephGID)
+			err := chown(osdKeyringPath(osdID), cephUID, cephGID)
 			if err != nil {
 				log.Fatal(err)
 			}
+
 			// populate osd store
-			osdMkfs(monInitialKeyringPath)
-		}
+			osdMkfs(osdID, osdUUID)
+		})
+
+		osdIDs = append(osdIDs, osdID)
 	}
 
-	if len(osdDeviceEnv) > 0 {
-		cmd := exec.Command("ceph-volume", "lvm", "list", "--format", "json")
+	return osdIDs
+}
 
-		out, err := cmd.CombinedOutput()
+// existingOsdDirectories returns the ids of the file backed OSDs that have a keyring, sorted numerically
+func existingOsdDirectories() []string {
+	var osdIDs []int
+	entries, err := ioutil.ReadDir(hostPath(osdBasePath))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "ceph-") {
+			continue
+		}
+		osdID, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "ceph-"))
 		if err != nil {
-			fmt.Printf("The command was: %s\n", cmd.Args)
-			fmt.Printf("The error was: %s\n", out)
-			log.Fatal(err)
-		} else {
-			// fetch the osd fsid value
-			osdID := "0"
-			var result map[string]interface{}
-			json.Unmarshal([]byte(out), &result)
-			result1 := result[osdID].([]interface{})
-			result2 := result1[0].(map[string]interface{})
-			result3 := result2["tags"].(map[string]interface{})
-			osdFSID := result3["ceph.osd_fsid"]
-			osdFSIDstr, ok := osdFSID.(string)
-			if ok {
-				log.Println("init osd: activating block device")
-
-				cmd := exec.Command("ceph-volume", "lvm", "activate", "--no-systemd", "--bluestore", "0", osdFSIDstr)
-
-				out, err := cmd.CombinedOutput()
-				if err != nil {
-					fmt.Printf("The command was: %s\n", cmd.Args)
-					fmt.Printf("The error was: %s\n", out)
-					log.Fatal(err)
-				}
-
-			} else {
-				log.Fatal("Could not initiate block device activation. Failed to retrieve osd_fsid.")
+			continue
+		}
+		if _, err := stat(osdKeyringPath(strconv.Itoa(osdID))); err == nil {
+			osdIDs = append(osdIDs, osdID)
+		}
+	}
+	sort.Ints(osdIDs)
+
+	ids := make([]string, len(osdIDs))
+	for i, osdID := range osdIDs {
+		ids[i] = strconv.Itoa(osdID)
+	}
+	return ids
+}
+
+// bootstrapOsdDevices prepares and activates one OSD per device, it returns the OSDs indexed by device
+func bootstrapOsdDevices(devices []string) map[string]lvmOsd {
+	osds := osdLvmList()
+
+	prepared := false
+	for _, device := range devices {
+		if _, ok := osds[device]; ok {
+			skipStep("block device " + device + " already prepared")
+			continue
+		}
+
+		if !prepared {
+			log.Println("init osd: run prerequisites")
+			// export client.bootstrap-osd keyring to bootstrap-osd/ceph.keyring file
+			if err := cephClient().AuthExport("client.bootstrap-osd", osdBootstrapKeyring); err != nil {
+				log.Fatal(err)
+			}
+			prepared = true
+		}
+
+		log.Println("init osd: preparing block device " + device)
+
+		// ceph-volume allocates the osd id with 'ceph osd new', the osd fsid is kept in the journal to find it again
+		// an interrupted prepare leaves logical volumes behind, they are zapped before preparing again
+		// it may also leave the osd id and its cephx key behind, ceph-volume generates a new key so the id is purged rather than reused
+		fsidKey := "prepare-" + device + "-fsid"
+		runStep("osd", journalStep{Name: "prepare-" + device, Devices: []string{device}}, func() {
+			if osdFsid := journalValue("osd", fsidKey); osdFsid != "" {
+				osdPurgeFsid(osdFsid)
+			}
+			osdFsid := generateUUID()
+			setJournalValue("osd", fsidKey, osdFsid)
+			runCommandOrExit(exec.Command("ceph-volume", "lvm", "prepare", "--osd-fsid", osdFsid, "--data", device))
+		})
+	}
+
+	if prepared {
+		osds = osdLvmList()
+	}
+
+	for i, device := range devices {
+		osd, ok := osds[device]
+		if !ok {
+			if !dryRun {
+				log.Fatalf("Could not initiate block device activation. Failed to find an osd for %s.", device)
 			}
+			osd = lvmOsd{ID: fmt.Sprintf("<osd id %d>", i), FSID: fmt.Sprintf("<osd fsid %d>", i), Devices: []string{device}}
+			osds[device] = osd
 		}
+
+		log.Println("init osd: activating block device " + device)
+
+		runCommandOrExit(exec.Command("ceph-volume", "lvm", "activate", "--no-systemd", "--bluestore", osd.ID, osd.FSID))
 	}
 
-	// start ceph osd!
-	osdStart()
+	return osds
 }
 
-func osdPreReq() {
+// osdLvmList returns the OSDs prepared by ceph-volume indexed by device
+func osdLvmList() map[string]lvmOsd {
+	cmd := exec.Command("ceph-volume", "lvm", "list", "--format", "json")
+
+	out := runCommandOrExit(cmd)
+	if dryRun {
+		return map[string]lvmOsd{}
+	}
+
+	osds, err := parseLvmList(out)
+	if err != nil {
+		log.Fatalf("init osd: failed to parse ceph-volume lvm list: %v", err)
+	}
+
+	byDevice := map[string]lvmOsd{}
+	for _, osd := range osds {
+		for _, device := range osd.Devices {
+			byDevice[device] = osd
+		}
+	}
+	return byDevice
+}
+
+// parseLvmList extracts the OSDs from the output of 'ceph-volume lvm list --format json'
+func parseLvmList(lvmList []byte) ([]lvmOsd, error) {
+	var result map[string][]struct {
+		Devices []string          `json:"devices"`
+		Type    string            `json:"type"`
+		Tags    map[string]string `json:"tags"`
+	}
+	if err := json.Unmarshal(lvmList, &result); err != nil {
+		return nil, err
+	}
+
+	var osds []lvmOsd
+	for osdID, lvs := range result {
+		osd := lvmOsd{ID: osdID}
+		for _, lv := range lvs {
+			// an OSD can have db and wal volumes, the data lives on the block one
+			if lv.Type != "" && lv.Type != "block" {
+				continue
+			}
+			osd.FSID = lv.Tags["ceph.osd_fsid"]
+			osd.Devices = lv.Devices
+		}
+		if osd.FSID == "" {
+			return nil, fmt.Errorf("osd.%s has no ceph.osd_fsid tag", osdID)
+		}
+		osds = append(osds, osd)
+	}
+	sort.Slice(osds, func(i, j int) bool {
+		a, _ := strconv.Atoi(osds[i].ID)
+		b, _ := strconv.Atoi(osds[j].ID)
+		return a < b
+	})
+
+	return osds, nil
+}
+
+// osdBlockSize returns the size of the BlueStore block file of an OSD backed by a file
+// ceph-volume sizes the block of an OSD backed by a device after the device
+func osdBlockSize() string {
+	if len(conf.BluestoreBlockSize) > 0 {
+		// override the default value with the size indicated by the configuration
+		return strconv.FormatInt(toBytes(conf.BluestoreBlockSize), 10)
+	}
+	return bluestoreBlockSizeDef
+}
+
+func osdPreReq(osdDataPath string) {
 	log.Println("init osd: run prerequisites")
-	if _, err := os.Stat(osdDataPath); os.IsNotExist(err) {
-		err = os.MkdirAll(osdDataPath, 0755)
+	if _, err := stat(osdDataPath); os.IsNotExist(err) {
+		err = mkdirAll(osdDataPath, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = os.Chown(osdDataPath, cephUID, cephGID)
+		err = chown(osdDataPath, cephUID, cephGID)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-func generateOsdKeyring(monKeyringPath, osdKeyringPath string) {
-	log.Println("init osd: generating osd keyring")
+// osdPurgeFsid removes the OSD registered with an osd fsid, if any
+func osdPurgeFsid(osdFsid string) {
+	dump, err := cephClient().OsdDump()
+	if err != nil && !dryRun {
+		log.Fatal(err)
+	}
+
+	for _, osd := range dump.Osds {
+		if osd.UUID != osdFsid {
+			continue
+		}
+		log.Printf("init osd: purging osd.%d, its prepare was interrupted", osd.Osd)
+		if err := cephClient().OsdPurge(strconv.Itoa(osd.Osd)); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
 
-	cmd := exec.Command("ceph", "-n", "mon.", "-k", monKeyringPath, "auth", "get-or-create", "osd.0", "mon", `allow profile osd`, "osd", `allow *`, "mgr", `allow profile osd`, "-o", osdKeyringPath)
+// osdNew registers a new OSD in the cluster and returns the id allocated by the monitor
+func osdNew(osdUUID string) string {
+	log.Println("init osd: allocating osd id")
 
-	out, err := cmd.CombinedOutput()
+	osdID, err := cephClient().OsdNew(osdUUID)
 	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
 		log.Fatal(err)
 	}
-}
 
-func osdMkfs(monInitialKeyringPath string) {
-	log.Println("init osd: populating osd store")
+	return osdID
+}
 
-	cmd := exec.Command("ceph-osd", "--setuser", "ceph", "--setgroup", "ceph", "--conf", cephConfFilePath, "--mkfs", "-i", "0", "--osd-data", osdDataPath)
+func generateOsdKeyring(monKeyringPath, osdID string) {
+	log.Println("init osd: generating osd." + osdID + " keyring")
 
-	out, err := cmd.CombinedOutput()
+	err := cephClient().As("mon.", monKeyringPath).AuthGetOrCreate("osd."+osdID, osdKeyringPath(osdID), "mon", `allow profile osd`, "osd", `allow *`, "mgr", `allow profile osd`)
 	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
 		log.Fatal(err)
 	}
 }
 
-func osdStart() {
-	log.Println("init osd: running osd")
-	memAvailable := getAvailableRAM()
+func osdMkfs(osdID, osdUUID string) {
+	log.Println("init osd: populating osd." + osdID + " store")
+
+	cmd := exec.Command("ceph-osd", "--setuser", "ceph", "--setgroup", "ceph", "--conf", cephConfFilePath, "--mkfs", "-i", osdID, "--osd-uuid", osdUUID, "--osd-data", osdDataPath(osdID))
+
+	runCommandOrExit(cmd)
+}
+
+func osdStart(osdID string, memAvailable uint64) {
+	log.Println("init osd: running osd." + osdID)
 	osdMemoryTarget, osdMemoryBase, osdMemoryCacheMin := tuneMemory(memAvailable)
 
-	cmd := exec.Command("ceph-osd", "--setuser", "ceph", "--setgroup", "ceph", "-i", "0",
-		"--osd-crush-chooseleaf-type", osdCrushChooseleafType,
-		"--osd-journal-size", osdJournalSize,
-		"--osd-pool-default-size", osdPoolDefaultSize,
-		"--osd-objectstore", osdObjectstore,
+	// the options of ceph.conf are read from there, the command line would override the drop-ins and CEPH_CONF_ variables
+	// the memory options depend on the memory left when the OSD starts, they are not in ceph.conf
+	cmd := exec.Command("ceph-osd", "--setuser", "ceph", "--setgroup", "ceph", "-i", osdID,
 		"--osd-memory-target", strconv.FormatUint(osdMemoryTarget, 10),
 		"--osd-memory-base", strconv.FormatUint(osdMemoryBase, 10),
-		"--osd-memory-cache-min", strconv.FormatUint(osdMemoryCacheMin, 10),
-		"--bluestore-block-size", bluestoreBlockSize)
+		"--osd-memory-cache-min", strconv.FormatUint(osdMemoryCacheMin, 10))
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+	cmd.Args = append(cmd.Args, "-f")
+	if supervising() {
+		superviseDaemon("osd."+osdID, cmd, true)
+		return
+	}
+
+	if err := startCommand(cmd); err != nil {
 		log.Fatal(err)
 	}
 }
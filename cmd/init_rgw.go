@@ -24,10 +24,11 @@ package cmd
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"time"
 )
 
 const (
@@ -38,6 +39,8 @@ const (
 	rgwUsageLogFlushThreshold = "1"
 	rgwUsageMaxShards         = "32"
 	rgwUsageMaxUserShards     = "1"
+	// rgwWaitTimeout is how long Rados Gateway has to answer before cn-core sends it S3 requests
+	rgwWaitTimeout = 2 * time.Minute
 )
 
 func bootstrapRgw() {
@@ -50,49 +53,69 @@ func bootstrapRgw() {
 	monKeyringPath := monDataPath + "/keyring"
 	rgwDataPath := cephDataPath + "/radosgw/ceph-rgw." + hostname
 	rgwKeyringPath := rgwDataPath + "/keyring"
-	rgwHost := hostname + ":" + rgwPort
 
 	// if there is no key, we assume there is no monitor
-	if _, err := os.Stat(rgwKeyringPath); os.IsNotExist(err) {
-		// run prereq
-		rgwPreReq(rgwDataPath)
+	if _, err := stat(rgwKeyringPath); os.IsNotExist(err) {
+		runStep("rgw", journalStep{Name: "keyring", Paths: []string{rgwKeyringPath}}, func() {
+			// run prereq
+			rgwPreReq(rgwDataPath)
 
-		// generate rgw keyring
-		generateRgwKeyring(hostname, rgwKeyringPath)
+			// generate rgw keyring
+			generateRgwKeyring(hostname, rgwKeyringPath)
 
-		// chown rgw keyring
-		err = os.Chown(rgwKeyringPath, cephUID, cephGID)
-		if err != nil {
-			log.Fatal(err)
-		}
+			// chown rgw keyring
+			err := chown(rgwKeyringPath, cephUID, cephGID)
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+	} else {
+		skipStep("rgw keyring " + rgwKeyringPath + " already exists")
+	}
+
+	if conf.rgwTLS() {
+		setupRgwTLS(hostname)
 	}
 
 	// start rgw!
-	rgwStart(hostname, rgwKeyringPath)
+	rgwStart(hostname)
 
 	// create cn user
-	if _, err := os.Stat(cnUserDetailsFile); os.IsNotExist(err) {
-		// create cn user
-		cnUserDetails, err := rgwCreateUser(monKeyringPath)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if _, err := stat(cnUserDetailsFile); os.IsNotExist(err) {
+		runStep("rgw", journalStep{Name: "cn-user", Paths: []string{cnUserDetailsFile, "/nano_user_details"}}, func() {
+			// create cn user
+			cnUserDetails, err := rgwCreateUser(monKeyringPath)
+			if err != nil {
+				log.Fatal(err)
+			}
 
-		// write cn user details to a file
-		err = ioutil.WriteFile(cnUserDetailsFile, cnUserDetails, 0644)
-		if err != nil {
-			log.Fatal(err)
-		}
+			// write cn user details to a file
+			err = writeFile(cnUserDetailsFile, cnUserDetails, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
 
-		// symlink for seemless transition between cn-core and demo.sh
-		// so cn can find the credentials
-		err = os.Symlink(cnUserDetailsFile, "/nano_user_details")
-		if err != nil {
-			log.Fatal(err)
-		}
+			// symlink for seemless transition between cn-core and demo.sh
+			// so cn can find the credentials
+			err = symlink(cnUserDetailsFile, "/nano_user_details")
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+	} else {
+		skipStep("cn user details " + cnUserDetailsFile + " already exist")
+	}
+
+	// configure s3cmd on every start, the keys or the address may have changed
+	configureClients("s3cmd")
 
-		// configure s3cmd
-		configureClients("s3cmd", rgwHost)
+	// the fixtures are applied on every start too, once the cn user exists
+	if conf.Fixtures != "" {
+		applyFixtures()
+	}
+	// then the seed objects, in the buckets of the fixtures or in new ones
+	if conf.SeedDir != "" {
+		seedObjects()
 	}
 }
 
@@ -100,12 +123,12 @@ func rgwPreReq(rgwDataPath string) {
 	log.Println("init rgw: run prerequisites")
 	dirs := [2]string{cephLogPath, rgwDataPath}
 	for _, dir := range dirs {
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			err = os.MkdirAll(dir, 0755)
+		if _, err := stat(dir); os.IsNotExist(err) {
+			err = mkdirAll(dir, 0755)
 			if err != nil {
 				log.Fatal(err)
 			}
-			err = os.Chown(dir, cephUID, cephGID)
+			err = chown(dir, cephUID, cephGID)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -116,50 +139,79 @@ func rgwPreReq(rgwDataPath string) {
 func generateRgwKeyring(hostname, rgwKeyringPath string) {
 	log.Println("init rgw: generating rgw keyring")
 
-	cmd := exec.Command("ceph", "auth", "get-or-create", "client.rgw."+hostname, "mon", `allow rw`, "osd", `allow rwx`, "-o", rgwKeyringPath)
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+	if err := cephClient().AuthGetOrCreate("client.rgw."+hostname, rgwKeyringPath, "mon", `allow rw`, "osd", `allow rwx`); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func rgwStart(hostname, rgwKeyringPath string) {
-	log.Println("init rgw: running rgw on port " + rgwPort)
-	rgwDNSName := hostname
-	rgwLogFile := "/var/log/ceph/client.rgw." + hostname + ".log"
-	rgwFrontends := rgwEngine + " endpoint=0.0.0.0:" + rgwPort
-	cmd := exec.Command("radosgw", "--setuser", "ceph", "--setgroup", "ceph", "-n", "client.rgw."+hostname, "-k", rgwKeyringPath,
-		"--rgw-dns-name", rgwDNSName,
-		"--rgw-enable-usage-log", rgwEnableUsageLog,
-		"--rgw-usage-log-tick-interval", rgwUsageLogTickInterval,
-		"--rgw-usage-log-flush-threshold", rgwUsageLogFlushThreshold,
-		"--rgw-usage-max-shards", rgwUsageMaxShards,
-		"--rgw-usage-max-user-shards", rgwUsageMaxUserShards,
-		"--log-file", rgwLogFile,
-		"--rgw-frontends", rgwFrontends)
-
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
+// rgwOptions returns the options Rados Gateway runs with, they are written to ceph.conf
+func rgwOptions(hostname string) [][2]string {
+	return [][2]string{
+		{"rgw dns name", hostname},
+		{"rgw enable usage log", rgwEnableUsageLog},
+		{"rgw usage log tick interval", rgwUsageLogTickInterval},
+		{"rgw usage log flush threshold", rgwUsageLogFlushThreshold},
+		{"rgw usage max shards", rgwUsageMaxShards},
+		{"rgw usage max user shards", rgwUsageMaxUserShards},
+		{"log file", "/var/log/ceph/client.rgw." + hostname + ".log"},
+		{"rgw frontends", rgwFrontends()},
+	}
+}
+
+// rgwFrontends returns the beast frontend, with an HTTPS endpoint next to the HTTP one when TLS is enabled
+func rgwFrontends() string {
+	frontends := rgwEngine + " endpoint=" + net.JoinHostPort(conf.bindAddress(), conf.RgwPort)
+	if conf.rgwTLS() {
+		cert, key, _ := conf.rgwTLSFiles()
+		frontends += " ssl_endpoint=" + net.JoinHostPort(conf.bindAddress(), conf.RgwTLSPort) + " ssl_certificate=" + cert + " ssl_private_key=" + key
+	}
+	return frontends
+}
+
+func rgwStart(hostname string) {
+	if conf.rgwTLS() {
+		log.Println("init rgw: running rgw on port " + conf.RgwPort + " and " + conf.RgwTLSPort + " (https)")
+	} else {
+		log.Println("init rgw: running rgw on port " + conf.RgwPort)
+	}
+	// the keyring and rgwOptions come from the client.rgw section of ceph.conf, so the drop-ins and CEPH_CONF_ variables apply
+	cmd := exec.Command("radosgw", "--setuser", "ceph", "--setgroup", "ceph", "-n", "client.rgw."+hostname)
+
+	cmd.Args = append(cmd.Args, "-f")
+	if supervising() {
+		superviseDaemon("rgw", cmd, true)
+		return
+	}
+
+	if err := startCommand(cmd); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// waitRgw waits for Rados Gateway to answer before cn-core sends it S3 requests
+func waitRgw(timeout time.Duration) error {
+	if dryRun {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := probeHTTP(conf.localAddr(conf.RgwPort))
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("rados gateway is not answering after %s: %v", timeout, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
 func rgwCreateUser(monKeyringPath string) ([]byte, error) {
 	log.Println("init rgw: creating rgw user")
 
 	cmd := exec.Command("radosgw-admin", "user", "create", "--uid="+cnCoreRgwUserUID, "--display-name=Ceph Nano user", "--caps=buckets=*;users=*;usage=*;metadata=*")
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Printf("The command was: %s\n", cmd.Args)
-		fmt.Printf("The error was: %s\n", out)
-		log.Fatal(err)
-	}
+	out := runCommandOrExit(cmd)
 
 	return out, nil
 }
@@ -26,8 +26,6 @@ import (
 	"log"
 	"os"
 	"os/exec"
-
-	"github.com/mholt/archiver"
 )
 
 const (
@@ -35,41 +33,35 @@ const (
 	dashboardDir          = dashboardDirExtractTo + "Sree-0.1/"
 )
 
-var (
-	dashPort = "5000"
-)
-
 func bootstrapSree() {
-	if _, err := os.Stat(dashboardDirExtractTo); os.IsNotExist(err) {
-		// Read ENV and search for a value for dashPort
-		dashPortEnv := os.Getenv("SREE_PORT")
-		if len(dashPortEnv) > 0 {
-			dashPort = dashPortEnv
-		}
-
-		// run pre-req
-		sreePreReq()
-
-		// untar dashboard -  /opt/ceph-container/tmp/
-		err := archiver.Unarchive("/opt/ceph-container/tmp/sree.tar.gz", dashboardDirExtractTo)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if _, err := stat(dashboardDirExtractTo); os.IsNotExist(err) {
+		runStep("dash", journalStep{Name: "extract", Paths: []string{dashboardDirExtractTo}}, func() {
+			// run pre-req
+			sreePreReq()
 
-		// Always run this, after reboot the IP might change on the host (EXPOSED_IP)
-		// This is coming from cn itself
-		// configure sree dashboard
-		configureClients("dashboard")
+			// untar dashboard -  /opt/ceph-container/tmp/
+			err := unarchive("/opt/ceph-container/tmp/sree.tar.gz", dashboardDirExtractTo)
+			if err != nil {
+				log.Fatal(err)
+			}
+		})
+	} else {
+		skipStep("dashboard already extracted in " + dashboardDirExtractTo)
 	}
 
+	// Always run this, after reboot the IP might change on the host (EXPOSED_IP)
+	// This is coming from cn itself
+	// configure sree dashboard
+	configureClients("dashboard")
+
 	// start cn dashboard!
 	sreeStart()
 }
 
 func sreePreReq() {
 	log.Println("init dashboard: run prerequisites")
-	if _, err := os.Stat(dashboardDirExtractTo); os.IsNotExist(err) {
-		err = os.MkdirAll(dashboardDirExtractTo, 0755)
+	if _, err := stat(dashboardDirExtractTo); os.IsNotExist(err) {
+		err = mkdirAll(dashboardDirExtractTo, 0755)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -77,12 +69,18 @@ func sreePreReq() {
 }
 
 func sreeStart() {
-	log.Println("init dashboard: running dashboard on port " + dashPort)
+	log.Println("init dashboard: running dashboard on port " + conf.DashPort)
 
 	cmd := exec.Command("python", "app.py")
 	cmd.Dir = dashboardDir
 
-	err := cmd.Start()
+	// the dashboard is not required to serve S3
+	if supervising() {
+		superviseDaemon("dash", cmd, false)
+		return
+	}
+
+	err := startCommand(cmd)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,198 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	journalDir     = cephDataPath + "/cn-core"
+	journalVersion = 1
+)
+
+// journalStep is a bootstrap step, with what to roll back if it was interrupted
+type journalStep struct {
+	Name    string   `json:"name"`
+	Paths   []string `json:"paths,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+}
+
+// bootstrapJournal records the bootstrap steps of a daemon that completed, so an interrupted init resumes where it stopped
+// Each daemon has its own journal, the daemons of a cluster may be bootstrapped by different containers
+type bootstrapJournal struct {
+	Version   int               `json:"version"`
+	Completed []journalStep     `json:"completed"`
+	Pending   *journalStep      `json:"pending,omitempty"`
+	Values    map[string]string `json:"values,omitempty"`
+
+	daemon string
+}
+
+func journalPath(daemon string) string {
+	return journalDir + "/journal-" + daemon + ".json"
+}
+
+// loadJournal reads the journal of a daemon, a daemon that was never bootstrapped has an empty one
+func loadJournal(daemon string) *bootstrapJournal {
+	j := &bootstrapJournal{Version: journalVersion, daemon: daemon}
+
+	data, err := ioutil.ReadFile(hostPath(journalPath(daemon)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, j); err != nil {
+			log.Fatalf("init %s: failed to parse the journal %s: %v", daemon, journalPath(daemon), err)
+		}
+		if j.Version != journalVersion {
+			log.Fatalf("init %s: unsupported journal version %d in %s", daemon, j.Version, journalPath(daemon))
+		}
+	}
+	if j.Values == nil {
+		j.Values = map[string]string{}
+	}
+
+	return j
+}
+
+// save replaces the journal atomically, a dry run leaves it untouched
+func (j *bootstrapJournal) save() {
+	if dryRun {
+		return
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(hostPath(journalDir), 0700); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeFile(journalPath(j.daemon), data, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (j *bootstrapJournal) completed(name string) bool {
+	for _, step := range j.Completed {
+		if step.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runStep runs a bootstrap step of a daemon unless the journal says it already completed
+// The step is recorded as pending before it runs, so an interrupted step can be rolled back on the next init
+func runStep(daemon string, step journalStep, fn func()) {
+	j := loadJournal(daemon)
+	if j.completed(step.Name) {
+		log.Printf("init %s: %s already completed", daemon, step.Name)
+		skipStep(step.Name + " already completed according to " + journalPath(daemon))
+		return
+	}
+
+	j.Pending = &step
+	j.save()
+
+	fn()
+
+	// the step may have stored values in the meantime
+	j = loadJournal(daemon)
+	j.Pending = nil
+	j.Completed = append(j.Completed, step)
+	j.save()
+}
+
+// journalValue returns a value a step of a daemon stored, e.g: an id allocated by the monitor
+func journalValue(daemon, key string) string {
+	return loadJournal(daemon).Values[key]
+}
+
+func setJournalValue(daemon, key, value string) {
+	j := loadJournal(daemon)
+	j.Values[key] = value
+	j.save()
+}
+
+// recoverJournal rolls back the step an interrupted init was running for each daemon, the bootstrap then resumes from it
+func recoverJournal(daemons ...string) {
+	for _, daemon := range daemons {
+		j := loadJournal(daemon)
+		if j.Pending == nil {
+			continue
+		}
+
+		log.Printf("init %s: rolling back %s, it was interrupted", daemon, j.Pending.Name)
+		rollbackStep(*j.Pending)
+		j.Pending = nil
+		j.save()
+	}
+}
+
+// rollbackStep removes what an interrupted step may have left behind
+func rollbackStep(step journalStep) {
+	for _, device := range step.Devices {
+		log.Println("init: zapping " + device)
+		zapDevice(device)
+	}
+	for _, path := range step.Paths {
+		log.Println("init: removing " + path)
+		if err := removeAll(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// repairJournal rolls back the interrupted steps and forgets the completed steps whose files are gone, so the next init runs them again
+func repairJournal(daemons ...string) {
+	recoverJournal(daemons...)
+
+	for _, daemon := range daemons {
+		j := loadJournal(daemon)
+		var completed []journalStep
+		for _, step := range j.Completed {
+			var missing []string
+			for _, path := range step.Paths {
+				if _, err := os.Lstat(hostPath(path)); os.IsNotExist(err) {
+					missing = append(missing, path)
+				}
+			}
+			if len(missing) > 0 {
+				log.Printf("init %s: %s completed but %s is missing, it will run again", daemon, step.Name, strings.Join(missing, ", "))
+				continue
+			}
+			completed = append(completed, step)
+		}
+
+		if len(completed) != len(j.Completed) {
+			j.Completed = completed
+			j.save()
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRoot points fsRoot to a temporary directory, the cleanup restores the globals the tests change
+func testRoot(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "cn-core-root")
+	assert.Nil(t, err)
+	fsRoot = root
+
+	return root, func() {
+		fsRoot, runner = "/", execRunner{}
+		conf = defaultConfig()
+		os.RemoveAll(root)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	assert.Nil(t, writeFile("/s3cfg", []byte("access_key = AWS_ACCESS_KEY_PLACEHOLDER\n"), 0644))
+	assert.Nil(t, writeFile("/s3cfg", []byte("access_key = CN4CCESSKEY0000000000\n"), 0600))
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "s3cfg"))
+	assert.Nil(t, err)
+	assert.Equal(t, "access_key = CN4CCESSKEY0000000000\n", string(content))
+
+	// the file is replaced with its new mode and no temporary file is left behind
+	info, err := os.Stat(filepath.Join(root, "s3cfg"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := ioutil.ReadDir(root)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestRunStep(t *testing.T) {
+	_, cleanup := testRoot(t)
+	defer cleanup()
+
+	runs := 0
+	step := journalStep{Name: "keyring", Paths: []string{"/keyring"}}
+	fn := func() {
+		// the step is pending while it runs
+		assert.Equal(t, &step, loadJournal("mgr").Pending)
+		runs++
+	}
+
+	runStep("mgr", step, fn)
+	runStep("mgr", step, fn)
+	assert.Equal(t, 1, runs)
+
+	j := loadJournal("mgr")
+	assert.Nil(t, j.Pending)
+	assert.Equal(t, []journalStep{step}, j.Completed)
+
+	// every daemon has its own journal
+	runStep("rgw", journalStep{Name: "keyring"}, func() {
+		runs++
+	})
+	assert.Equal(t, 2, runs)
+}
+
+func TestRecoverJournal(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	// the previous init was killed while populating the osd store and preparing a device
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "var/lib/ceph/osd/ceph-0"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "var/lib/ceph/osd/ceph-0/keyring"), []byte{}, 0600))
+	j := loadJournal("osd")
+	j.Pending = &journalStep{Name: "osd-0-mkfs", Paths: []string{osdDataPath("0")}, Devices: []string{"/dev/sdb"}}
+	j.save()
+
+	replay := &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph-volume", "lvm", "zap", "--destroy", "/dev/sdb"}},
+	}}
+	runner = replay
+	recoverJournal("mon", "osd")
+	assert.Equal(t, 0, replay.remaining())
+
+	_, err := os.Stat(filepath.Join(root, "var/lib/ceph/osd/ceph-0"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, loadJournal("osd").Pending)
+	assert.Empty(t, existingOsdDirectories())
+}
+
+func TestResumeOsdDirectories(t *testing.T) {
+	_, cleanup := testRoot(t)
+	defer cleanup()
+
+	// the previous init allocated osd.0 then stopped before populating its store
+	osdUUID := "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a"
+	setJournalValue("osd", "osd-0-uuid", osdUUID)
+	runStep("osd", journalStep{Name: "osd-0-new"}, func() {
+		setJournalValue("osd", "osd-0-id", "0")
+	})
+
+	monKeyringPath := "/var/lib/ceph/mon/ceph-host/keyring"
+	replay := &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph", "-n", "mon.", "-k", monKeyringPath, "auth", "get-or-create", "osd.0", "mon", "allow profile osd", "osd", "allow *", "mgr", "allow profile osd", "-o", osdKeyringPath("0")}},
+		{Args: []string{"ceph-osd", "--setuser", "ceph", "--setgroup", "ceph", "--conf", cephConfFilePath, "--mkfs", "-i", "0", "--osd-uuid", osdUUID, "--osd-data", osdDataPath("0")}},
+	}}
+	runner = replay
+
+	// 'ceph osd new' does not run again
+	assert.Equal(t, []string{"0"}, bootstrapOsdDirectories(monKeyringPath, 1))
+	assert.Equal(t, 0, replay.remaining())
+}
+
+func TestRepairJournal(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "etc/ceph"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "etc/ceph/initial-mon-keyring"), []byte{}, 0600))
+	runStep("mon", journalStep{Name: "initial-keyring", Paths: []string{monInitialKeyringPath}}, func() {})
+	runStep("mon", journalStep{Name: "monmap", Paths: []string{monMapPath}}, func() {})
+
+	repairJournal("mon")
+
+	// the monmap is gone, the next init generates it again
+	j := loadJournal("mon")
+	assert.True(t, j.completed("initial-keyring"))
+	assert.False(t, j.completed("monmap"))
+}
+
+func TestDryRunJournal(t *testing.T) {
+	_, cleanup := testRoot(t)
+	defer cleanup()
+	runStep("rgw", journalStep{Name: "keyring"}, func() {})
+
+	dryRun = true
+	plan = &bootstrapPlan{}
+	defer func() {
+		dryRun = false
+		plan = &bootstrapPlan{}
+	}()
+
+	runStep("rgw", journalStep{Name: "keyring"}, func() {
+		t.Fatal("a completed step runs again")
+	})
+	runStep("rgw", journalStep{Name: "cn-user"}, func() {})
+
+	// a dry run leaves the journal untouched
+	assert.False(t, loadJournal("rgw").completed("cn-user"))
+	assert.Equal(t, 1, len(plan.Steps))
+	assert.Equal(t, "skip", plan.Steps[0].Action)
+}
+
+func TestResumeOsdDevicePrepare(t *testing.T) {
+	_, cleanup := testRoot(t)
+	defer cleanup()
+
+	// the previous init was killed after ceph-volume registered osd.0
+	staleFsid := "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a"
+	setJournalValue("osd", "prepare-/dev/sdb-fsid", staleFsid)
+	j := loadJournal("osd")
+	j.Pending = &journalStep{Name: "prepare-/dev/sdb", Devices: []string{"/dev/sdb"}}
+	j.save()
+
+	osdFsid := "0b5d4a56-e5b2-4c6a-8a8d-7e1c3d0c5a11"
+	lvmList := `{"0": [{"devices": ["/dev/sdb"], "type": "block", "tags": {"ceph.osd_id": "0", "ceph.osd_fsid": "` + osdFsid + `"}}]}`
+	replay := &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph-volume", "lvm", "zap", "--destroy", "/dev/sdb"}},
+		{Args: []string{"ceph-volume", "lvm", "list", "--format", "json"}, Output: "{}\n"},
+		{Args: []string{"ceph", "auth", "export", "client.bootstrap-osd", "-o", osdBootstrapKeyring}},
+		{Args: []string{"ceph", "osd", "dump", "--format", "json"}, Output: `{"osds": [{"osd": 0, "uuid": "` + staleFsid + `"}]}`},
+		{Args: []string{"ceph", "osd", "purge", "0", "--yes-i-really-mean-it"}},
+		{Args: []string{"ceph-volume", "lvm", "prepare", "--osd-fsid", transcriptAnyArg, "--data", "/dev/sdb"}},
+		{Args: []string{"ceph-volume", "lvm", "list", "--format", "json"}, Output: lvmList},
+		{Args: []string{"ceph-volume", "lvm", "activate", "--no-systemd", "--bluestore", "0", osdFsid}},
+	}}
+	runner = replay
+	recoverJournal("osd")
+
+	// the stale osd id is purged, not reused with the new cephx key ceph-volume generates
+	osds := bootstrapOsdDevices([]string{"/dev/sdb"})
+	assert.Equal(t, 0, replay.remaining())
+	assert.Equal(t, "0", osds["/dev/sdb"].ID)
+	assert.NotEqual(t, staleFsid, journalValue("osd", "prepare-/dev/sdb-fsid"))
+	assert.True(t, loadJournal("osd").completed("prepare-/dev/sdb"))
+}
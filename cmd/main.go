@@ -64,6 +64,15 @@ func Main(version string) {
 func init() {
 	rootCmd.AddCommand(
 		cliInitCluster(),
+		cliStatus(),
+		cliBackup(),
+		cliRestore(),
+		cliPurge(),
+		cliUser(),
+		cliCredentials(),
+		cliS3(),
+		cliDaemon(),
+		cliConfig(),
 		cliVersionCnCore(),
 	)
 	rootCmd.SetHelpCommand(&cobra.Command{
@@ -0,0 +1,186 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// monIPAuto makes cn-core use the primary address of the container for the monitor
+	monIPAuto = "auto"
+
+	procNetRoute     = "/proc/net/route"
+	procNetIPv6Route = "/proc/net/ipv6_route"
+)
+
+// detectPrimaryAddress is replaced by the tests
+var detectPrimaryAddress = primaryAddress
+
+// primaryAddress returns the address of the interface holding the default route, IPv4 first
+// Without a default route, the first global address of any interface is used
+func primaryAddress() (net.IP, string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var names []string
+	for _, path := range []string{procNetRoute, procNetIPv6Route} {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if name := defaultRouteInterface(f); name != "" {
+			names = append(names, name)
+		}
+		f.Close()
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			names = append(names, iface.Name)
+		}
+	}
+
+	for _, name := range names {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		if ip := pickAddress(addrs); ip != nil {
+			return ip, name, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("no interface has a global address")
+}
+
+// defaultRouteInterface returns the interface of the default route from /proc/net/route or /proc/net/ipv6_route
+func defaultRouteInterface(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		case len(fields) == 11 && fields[1] == "00000000" && fields[7] == "00000000":
+			return fields[0]
+		// destination, prefix length, source, source prefix length, next hop, metric, refcnt, use, flags, iface
+		// the kernel lists unreachable default routes on lo
+		case len(fields) == 10 && fields[0] == strings.Repeat("0", 32) && fields[1] == "00" && fields[9] != "lo":
+			return fields[9]
+		}
+	}
+	return ""
+}
+
+// pickAddress returns the first global unicast address, IPv4 first
+func pickAddress(addrs []net.Addr) net.IP {
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	return ipv6
+}
+
+// isIPv6 tells if an address is an IPv6 one, IPv4-mapped addresses are IPv4
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// resolveMonIP replaces mon_ip 'auto' by the detected address
+func (c *cnConfig) resolveMonIP() error {
+	if c.MonIP != monIPAuto {
+		return nil
+	}
+	ip, iface, err := detectPrimaryAddress()
+	if err != nil {
+		return fmt.Errorf("config: failed to detect mon_ip: %v", err)
+	}
+	c.MonIP = ip.String()
+	c.sources["mon_ip"] += ", detected on " + iface
+
+	return nil
+}
+
+// monAddr returns the msgr2 address of the monitor, IPv6 addresses are bracketed
+func (c *cnConfig) monAddr() string {
+	return net.JoinHostPort(c.MonIP, monPort)
+}
+
+// monHost returns the 'mon host' of ceph.conf, with both protocols
+func (c *cnConfig) monHost() string {
+	return "[v2:" + c.monAddr() + ",v1:" + net.JoinHostPort(c.MonIP, monV1Port) + "]"
+}
+
+// publicNetwork returns the public network, every address of the monitor family by default
+func (c *cnConfig) publicNetwork() string {
+	if c.PublicNetwork != "" {
+		return c.PublicNetwork
+	}
+	if isIPv6(c.MonIP) {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// bindAddress returns the address Rados Gateway and the ceph dashboard listen on, every address of the monitor family by default
+func (c *cnConfig) bindAddress() string {
+	if c.BindAddress != "" {
+		return c.BindAddress
+	}
+	if isIPv6(c.MonIP) {
+		return "::"
+	}
+	return "0.0.0.0"
+}
+
+// localAddr returns the address a port bound on bind_address is reached on from the container
+func (c *cnConfig) localAddr(port string) string {
+	host := c.bindAddress()
+	if ip := net.ParseIP(host); ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
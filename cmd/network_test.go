@@ -0,0 +1,108 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testProcNetRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	0011A8C0	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth1	00000000	0111A8C0	0003	0	0	0	00000000	0	0	0
+`
+	testProcNetIPv6Route = `fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+`
+)
+
+func TestDefaultRouteInterface(t *testing.T) {
+	assert.Equal(t, "eth1", defaultRouteInterface(strings.NewReader(testProcNetRoute)))
+	assert.Equal(t, "eth0", defaultRouteInterface(strings.NewReader(testProcNetIPv6Route)))
+	assert.Equal(t, "", defaultRouteInterface(strings.NewReader("Iface\tDestination\n")))
+}
+
+func TestPickAddress(t *testing.T) {
+	parse := func(cidrs ...string) []net.Addr {
+		var addrs []net.Addr
+		for _, cidr := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(cidr)
+			assert.Nil(t, err)
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs
+	}
+
+	assert.Equal(t, "192.168.17.2", pickAddress(parse("fe80::1/64", "fd00::2/64", "192.168.17.2/24")).String())
+	assert.Equal(t, "fd00::2", pickAddress(parse("127.0.0.1/8", "fe80::1/64", "fd00::2/64")).String())
+	assert.Nil(t, pickAddress(parse("127.0.0.1/8", "fe80::1/64")))
+}
+
+func TestMonAddresses(t *testing.T) {
+	c := defaultConfig()
+	assert.Equal(t, "127.0.0.1:3300", c.monAddr())
+	assert.Equal(t, "[v2:127.0.0.1:3300,v1:127.0.0.1:6789]", c.monHost())
+	assert.Equal(t, "0.0.0.0/0", c.publicNetwork())
+	assert.Equal(t, "0.0.0.0", c.bindAddress())
+	assert.Equal(t, "127.0.0.1:8000", c.localAddr(c.RgwPort))
+
+	c.MonIP = "fd00::2"
+	assert.Equal(t, "[fd00::2]:3300", c.monAddr())
+	assert.Equal(t, "[v2:[fd00::2]:3300,v1:[fd00::2]:6789]", c.monHost())
+	assert.Equal(t, "::/0", c.publicNetwork())
+	assert.Equal(t, "::", c.bindAddress())
+	assert.Equal(t, "[::1]:8000", c.localAddr(c.RgwPort))
+
+	c.PublicNetwork, c.BindAddress = "fd00::/64", "fd00::3"
+	assert.Equal(t, "fd00::/64", c.publicNetwork())
+	assert.Equal(t, "[fd00::3]:8000", c.localAddr(c.RgwPort))
+}
+
+func TestResolveMonIP(t *testing.T) {
+	defer func() { detectPrimaryAddress = primaryAddress }()
+	detectPrimaryAddress = func() (net.IP, string, error) {
+		return net.ParseIP("192.168.17.2"), "eth0", nil
+	}
+
+	c := defaultConfig()
+	assert.Nil(t, c.resolveMonIP())
+	assert.Equal(t, "127.0.0.1", c.MonIP)
+
+	c.MonIP, c.sources["mon_ip"] = monIPAuto, "env (MON_IP)"
+	assert.Nil(t, c.resolveMonIP())
+	assert.Equal(t, "192.168.17.2", c.MonIP)
+	assert.Equal(t, "env (MON_IP), detected on eth0", c.sources["mon_ip"])
+
+	detectPrimaryAddress = func() (net.IP, string, error) {
+		return nil, "", fmt.Errorf("no interface has a global address")
+	}
+	c.MonIP = monIPAuto
+	assert.EqualError(t, c.resolveMonIP(), "config: failed to detect mon_ip: no interface has a global address")
+}
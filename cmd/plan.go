@@ -0,0 +1,239 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver"
+)
+
+// planStep is a single action the bootstrap would take
+type planStep struct {
+	Daemon string   `json:"daemon"`
+	Action string   `json:"action"`
+	Path   string   `json:"path,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// bootstrapPlan is the ordered list of actions recorded during a dry run
+type bootstrapPlan struct {
+	Config map[string]string `json:"config"`
+	Steps  []planStep        `json:"steps"`
+
+	daemon string
+}
+
+var (
+	// dryRun, when set, records the bootstrap actions instead of executing them
+	dryRun bool
+	plan   = &bootstrapPlan{}
+
+	// fsRoot is prepended to every path cn-core writes, tests point it to a temporary directory
+	fsRoot = "/"
+)
+
+func (p *bootstrapPlan) record(action, path, reason string, args []string) {
+	p.Steps = append(p.Steps, planStep{Daemon: p.daemon, Action: action, Path: path, Args: args, Reason: reason})
+}
+
+// bootstrap runs the bootstrap function of a daemon, labelling the plan with its name
+func bootstrap(name string, fn func()) {
+	plan.daemon = name
+	fn()
+	probes.markBootstrapped(name)
+}
+
+// planRunner records the commands in the plan instead of running them
+type planRunner struct{}
+
+func (planRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	plan.record("run", "", "", cmd.Args)
+	return nil, nil
+}
+
+func (planRunner) Start(cmd *exec.Cmd) error {
+	plan.record("start", cmd.Dir, "", cmd.Args)
+	return nil
+}
+
+// hostPath returns where a path cn-core manages lives on the filesystem
+func hostPath(path string) string {
+	return filepath.Join(fsRoot, path)
+}
+
+func stat(path string) (os.FileInfo, error) {
+	return os.Stat(hostPath(path))
+}
+
+// writeFile writes to a temporary file next to path and renames it over path
+// A crash leaves either the previous content or the new one, never a truncated file
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if dryRun {
+		plan.record("write", path, "", nil)
+		return nil
+	}
+
+	dest := hostPath(path)
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func mkdirAll(path string, perm os.FileMode) error {
+	if dryRun {
+		plan.record("mkdir", path, "", nil)
+		return nil
+	}
+	return os.MkdirAll(hostPath(path), perm)
+}
+
+// chown is not recorded in the plan, it always follows a write or a mkdir
+// Files under a relocated root belong to whoever runs the tests so they are left alone
+func chown(path string, uid, gid int) error {
+	if dryRun || fsRoot != "/" {
+		return nil
+	}
+	return os.Chown(hostPath(path), uid, gid)
+}
+
+func symlink(oldname, newname string) error {
+	if dryRun {
+		plan.record("symlink", newname, "", []string{oldname})
+		return nil
+	}
+	return os.Symlink(oldname, hostPath(newname))
+}
+
+func removeAll(path string) error {
+	if dryRun {
+		plan.record("remove", path, "", nil)
+		return nil
+	}
+	return os.RemoveAll(hostPath(path))
+}
+
+func unarchive(source, destination string) error {
+	if dryRun {
+		plan.record("extract", destination, "", []string{source})
+		return nil
+	}
+	return archiver.Unarchive(hostPath(source), hostPath(destination))
+}
+
+// skipStep records a step that does not need to run because it already happened
+func skipStep(reason string) {
+	if dryRun {
+		plan.record("skip", "", reason, nil)
+	}
+}
+
+func validatePlanFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("init: unknown format %q, valid choices are: text, json", format)
+	}
+	return nil
+}
+
+// printPlan writes the recorded plan in the requested format
+func printPlan(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+	case "text":
+		fmt.Fprintln(w, "Configuration:")
+		for _, opt := range conf.options() {
+			fmt.Fprintf(w, "  %s = %s\n", opt.key, *opt.value)
+		}
+		fmt.Fprintln(w, "\nBootstrap plan:")
+		for i, step := range plan.Steps {
+			fmt.Fprintf(w, "  %2d. [%s] %-7s %s\n", i+1, step.Daemon, step.Action, describeStep(step))
+		}
+	default:
+		return validatePlanFormat(format)
+	}
+
+	return nil
+}
+
+func describeStep(step planStep) string {
+	switch step.Action {
+	case "run", "start":
+		quoted := make([]string, len(step.Args))
+		for i, arg := range step.Args {
+			quoted[i] = shellQuote(arg)
+		}
+		if step.Path != "" {
+			return strings.Join(quoted, " ") + " (in " + step.Path + ")"
+		}
+		return strings.Join(quoted, " ")
+	case "skip":
+		return step.Reason
+	case "symlink":
+		return step.Path + " -> " + strings.Join(step.Args, " ")
+	case "extract":
+		return strings.Join(step.Args, " ") + " into " + step.Path
+	case "s3":
+		return strings.Join(step.Args, " ")
+	}
+	return step.Path
+}
+
+// shellQuote quotes an argument so the printed command can be pasted in a shell
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n*;&|$'\"\\<>()") {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,68 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "osd.0", shellQuote("osd.0"))
+	assert.Equal(t, "'allow *'", shellQuote("allow *"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	assert.Equal(t, "''", shellQuote(""))
+}
+
+func TestDryRunPlan(t *testing.T) {
+	dryRun = true
+	runner = planRunner{}
+	plan = &bootstrapPlan{}
+	defer func() {
+		dryRun = false
+		runner = execRunner{}
+		plan = &bootstrapPlan{}
+	}()
+
+	bootstrap("mgr", func() {
+		generateMgrKeyring("host", "/tmp/keyring")
+		assert.Nil(t, writeFile("/nonexistent/file", []byte("data"), 0644))
+		skipStep("already done")
+	})
+
+	assert.Equal(t, 3, len(plan.Steps))
+	assert.Equal(t, planStep{Daemon: "mgr", Action: "run", Args: []string{"ceph", "auth", "get-or-create", "mgr.host", "mon", "allow *", "-o", "/tmp/keyring"}}, plan.Steps[0])
+	assert.Equal(t, "write", plan.Steps[1].Action)
+	assert.Equal(t, "skip", plan.Steps[2].Action)
+
+	var out bytes.Buffer
+	assert.Nil(t, printPlan(&out, "json"))
+	var decoded bootstrapPlan
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, plan.Steps, decoded.Steps)
+
+	assert.NotNil(t, printPlan(&out, "yaml"))
+}
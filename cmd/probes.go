@@ -0,0 +1,375 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ceph/cn-core/internal/ceph"
+)
+
+const (
+	// probeTimeout bounds every ceph command and HTTP request made by a probe
+	probeTimeout = 5 * time.Second
+	// probeCacheTTL avoids running the ceph commands on every request when probes are frequent
+	probeCacheTTL = 2 * time.Second
+)
+
+var (
+	// probeComponents are the components deployed by a full bootstrap, in bootstrap order
+	probeComponents = []string{"mon", "mgr", "osd", "rgw", "dash"}
+
+	// probeRunner runs the commands of the probes, they do not belong to the bootstrap transcript
+	probeRunner commandRunner = execRunner{}
+
+	// probes tracks the readiness of the components bootstrapped by initCluster
+	probes = newReadinessProbes()
+
+	errNotDeployed = errors.New("not deployed")
+)
+
+// probeResult is the cached outcome of a readiness check
+type probeResult struct {
+	err  error
+	when time.Time
+}
+
+// readinessProbes knows which components are expected and which ones finished their bootstrap
+type readinessProbes struct {
+	mu           sync.Mutex
+	expected     map[string]bool
+	bootstrapped map[string]bool
+	checks       map[string]func() error
+	cache        map[string]probeResult
+}
+
+func newReadinessProbes() *readinessProbes {
+	return &readinessProbes{
+		expected:     map[string]bool{},
+		bootstrapped: map[string]bool{},
+		checks: map[string]func() error{
+			"mon":  checkMon,
+			"mgr":  checkMgr,
+			"osd":  checkOsd,
+			"rgw":  checkRgw,
+			"dash": checkDash,
+		},
+		cache: map[string]probeResult{},
+	}
+}
+
+// expect declares the components this cn-core is going to bootstrap
+func (p *readinessProbes) expect(names ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, name := range names {
+		p.expected[name] = true
+	}
+}
+
+// markBootstrapped flips a component to checked once its bootstrap function returned
+func (p *readinessProbes) markBootstrapped(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bootstrapped[name] = true
+}
+
+// check tells if a component is serving
+func (p *readinessProbes) check(name string) error {
+	p.mu.Lock()
+	if !p.expected[name] {
+		p.mu.Unlock()
+		return errNotDeployed
+	}
+	if !p.bootstrapped[name] {
+		p.mu.Unlock()
+		return errors.New("bootstrap in progress")
+	}
+	if result, ok := p.cache[name]; ok && time.Since(result.when) < probeCacheTTL {
+		p.mu.Unlock()
+		return result.err
+	}
+	check := p.checks[name]
+	p.mu.Unlock()
+
+	err := check()
+
+	p.mu.Lock()
+	p.cache[name] = probeResult{err: err, when: time.Now()}
+	p.mu.Unlock()
+
+	return err
+}
+
+// handler serves /livez, /readyz and /readyz/<component>
+func (p *readinessProbes) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", p.livez)
+	mux.HandleFunc("/readyz", p.readyz)
+	mux.HandleFunc("/readyz/", p.readyzComponent)
+	return mux
+}
+
+// livez answers as long as cn-core runs, no required daemon was given up by the supervisor
+// and the daemons it started answer on their admin socket, a hung or dead daemon fails it
+func (p *readinessProbes) livez(w http.ResponseWriter, r *http.Request) {
+	if supervising() {
+		for _, d := range sup.status() {
+			if d.Required && d.Failed {
+				http.Error(w, "[-]"+d.Name+" failed", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+	for _, name := range p.liveDaemons() {
+		if err := checkAdminSocket(name); err != nil {
+			http.Error(w, fmt.Sprintf("[-]%s not alive: %v", name, err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// liveDaemons returns the daemons expected to answer on their admin socket, e.g: mon or osd.0
+// A supervised daemon being restarted is left to the supervisor, the others are checked once bootstrapped
+func (p *readinessProbes) liveDaemons() []string {
+	var names []string
+	if supervising() {
+		for _, d := range sup.status() {
+			if d.Running && d.Name != "dash" {
+				names = append(names, d.Name)
+			}
+		}
+		return names
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range []string{"mon", "mgr", "osd", "rgw"} {
+		switch {
+		case !p.expected[name] || !p.bootstrapped[name]:
+		case name == "osd":
+			names = append(names, osdAdminSockets()...)
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkAdminSocket tells if a daemon answers on its admin socket
+func checkAdminSocket(name string) error {
+	entity, err := daemonEntity(name)
+	if err != nil {
+		return err
+	}
+	_, err = adminSocket(entity, probeTimeout).Version()
+	return err
+}
+
+// readyz is ready when every expected component is, the body lists them the way Kubernetes does
+func (p *readinessProbes) readyz(w http.ResponseWriter, r *http.Request) {
+	var body strings.Builder
+	ready := true
+	for _, name := range probeComponents {
+		err := p.check(name)
+		switch {
+		case err == errNotDeployed:
+			continue
+		case err != nil:
+			ready = false
+			fmt.Fprintf(&body, "[-]%s not ready: %v\n", name, err)
+		default:
+			fmt.Fprintf(&body, "[+]%s ok\n", name)
+		}
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, body.String())
+}
+
+func (p *readinessProbes) readyzComponent(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/readyz/")
+	if _, ok := p.checks[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := p.check(name)
+	switch {
+	case err == errNotDeployed:
+		http.Error(w, "[-]"+name+" not deployed", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("[-]%s not ready: %v", name, err), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "[+]%s ok\n", name)
+	}
+}
+
+// serveProbes starts the probes HTTP listener in the background
+func serveProbes(port string) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("init: failed to listen for probes on port %s: %v", port, err)
+	}
+	log.Println("init: serving liveness and readiness probes on port " + port)
+
+	go func() {
+		if err := http.Serve(listener, probes.handler()); err != nil {
+			log.Printf("init: probes listener stopped: %v", err)
+		}
+	}()
+}
+
+// probeCeph returns a client of the ceph command line tool giving up after the probe timeout
+func probeCeph() *ceph.Client {
+	return ceph.New(probeRunner.Run).WithTimeout(probeTimeout)
+}
+
+// checkMon is ready once the local monitor is part of the quorum
+func checkMon() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	quorum, err := probeCeph().QuorumStatus()
+	if err != nil {
+		return err
+	}
+	for _, name := range quorum.QuorumNames {
+		if name == hostname {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("mon.%s is not in quorum", hostname)
+}
+
+// checkMgr is ready once a manager is active
+func checkMgr() error {
+	mgrMap, err := probeCeph().MgrDump()
+	if err != nil {
+		return err
+	}
+	if !mgrMap.Available {
+		return errors.New("no active manager")
+	}
+
+	return nil
+}
+
+// checkOsd is ready once every OSD we deployed is up
+func checkOsd() error {
+	osdMap, err := probeCeph().OsdStat()
+	if err != nil {
+		return err
+	}
+	if expected := conf.osdCount(); osdMap.NumUpOsds < expected {
+		return fmt.Errorf("%d/%d osds up", osdMap.NumUpOsds, expected)
+	}
+
+	return nil
+}
+
+// checkRgw is ready once Rados Gateway answers on its ports and the cn S3 user exists
+func checkRgw() error {
+	if err := probeHTTP(conf.localAddr(conf.RgwPort)); err != nil {
+		return err
+	}
+	if conf.rgwTLS() {
+		if err := probeHTTPS(conf.localAddr(conf.RgwTLSPort)); err != nil {
+			return err
+		}
+	}
+
+	// the keys of the cn user are written once Rados Gateway created it
+	if _, err := os.Stat(hostPath(cnUserDetailsFile)); os.IsNotExist(err) {
+		return fmt.Errorf("user %s does not exist yet", cnCoreRgwUserUID)
+	}
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return err
+	}
+
+	// the Admin Ops API answers without a cluster connection of its own, the probe gives up like the ceph commands
+	// a key Rados Gateway does not know means the cn user it belongs to is gone too
+	admin.s3.http.Timeout = probeTimeout
+	if _, err := admin.user(cnCoreRgwUserUID); isS3Error(err, "NoSuchUser", "InvalidAccessKeyId") {
+		return fmt.Errorf("user %s does not exist yet: %v", cnCoreRgwUserUID, err)
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkDash is ready once the dashboard answers on its port
+func checkDash() error {
+	switch conf.Dashboard {
+	case dashboardNone:
+		return nil
+	case dashboardCeph:
+		// the mgr dashboard listens on bind_address like Rados Gateway
+		return probeHTTP(conf.localAddr(conf.DashPort))
+	}
+	return probeHTTP(net.JoinHostPort("127.0.0.1", conf.DashPort))
+}
+
+// probeHTTP sends a GET on a local address, any answer but a server error means it is serving
+func probeHTTP(addr string) error {
+	return probeGet(&http.Client{Timeout: probeTimeout}, "http://"+addr)
+}
+
+// probeHTTPS is probeHTTP over TLS, like the kubelet probes it does not verify the certificate, the clients do
+func probeHTTPS(addr string) error {
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return probeGet(&http.Client{Timeout: probeTimeout, Transport: transport}, "https://"+addr)
+}
+
+func probeGet(client *http.Client, endpoint string) error {
+	resp, err := client.Get(endpoint + "/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("port %s answered %s", resp.Request.URL.Port(), resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// probeStub answers the probe commands by their arguments, without the connect timeout
+type probeStub map[string]string
+
+func (s probeStub) Run(cmd *exec.Cmd) ([]byte, error) {
+	args := strings.Join(cmd.Args, " ")
+	args = strings.Replace(args, " --connect-timeout 5", "", 1)
+	out, ok := s[args]
+	if !ok {
+		return nil, errors.New("exit status 1")
+	}
+	return []byte(out), nil
+}
+
+func (s probeStub) Start(cmd *exec.Cmd) error {
+	return errors.New("not supported")
+}
+
+// serveProbeTest returns the port of a fake daemon answering with the given status code
+func serveProbeTest(t *testing.T, code int) (string, func()) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	return u.Port(), server.Close
+}
+
+func getProbe(t *testing.T, p *readinessProbes, path string) (int, string) {
+	w := httptest.NewRecorder()
+	p.handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestProbes(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	dashPort, closeDash := serveProbeTest(t, http.StatusInternalServerError)
+	defer closeDash()
+
+	conf.DashPort, conf.OsdCount = dashPort, "2"
+	probeRunner = probeStub{
+		"ceph quorum_status --format json": `{"quorum_names": ["` + hostname + `"]}`,
+		"ceph mgr dump --format json":      `{"available": true}`,
+		"ceph osd stat --format json":      `{"osdmap": {"num_osds": 2, "num_up_osds": 1, "num_in_osds": 2}}`,
+	}
+	defer func() {
+		probeRunner = execRunner{}
+	}()
+
+	p := newReadinessProbes()
+	p.expect(probeComponents...)
+
+	code, body := getProbe(t, p, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	// nothing is ready before its bootstrap returns
+	code, body = getProbe(t, p, "/readyz/mon")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "bootstrap in progress")
+
+	for _, name := range probeComponents {
+		p.markBootstrapped(name)
+	}
+
+	for name, want := range map[string]int{
+		"mon":  http.StatusOK,
+		"mgr":  http.StatusOK,
+		"osd":  http.StatusServiceUnavailable,
+		"rgw":  http.StatusOK,
+		"dash": http.StatusServiceUnavailable,
+	} {
+		code, _ := getProbe(t, p, "/readyz/"+name)
+		assert.Equal(t, want, code, name)
+	}
+
+	code, body = getProbe(t, p, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "[+]mon ok\n[+]mgr ok\n[-]osd not ready: 1/2 osds up\n[+]rgw ok\n[-]dash not ready: port "+dashPort+" answered 500 Internal Server Error\n", body)
+
+	code, _ = getProbe(t, p, "/readyz/nfs")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestProbesSingleDaemon(t *testing.T) {
+	probeRunner = probeStub{
+		"ceph mgr dump --format json": `{"available": false}`,
+	}
+	defer func() {
+		probeRunner = execRunner{}
+	}()
+
+	p := newReadinessProbes()
+	p.expect("mgr")
+	p.markBootstrapped("mgr")
+
+	// components deployed by other containers are not part of the readiness
+	code, _ := getProbe(t, p, "/readyz/mon")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body := getProbe(t, p, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "[-]mgr not ready: no active manager\n", body)
+
+	// the result is cached for a while
+	probeRunner = probeStub{
+		"ceph mgr dump --format json": `{"available": true}`,
+	}
+	code, _ = getProbe(t, p, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	p.cache = map[string]probeResult{}
+	code, _ = getProbe(t, p, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestProbesRgwUser(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	assert.Nil(t, checkRgw())
+
+	// rgw answers but the cn user was not created yet, or not in this cluster
+	assert.Nil(t, os.Remove(filepath.Join(root, cnUserDetailsFile)))
+	assert.EqualError(t, checkRgw(), "user cn does not exist yet")
+	writeTestKeys(t, root, "UNKNOWNACCESSKEY0000", "unknownSecret")
+	assert.EqualError(t, checkRgw(), "user cn does not exist yet: InvalidAccessKeyId (403)")
+
+	// other failures are not mistaken for a missing user
+	rgwPort, closeRgw := serveProbeTest(t, http.StatusForbidden)
+	defer closeRgw()
+	conf.RgwPort = rgwPort
+	assert.EqualError(t, checkRgw(), "Forbidden (403)")
+}
+
+func TestProbesRgwTLS(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	// the HTTPS endpoint is probed too, whoever signed its certificate
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	u, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+	conf.RgwTLS, conf.RgwTLSPort = "true", u.Port()
+	assert.EqualError(t, checkRgw(), "port "+u.Port()+" answered 503 Service Unavailable")
+
+	server.Close()
+	err = checkRgw()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+}
+
+func TestConfigHealthPort(t *testing.T) {
+	c := defaultConfig()
+	assert.Nil(t, c.validate())
+	assert.Equal(t, "5001", c.healthPort())
+
+	c.HealthPort = "0"
+	assert.Nil(t, c.validate())
+	assert.Equal(t, "", c.healthPort())
+
+	c.HealthPort = c.RgwPort
+	assert.NotNil(t, c.validate())
+
+	c.HealthPort = "http"
+	assert.NotNil(t, c.validate())
+}
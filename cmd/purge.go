@@ -0,0 +1,183 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	purgeYes        bool
+	purgeKeepConfig bool
+)
+
+// cliPurge is the Cobra CLI call
+func cliPurge() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Stop the daemons and remove everything init created",
+		Args:  cobra.NoArgs,
+		Run:   purgeCluster,
+		Example: "cn-core purge\n" +
+			"cn-core purge --yes --keep-config\n",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().BoolVar(&purgeYes, "yes", false, "Do not ask for a confirmation.")
+	cmd.Flags().BoolVar(&purgeKeepConfig, "keep-config", false, "Keep "+cephConfFilePath+".")
+	addConfigFlags(cmd.Flags())
+
+	return cmd
+}
+
+// purgeCluster tears the cluster down so the next init bootstraps a new one
+func purgeCluster(cmd *cobra.Command, args []string) {
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal("Failed to get the hostname.")
+	}
+
+	targets := purgeTargets(hostname, purgeKeepConfig)
+	devices := purgeDevices()
+	if len(targets) == 0 && len(devices) == 0 {
+		fmt.Println("Nothing to purge.")
+		return
+	}
+
+	if !purgeYes && !confirmPurge(os.Stdin, os.Stdout, targets, devices) {
+		log.Fatal("purge: aborted")
+	}
+
+	for _, group := range shutdownOrder {
+		stopDaemon(group)
+	}
+	for _, device := range devices {
+		log.Println("purge: zapping " + device)
+		zapDevice(device)
+	}
+
+	for _, path := range targets {
+		log.Println("purge: removing " + path)
+		if err := os.RemoveAll(hostPath(path)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Println("purge: done, the next init bootstraps a new cluster")
+}
+
+// purgeTargets lists the files and directories created by init that exist on this host
+func purgeTargets(hostname string, keepConfig bool) []string {
+	var candidates []string
+	if !keepConfig {
+		candidates = append(candidates, cephConfFilePath)
+	}
+	candidates = append(candidates,
+		adminKeyringPath,
+		monMapPath,
+		monInitialKeyringPath,
+		cephDataPath+"/mon/ceph-"+hostname,
+		cephDataPath+"/mgr/ceph-"+hostname,
+	)
+
+	// osdBasePath may be a dedicated mount, only the OSD directories are ours
+	if dirs, err := filepath.Glob(hostPath(osdBasePath + "/ceph-*")); err == nil {
+		for _, dir := range dirs {
+			candidates = append(candidates, osdBasePath+"/"+filepath.Base(dir))
+		}
+	}
+
+	candidates = append(candidates,
+		osdBootstrapKeyring,
+		journalDir,
+		rgwTLSDir,
+		cephDataPath+"/radosgw/ceph-rgw."+hostname,
+		strings.TrimSuffix(dashboardDirExtractTo, "/"),
+		cnUserDetailsFile,
+		"/nano_user_details",
+		dashboardUserDetailsFile,
+		cnUsersDir,
+		s3CmdFilePath,
+	)
+
+	var targets []string
+	for _, path := range candidates {
+		if _, err := os.Lstat(hostPath(path)); err == nil {
+			targets = append(targets, path)
+		}
+	}
+
+	return targets
+}
+
+// purgeDevices returns the configured devices that ceph-volume prepared
+func purgeDevices() []string {
+	devices := conf.osdDevices()
+	if len(devices) == 0 {
+		return nil
+	}
+
+	prepared := osdLvmList()
+	var zap []string
+	for _, device := range devices {
+		if _, ok := prepared[device]; ok {
+			zap = append(zap, device)
+		}
+	}
+
+	return zap
+}
+
+// confirmPurge lists what is going to be removed and waits for the user to type yes
+func confirmPurge(in io.Reader, out io.Writer, targets, devices []string) bool {
+	fmt.Fprintln(out, "The following will be removed:")
+	for _, path := range targets {
+		fmt.Fprintln(out, "  "+path)
+	}
+	for _, device := range devices {
+		fmt.Fprintln(out, "  "+device+" (zapped with ceph-volume)")
+	}
+	fmt.Fprint(out, "Type 'yes' to continue: ")
+
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// zapDevice destroys the logical volumes of an OSD device so it can be prepared again
+func zapDevice(device string) {
+	cmd := exec.Command("ceph-volume", "lvm", "zap", "--destroy", device)
+	runCommandOrExit(cmd)
+}
@@ -0,0 +1,85 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeTargets(t *testing.T) {
+	_, cleanup := fakeCluster(t)
+	defer cleanup()
+
+	hostname, _ := os.Hostname()
+	assert.Equal(t, []string{
+		"/etc/ceph/ceph.conf",
+		"/etc/ceph/ceph.client.admin.keyring",
+		"/var/lib/ceph/mon/ceph-" + hostname,
+		"/var/lib/ceph/osd/ceph-0",
+		"/opt/ceph-container/tmp/cn_user_details",
+		"/nano_user_details",
+	}, purgeTargets(hostname, false))
+
+	targets := purgeTargets(hostname, true)
+	assert.NotContains(t, targets, "/etc/ceph/ceph.conf")
+	assert.Contains(t, targets, "/etc/ceph/ceph.client.admin.keyring")
+}
+
+func TestPurgeDevices(t *testing.T) {
+	defer func() {
+		runner = execRunner{}
+		conf = defaultConfig()
+	}()
+	conf = defaultConfig()
+	assert.Nil(t, purgeDevices())
+
+	lvmList := `{"0": [{"devices": ["/dev/sdb"], "type": "block", "tags": {"ceph.osd_fsid": "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a"}}]}`
+	replay := &replayRunner{entries: []transcriptEntry{
+		{Args: []string{"ceph-volume", "lvm", "list", "--format", "json"}, Output: lvmList},
+		{Args: []string{"ceph-volume", "lvm", "zap", "--destroy", "/dev/sdb"}},
+	}}
+	runner = replay
+	conf.OsdDevice = "/dev/sdb,/dev/sdc"
+
+	devices := purgeDevices()
+	assert.Equal(t, []string{"/dev/sdb"}, devices)
+	for _, device := range devices {
+		zapDevice(device)
+	}
+	assert.Equal(t, 0, replay.remaining())
+}
+
+func TestConfirmPurge(t *testing.T) {
+	var out bytes.Buffer
+	assert.True(t, confirmPurge(strings.NewReader("yes\n"), &out, []string{"/etc/ceph/ceph.conf"}, []string{"/dev/sdb"}))
+	assert.Contains(t, out.String(), "  /etc/ceph/ceph.conf\n")
+	assert.Contains(t, out.String(), "  /dev/sdb (zapped with ceph-volume)\n")
+
+	assert.False(t, confirmPurge(strings.NewReader("y\n"), &out, nil, nil))
+	assert.False(t, confirmPurge(strings.NewReader(""), &out, nil, nil))
+}
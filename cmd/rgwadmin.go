@@ -0,0 +1,271 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// rgwAdminPrefix is where Rados Gateway serves the Admin Ops API, it is signed like an S3 bucket of that name
+	rgwAdminPrefix = "admin"
+	// rgwUsageDate is the format of the bounds of a usage query
+	rgwUsageDate = "2006-01-02 15:04:05"
+)
+
+// rgwAdmin is a client of the Admin Ops API of Rados Gateway
+// The user it signs with needs the caps of the resources it administers, the cn user has them all
+// Failures are *s3Error, e.g: NoSuchUser, UserAlreadyExists, InvalidAccessKeyId
+type rgwAdmin struct {
+	s3 *s3Client
+}
+
+// rgwUserSpec are the settings of a user to create
+type rgwUserSpec struct {
+	DisplayName string
+	Caps        string // e.g: "users=read;buckets=*"
+	MaxBuckets  *int   // the default of Rados Gateway when nil
+	Suspended   bool
+}
+
+// rgwQuota is the quota of a user, or of each of its buckets
+type rgwQuota struct {
+	Enabled    bool  `json:"enabled"`
+	MaxSizeKB  int64 `json:"max_size_kb"`
+	MaxObjects int64 `json:"max_objects"` // -1 is unlimited
+}
+
+// rgwBucketStats is the usage of a bucket, indexed by category, e.g: rgw.main
+type rgwBucketStats struct {
+	Bucket string                    `json:"bucket"`
+	Owner  string                    `json:"owner"`
+	Usage  map[string]rgwBucketUsage `json:"usage"`
+}
+
+type rgwBucketUsage struct {
+	SizeKB     int64 `json:"size_kb"`
+	SizeActual int64 `json:"size_actual"`
+	NumObjects int64 `json:"num_objects"`
+}
+
+// rgwUsage is the summary of the operations of users, without the detail per bucket
+type rgwUsage struct {
+	Summary []rgwUsageSummary `json:"summary"`
+}
+
+type rgwUsageSummary struct {
+	User  string        `json:"user"`
+	Total rgwUsageTotal `json:"total"`
+}
+
+type rgwUsageTotal struct {
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+	Ops           int64 `json:"ops"`
+	SuccessfulOps int64 `json:"successful_ops"`
+}
+
+func newRgwAdmin(endpoint string, creds s3Credentials) *rgwAdmin {
+	return &rgwAdmin{s3: newS3Client(endpoint, creds)}
+}
+
+// localRgwAdmin returns a client of the local Rados Gateway signing with the cn user
+// During a dry run the requests are only recorded, the keys may not exist yet
+func localRgwAdmin() (*rgwAdmin, error) {
+	creds := s3Credentials{User: cnCoreRgwUserUID, AccessKey: "<access key>", SecretKey: "<secret key>"}
+	if !dryRun {
+		var err error
+		if creds, err = cnCredentials(); err != nil {
+			return nil, err
+		}
+	}
+	return &rgwAdmin{s3: localS3Client(creds)}, nil
+}
+
+// rgwAdminUID returns the uid the Admin Ops API knows a user by, tenant$uid for the user of a tenant
+func rgwAdminUID(tenant, uid string) string {
+	if tenant != "" {
+		return tenant + "$" + uid
+	}
+	return uid
+}
+
+// call sends a request to a resource of the API, e.g: user, and decodes the JSON answer in out unless nil
+func (a *rgwAdmin) call(method, resource string, query url.Values, in, out interface{}) error {
+	r := s3Request{method: method, bucket: rgwAdminPrefix, key: resource, query: url.Values{"format": {"json"}}}
+	for name, values := range query {
+		r.query[name] = values
+	}
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		r.body = body
+	}
+
+	body, err := a.s3.call(r)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse the answer to %s /%s/%s: %v", method, rgwAdminPrefix, resource, err)
+	}
+	return nil
+}
+
+// users returns the uids of every user, tenant$uid for the users of a tenant
+func (a *rgwAdmin) users() ([]string, error) {
+	var uids []string
+	err := a.call(http.MethodGet, "metadata/user", nil, nil, &uids)
+	return uids, err
+}
+
+// user returns a user with its keys
+func (a *rgwAdmin) user(uid string) (rgwUserInfo, error) {
+	var info rgwUserInfo
+	err := a.call(http.MethodGet, "user", url.Values{"uid": {uid}}, nil, &info)
+	return info, err
+}
+
+// createUser creates a user with a generated S3 key
+func (a *rgwAdmin) createUser(uid string, spec rgwUserSpec) (rgwUserInfo, error) {
+	query := url.Values{"uid": {uid}, "display-name": {spec.DisplayName}, "key-type": {"s3"}, "generate-key": {"true"}}
+	if spec.Caps != "" {
+		query.Set("user-caps", spec.Caps)
+	}
+	if spec.MaxBuckets != nil {
+		query.Set("max-buckets", strconv.Itoa(*spec.MaxBuckets))
+	}
+	if spec.Suspended {
+		query.Set("suspended", "true")
+	}
+
+	var info rgwUserInfo
+	err := a.call(http.MethodPut, "user", query, nil, &info)
+	return info, err
+}
+
+// suspendUser suspends or reenables a user
+func (a *rgwAdmin) suspendUser(uid string, suspended bool) (rgwUserInfo, error) {
+	var info rgwUserInfo
+	err := a.call(http.MethodPost, "user", url.Values{"uid": {uid}, "suspended": {strconv.FormatBool(suspended)}}, nil, &info)
+	return info, err
+}
+
+// removeUser removes a user, purgeData removes its buckets and objects first
+func (a *rgwAdmin) removeUser(uid string, purgeData bool) error {
+	query := url.Values{"uid": {uid}}
+	if purgeData {
+		query.Set("purge-data", "true")
+	}
+	return a.call(http.MethodDelete, "user", query, nil, nil)
+}
+
+// createKey adds an S3 key to a user, what is not given is generated, it returns every key of the user
+func (a *rgwAdmin) createKey(uid, accessKey, secretKey string) ([]rgwKey, error) {
+	query := url.Values{"key": {""}, "uid": {uid}, "key-type": {"s3"}}
+	if accessKey != "" {
+		query.Set("access-key", accessKey)
+	}
+	if secretKey != "" {
+		query.Set("secret-key", secretKey)
+	}
+	if accessKey == "" || secretKey == "" {
+		query.Set("generate-key", "true")
+	}
+
+	var keys []rgwKey
+	err := a.call(http.MethodPut, "user", query, nil, &keys)
+	return keys, err
+}
+
+// removeKey removes an S3 key from a user
+func (a *rgwAdmin) removeKey(uid, accessKey string) error {
+	return a.call(http.MethodDelete, "user", url.Values{"key": {""}, "uid": {uid}, "key-type": {"s3"}, "access-key": {accessKey}}, nil, nil)
+}
+
+// quota returns the quota of a user, scope is "user" for all its buckets together or "bucket" for each of them
+func (a *rgwAdmin) quota(uid, scope string) (rgwQuota, error) {
+	var quota rgwQuota
+	err := a.call(http.MethodGet, "user", url.Values{"quota": {""}, "uid": {uid}, "quota-type": {scope}}, nil, &quota)
+	return quota, err
+}
+
+// setQuota sets the quota of a user, see quota for the scopes
+func (a *rgwAdmin) setQuota(uid, scope string, quota rgwQuota) error {
+	return a.call(http.MethodPut, "user", url.Values{"quota": {""}, "uid": {uid}, "quota-type": {scope}}, quota, nil)
+}
+
+// buckets returns the buckets of a user, or every bucket when uid is empty
+func (a *rgwAdmin) buckets(uid string) ([]string, error) {
+	query := url.Values{}
+	if uid != "" {
+		query.Set("uid", uid)
+	}
+	var buckets []string
+	err := a.call(http.MethodGet, "bucket", query, nil, &buckets)
+	return buckets, err
+}
+
+// bucketStats returns the owner and the usage of a bucket
+func (a *rgwAdmin) bucketStats(bucket string) (rgwBucketStats, error) {
+	var stats rgwBucketStats
+	err := a.call(http.MethodGet, "bucket", url.Values{"bucket": {bucket}, "stats": {"true"}}, nil, &stats)
+	return stats, err
+}
+
+// removeBucket removes a bucket, purgeObjects removes its objects first, a bucket with objects cannot be removed otherwise
+func (a *rgwAdmin) removeBucket(bucket string, purgeObjects bool) error {
+	query := url.Values{"bucket": {bucket}}
+	if purgeObjects {
+		query.Set("purge-objects", "true")
+	}
+	return a.call(http.MethodDelete, "bucket", query, nil, nil)
+}
+
+// usage returns the operations of a user, or of every user when uid is empty, between two dates
+// Rados Gateway only logs them with rgw_enable_usage_log, the zero time leaves a bound open
+func (a *rgwAdmin) usage(uid string, start, end time.Time) (rgwUsage, error) {
+	query := url.Values{"show-entries": {"false"}, "show-summary": {"true"}}
+	if uid != "" {
+		query.Set("uid", uid)
+	}
+	if !start.IsZero() {
+		query.Set("start", start.UTC().Format(rgwUsageDate))
+	}
+	if !end.IsZero() {
+		query.Set("end", end.UTC().Format(rgwUsageDate))
+	}
+	var usage rgwUsage
+	err := a.call(http.MethodGet, "usage", query, nil, &usage)
+	return usage, err
+}
@@ -0,0 +1,331 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveAdmin handles the requests on /admin/<resource>, errors are JSON documents like Rados Gateway sends
+// The request must be signed by a known user, caps are not checked
+func (f *fakeS3) serveAdmin(w http.ResponseWriter, r *http.Request, accessKey, resource string, body []byte) {
+	if f.uidOf(accessKey) == "" {
+		f.failJSON(w, s3Error{StatusCode: http.StatusForbidden, Code: "InvalidAccessKeyId"})
+		return
+	}
+	query := r.URL.Query()
+	uid := query.Get("uid")
+	user := f.users[uid]
+	noSuchUser := s3Error{StatusCode: http.StatusNotFound, Code: "NoSuchUser"}
+
+	switch {
+	case resource == "metadata/user":
+		uids := []string{}
+		for uid := range f.users {
+			uids = append(uids, uid)
+		}
+		sort.Strings(uids)
+		f.reply(w, uids)
+
+	case resource == "user" && user == nil && (r.Method != http.MethodPut || query["key"] != nil || query["quota"] != nil):
+		f.failJSON(w, noSuchUser)
+
+	case resource == "user" && query["quota"] != nil:
+		key := uid + "?" + query.Get("quota-type")
+		if r.Method == http.MethodPut {
+			var quota rgwQuota
+			if err := json.Unmarshal(body, &quota); err != nil {
+				f.failJSON(w, s3Error{StatusCode: http.StatusBadRequest, Code: "InvalidArgument"})
+				return
+			}
+			f.quotas[key] = quota
+			return
+		}
+		quota, ok := f.quotas[key]
+		if !ok {
+			quota = rgwQuota{MaxSizeKB: -1, MaxObjects: -1}
+		}
+		f.reply(w, quota)
+
+	case resource == "user" && query["key"] != nil && r.Method == http.MethodPut:
+		key := rgwKey{AccessKey: query.Get("access-key"), SecretKey: query.Get("secret-key")}
+		generated := fakeKey(uid, len(user.Keys))
+		if key.AccessKey == "" {
+			key.AccessKey = generated.AccessKey
+		}
+		if key.SecretKey == "" {
+			key.SecretKey = generated.SecretKey
+		}
+		if f.uidOf(key.AccessKey) != "" {
+			f.failJSON(w, s3Error{StatusCode: http.StatusConflict, Code: "KeyExists"})
+			return
+		}
+		user.Keys = append(user.Keys, key)
+		f.reply(w, user.Keys)
+
+	case resource == "user" && query["key"] != nil && r.Method == http.MethodDelete:
+		for i, key := range user.Keys {
+			if key.AccessKey == query.Get("access-key") {
+				user.Keys = append(user.Keys[:i], user.Keys[i+1:]...)
+				return
+			}
+		}
+		f.failJSON(w, s3Error{StatusCode: http.StatusNotFound, Code: "InvalidAccessKeyId"})
+
+	case resource == "user" && r.Method == http.MethodGet:
+		f.reply(w, user)
+
+	case resource == "user" && r.Method == http.MethodPut:
+		if user != nil {
+			f.failJSON(w, s3Error{StatusCode: http.StatusConflict, Code: "UserAlreadyExists"})
+			return
+		}
+		user = &rgwUserInfo{UserID: uid, DisplayName: query.Get("display-name"), MaxBuckets: 1000, Caps: []rgwCap{}, Keys: []rgwKey{fakeKey(uid, 0)}}
+		if max := query.Get("max-buckets"); max != "" {
+			user.MaxBuckets, _ = strconv.Atoi(max)
+		}
+		if query.Get("suspended") == "true" {
+			user.Suspended = 1
+		}
+		for _, c := range strings.Split(query.Get("user-caps"), ";") {
+			if parts := strings.SplitN(c, "=", 2); len(parts) == 2 {
+				user.Caps = append(user.Caps, rgwCap{Type: parts[0], Perm: parts[1]})
+			}
+		}
+		f.users[uid] = user
+		f.reply(w, user)
+
+	case resource == "user" && r.Method == http.MethodPost:
+		user.Suspended = 0
+		if query.Get("suspended") == "true" {
+			user.Suspended = 1
+		}
+		f.reply(w, user)
+
+	case resource == "user" && r.Method == http.MethodDelete:
+		for bucket := range f.buckets {
+			if f.uidOf(f.owners[bucket]) != uid {
+				continue
+			}
+			if query.Get("purge-data") != "true" {
+				f.failJSON(w, s3Error{StatusCode: http.StatusConflict, Code: "BucketNotEmpty"})
+				return
+			}
+			f.removeBucket(bucket)
+		}
+		delete(f.users, uid)
+
+	case resource == "bucket" && r.Method == http.MethodGet && query.Get("bucket") != "":
+		bucket := query.Get("bucket")
+		if !f.buckets[bucket] {
+			f.failJSON(w, s3Error{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"})
+			return
+		}
+		stats := rgwBucketStats{Bucket: bucket, Owner: f.uidOf(f.owners[bucket]), Usage: map[string]rgwBucketUsage{}}
+		for name, object := range f.objects {
+			if strings.HasPrefix(name, bucket+"/") {
+				usage := stats.Usage["rgw.main"]
+				usage.NumObjects++
+				usage.SizeActual += int64(len(object.data))
+				usage.SizeKB = (usage.SizeActual + 1023) / 1024
+				stats.Usage["rgw.main"] = usage
+			}
+		}
+		f.reply(w, stats)
+
+	case resource == "bucket" && r.Method == http.MethodGet:
+		buckets := []string{}
+		for bucket := range f.buckets {
+			if uid == "" || f.uidOf(f.owners[bucket]) == uid {
+				buckets = append(buckets, bucket)
+			}
+		}
+		sort.Strings(buckets)
+		f.reply(w, buckets)
+
+	case resource == "bucket" && r.Method == http.MethodDelete:
+		bucket := query.Get("bucket")
+		if !f.buckets[bucket] {
+			f.failJSON(w, s3Error{StatusCode: http.StatusNotFound, Code: "NoSuchBucket"})
+			return
+		}
+		for name := range f.objects {
+			if strings.HasPrefix(name, bucket+"/") && query.Get("purge-objects") != "true" {
+				f.failJSON(w, s3Error{StatusCode: http.StatusConflict, Code: "BucketNotEmpty"})
+				return
+			}
+		}
+		f.removeBucket(bucket)
+
+	case resource == "usage":
+		usage := rgwUsage{Summary: []rgwUsageSummary{}}
+		for user, total := range f.usage {
+			if uid == "" || user == uid {
+				usage.Summary = append(usage.Summary, rgwUsageSummary{User: user, Total: total})
+			}
+		}
+		f.reply(w, usage)
+
+	default:
+		f.failJSON(w, s3Error{StatusCode: http.StatusMethodNotAllowed, Code: "MethodNotAllowed"})
+	}
+}
+
+// uidOf returns the user of an access key, empty when no user has it
+func (f *fakeS3) uidOf(accessKey string) string {
+	for uid, user := range f.users {
+		for _, key := range user.Keys {
+			if key.AccessKey == accessKey {
+				return uid
+			}
+		}
+	}
+	return ""
+}
+
+func (f *fakeS3) removeBucket(bucket string) {
+	for name := range f.objects {
+		if strings.HasPrefix(name, bucket+"/") {
+			delete(f.objects, name)
+			delete(f.versions, name)
+		}
+	}
+	delete(f.buckets, bucket)
+	delete(f.owners, bucket)
+}
+
+func (f *fakeS3) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeS3) failJSON(w http.ResponseWriter, e s3Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.StatusCode)
+	json.NewEncoder(w).Encode(e)
+}
+
+// fakeKey generates the n-th key of a user, e.g: ALICEACCESSKEY000000 and aliceSecret for acme$alice
+func fakeKey(uid string, n int) rgwKey {
+	name := uid[strings.Index(uid, "$")+1:]
+	prefix := strings.ToUpper(name) + "ACCESSKEY"
+	key := rgwKey{AccessKey: prefix, SecretKey: name + "Secret"}
+	if len(prefix) < 20 {
+		key.AccessKey += strings.Repeat(strconv.Itoa(n), 20-len(prefix))
+	}
+	if n > 0 {
+		key.SecretKey += strconv.Itoa(n)
+	}
+	return key
+}
+
+func TestRgwAdminUsers(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	admin, err := localRgwAdmin()
+	assert.Nil(t, err)
+	maxBuckets := 10
+	info, err := admin.createUser("acme$alice", rgwUserSpec{DisplayName: "Alice", Caps: "buckets=read", MaxBuckets: &maxBuckets, Suspended: true})
+	assert.Nil(t, err)
+	assert.Equal(t, rgwUserInfo{UserID: "acme$alice", DisplayName: "Alice", Suspended: 1, MaxBuckets: 10,
+		Caps: []rgwCap{{Type: "buckets", Perm: "read"}}, Keys: []rgwKey{{AccessKey: "ALICEACCESSKEY000000", SecretKey: "aliceSecret"}}}, info)
+	assert.Equal(t, "PUT /admin/user?display-name=Alice&format=json&generate-key=true&key-type=s3&max-buckets=10&suspended=true&uid=acme%24alice&user-caps=buckets%3Dread", fake.requests[0])
+
+	// errors keep the code Rados Gateway answered with
+	_, err = admin.createUser("acme$alice", rgwUserSpec{DisplayName: "Alice"})
+	assert.EqualError(t, err, "UserAlreadyExists (409)")
+	_, err = admin.user("bob")
+	assert.True(t, isS3Error(err, "NoSuchUser"))
+
+	info, err = admin.suspendUser("acme$alice", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, info.Suspended)
+
+	keys, err := admin.createKey("acme$alice", "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []rgwKey{{AccessKey: "ALICEACCESSKEY000000", SecretKey: "aliceSecret"}, {AccessKey: "ALICEACCESSKEY111111", SecretKey: "aliceSecret1"}}, keys)
+	assert.Nil(t, admin.removeKey("acme$alice", "ALICEACCESSKEY000000"))
+	err = admin.removeKey("acme$alice", "ALICEACCESSKEY000000")
+	assert.True(t, isS3Error(err, "InvalidAccessKeyId"))
+
+	uids, err := admin.users()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acme$alice", "cn"}, uids)
+	assert.Nil(t, admin.removeUser("acme$alice", false))
+	_, err = admin.user("acme$alice")
+	assert.True(t, isS3Error(err, "NoSuchUser"))
+
+	// only known keys are accepted
+	_, err = newRgwAdmin("http://"+conf.localAddr(conf.RgwPort), s3Credentials{AccessKey: "ALICEACCESSKEY111111", SecretKey: "aliceSecret1"}).users()
+	assert.EqualError(t, err, "InvalidAccessKeyId (403)")
+}
+
+func TestRgwAdminBuckets(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	assert.Nil(t, createBucket(localS3Client(testCnCredentials), "photos", false))
+	assert.Nil(t, localS3Client(testCnCredentials).putObject("photos", "cat.jpg", nil, make([]byte, 1500)))
+	fake.usage["cn"] = rgwUsageTotal{BytesReceived: 1500, Ops: 3, SuccessfulOps: 3}
+
+	admin, err := localRgwAdmin()
+	assert.Nil(t, err)
+	buckets, err := admin.buckets("cn")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"photos"}, buckets)
+	stats, err := admin.bucketStats("photos")
+	assert.Nil(t, err)
+	assert.Equal(t, rgwBucketStats{Bucket: "photos", Owner: "cn", Usage: map[string]rgwBucketUsage{"rgw.main": {SizeKB: 2, SizeActual: 1500, NumObjects: 1}}}, stats)
+
+	quota := rgwQuota{Enabled: true, MaxSizeKB: 1024, MaxObjects: -1}
+	assert.Nil(t, admin.setQuota("cn", "bucket", quota))
+	got, err := admin.quota("cn", "bucket")
+	assert.Nil(t, err)
+	assert.Equal(t, quota, got)
+	got, err = admin.quota("cn", "user")
+	assert.Nil(t, err)
+	assert.False(t, got.Enabled)
+
+	usage, err := admin.usage("cn", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, []rgwUsageSummary{{User: "cn", Total: rgwUsageTotal{BytesReceived: 1500, Ops: 3, SuccessfulOps: 3}}}, usage.Summary)
+	assert.Contains(t, fake.requests, "GET /admin/usage?format=json&show-entries=false&show-summary=true&start=2019-01-01%2000%3A00%3A00&uid=cn")
+
+	err = admin.removeBucket("photos", false)
+	assert.EqualError(t, err, "BucketNotEmpty (409)")
+	assert.Nil(t, admin.removeBucket("photos", true))
+	assert.False(t, fake.buckets["photos"])
+}
@@ -0,0 +1,270 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/cn-core/internal/ceph"
+)
+
+const (
+	// transcriptAnyArg matches any argument when replaying a transcript
+	// This is useful for values that change on every run like the fsid
+	transcriptAnyArg = "<any>"
+)
+
+// commandRunner executes the commands of the bootstrap
+type commandRunner interface {
+	// Run runs a command until it completes and returns its combined output
+	Run(cmd *exec.Cmd) ([]byte, error)
+	// Start starts a command without waiting for it to complete
+	Start(cmd *exec.Cmd) error
+}
+
+var (
+	// runner executes every ceph, monmaptool, ceph-volume and radosgw-admin call
+	runner commandRunner = execRunner{}
+)
+
+// runCommand runs a command and returns its combined output
+func runCommand(cmd *exec.Cmd) ([]byte, error) {
+	return runner.Run(cmd)
+}
+
+// runCommandOrExit runs a command and exits when it fails, after printing what it was and its output
+func runCommandOrExit(cmd *exec.Cmd) []byte {
+	out, err := runCommand(cmd)
+	if err != nil {
+		fmt.Printf("The command was: %s\n", cmd.Args)
+		fmt.Printf("The error was: %s\n", out)
+		log.Fatal(err)
+	}
+	return out
+}
+
+// cephClient returns a client of the ceph command line tool running its commands like the rest of the bootstrap
+func cephClient() *ceph.Client {
+	return ceph.New(runCommand)
+}
+
+// startCommand starts a command without waiting for it, the daemons it starts must stay in the foreground
+// so cn-core tracks their pid and stops them on shutdown
+func startCommand(cmd *exec.Cmd) error {
+	return runner.Start(cmd)
+}
+
+// execRunner runs the commands on the host
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	// same as CombinedOutput but the child is tracked so the zombie reaper does not steal its exit status
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := startTracked(cmd); err != nil {
+		return nil, err
+	}
+	err := waitTracked(cmd)
+	return out.Bytes(), err
+}
+
+func (execRunner) Start(cmd *exec.Cmd) error {
+	if err := startTracked(cmd); err != nil {
+		return err
+	}
+	// nobody else waits for it, the pid stays tracked until it exits
+	go waitTracked(cmd)
+	return nil
+}
+
+// transcriptEntry is a single command of a transcript
+type transcriptEntry struct {
+	Args       []string `json:"args"`
+	Dir        string   `json:"dir,omitempty"`
+	Background bool     `json:"background,omitempty"`
+	ExitCode   int      `json:"exit_code"`
+	Output     string   `json:"output"`
+	DurationMs int64    `json:"duration_ms"`
+}
+
+// matches tells if a command corresponds to the entry
+func (e transcriptEntry) matches(args []string, background bool) bool {
+	if e.Background != background || len(e.Args) != len(args) {
+		return false
+	}
+	for i, arg := range e.Args {
+		if arg != transcriptAnyArg && arg != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recordingRunner runs the commands with another runner and writes a transcript of them
+type recordingRunner struct {
+	next    commandRunner
+	path    string
+	mu      sync.Mutex
+	entries []transcriptEntry
+}
+
+func newRecordingRunner(next commandRunner, path string) *recordingRunner {
+	return &recordingRunner{next: next, path: path, entries: []transcriptEntry{}}
+}
+
+func (r *recordingRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	out, err := r.next.Run(cmd)
+	r.record(transcriptEntry{
+		Args:       cmd.Args,
+		Dir:        cmd.Dir,
+		ExitCode:   exitCode(err),
+		Output:     string(out),
+		DurationMs: int64(time.Since(start) / time.Millisecond),
+	})
+	return out, err
+}
+
+func (r *recordingRunner) Start(cmd *exec.Cmd) error {
+	err := r.next.Start(cmd)
+	r.record(transcriptEntry{
+		Args:       cmd.Args,
+		Dir:        cmd.Dir,
+		Background: true,
+		ExitCode:   exitCode(err),
+	})
+	return err
+}
+
+// record appends an entry and saves the transcript right away so a crash still leaves it on disk
+// A transcript that cannot be saved does not stop the bootstrap, the failure is logged
+func (r *recordingRunner) record(entry transcriptEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, entry)
+	out, err := json.MarshalIndent(r.entries, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(r.path, out, 0644)
+	}
+	if err != nil {
+		log.Printf("record: failed to save the transcript %s: %v", r.path, err)
+	}
+}
+
+// exitCode returns the exit code of a command from the error it returned
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *exec.ExitError:
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	case *replayError:
+		return e.code
+	}
+	return -1
+}
+
+// replayRunner answers the commands from a transcript instead of running them
+type replayRunner struct {
+	mu      sync.Mutex
+	entries []transcriptEntry
+	next    int
+}
+
+func loadTranscript(path string) (*replayRunner, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replayRunner{}
+	if err := json.Unmarshal(content, &r.entries); err != nil {
+		return nil, fmt.Errorf("replay: failed to parse %s: %v", path, err)
+	}
+
+	return r, nil
+}
+
+// replayError is returned for the commands that failed when the transcript was recorded
+type replayError struct {
+	code int
+}
+
+func (e *replayError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *replayError) ExitCode() int {
+	return e.code
+}
+
+// replay returns the next entry of the transcript, commands must come in the recorded order
+func (r *replayRunner) replay(cmd *exec.Cmd, background bool) (transcriptEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.entries) {
+		return transcriptEntry{}, fmt.Errorf("replay: unexpected command %s, the transcript is exhausted", strings.Join(cmd.Args, " "))
+	}
+	entry := r.entries[r.next]
+	if !entry.matches(cmd.Args, background) {
+		return transcriptEntry{}, fmt.Errorf("replay: unexpected command %s, expected %s", strings.Join(cmd.Args, " "), strings.Join(entry.Args, " "))
+	}
+	r.next++
+
+	if entry.ExitCode != 0 {
+		return entry, &replayError{code: entry.ExitCode}
+	}
+	return entry, nil
+}
+
+func (r *replayRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	entry, err := r.replay(cmd, false)
+	return []byte(entry.Output), err
+}
+
+func (r *replayRunner) Start(cmd *exec.Cmd) error {
+	_, err := r.replay(cmd, true)
+	return err
+}
+
+// remaining returns the number of entries that were not replayed
+func (r *replayRunner) remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.entries) - r.next
+}
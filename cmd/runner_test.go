@@ -0,0 +1,186 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replayTestdata loads a transcript from testdata, HOSTNAME is replaced by the hostname of the machine
+func replayTestdata(t *testing.T, name string) *replayRunner {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	assert.Nil(t, err)
+	hostname, err := os.Hostname()
+	assert.Nil(t, err)
+
+	tmp, err := ioutil.TempFile("", "cn-core-transcript")
+	assert.Nil(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(strings.Replace(string(content), "HOSTNAME", hostname, -1))
+	assert.Nil(t, err)
+	tmp.Close()
+
+	r, err := loadTranscript(tmp.Name())
+	assert.Nil(t, err)
+	return r
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-core-runner")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "transcript.json")
+
+	recorder := newRecordingRunner(execRunner{}, path)
+	out, err := recorder.Run(exec.Command("echo", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(out))
+	_, err = recorder.Run(exec.Command("sh", "-c", "echo oops; exit 3"))
+	assert.NotNil(t, err)
+
+	replay, err := loadTranscript(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, replay.remaining())
+
+	out, err = replay.Run(exec.Command("echo", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(out))
+
+	// commands must come in the recorded order
+	_, err = replay.Run(exec.Command("echo", "hello"))
+	assert.NotNil(t, err)
+
+	out, err = replay.Run(exec.Command("sh", "-c", "echo oops; exit 3"))
+	assert.Equal(t, "oops\n", string(out))
+	assert.Equal(t, 3, exitCode(err))
+
+	_, err = replay.Run(exec.Command("echo", "hello"))
+	assert.NotNil(t, err)
+
+	// a transcript that cannot be saved is logged, the command still runs
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	recorder = newRecordingRunner(execRunner{}, filepath.Join(dir, "missing", "transcript.json"))
+	out, err = recorder.Run(exec.Command("echo", "hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(out))
+	assert.Contains(t, logs.String(), "record: failed to save the transcript "+filepath.Join(dir, "missing", "transcript.json"))
+}
+
+func TestTranscriptEntryMatches(t *testing.T) {
+	entry := transcriptEntry{Args: []string{"monmaptool", "--fsid", transcriptAnyArg}}
+	assert.True(t, entry.matches([]string{"monmaptool", "--fsid", "7ff73783-cec6-4ace-b655-a6bc4f2532a8"}, false))
+	assert.False(t, entry.matches([]string{"monmaptool", "--fsid"}, false))
+	assert.False(t, entry.matches([]string{"monmaptool", "--fsid", "7ff73783-cec6-4ace-b655-a6bc4f2532a8"}, true))
+}
+
+func TestReplayBootstrap(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	// files and directories shipped by the container image
+	for _, dir := range []string{cephConfigPath, cephDataPath + "/bootstrap-osd", "/opt/ceph-container/tmp", dashboardDir, "/root"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dashboardDir+"sree.cfg.sample"), []byte(testSreeCfg), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, dashboardDir+"static/js"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, dashboardDir+"static/js/base.js"), []byte(testBaseJs), 0644))
+
+	defer fakeSysfs(t, map[string]string{"proc/meminfo": testMemInfo})()
+
+	replay := replayTestdata(t, "bootstrap-osd-device.json")
+	runner = replay
+	conf = defaultConfig()
+	conf.OsdDevice = "/dev/sdb"
+
+	bootstrapCluster()
+	assert.Equal(t, 0, replay.remaining())
+
+	cephConf, err := ioutil.ReadFile(filepath.Join(root, cephConfFilePath))
+	assert.Nil(t, err)
+	assert.Contains(t, string(cephConf), "fsid = ")
+
+	creds, err := cnCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, s3Credentials{User: "cn", AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey000000000000000000000000000000"}, creds)
+
+	s3cfg, err := ioutil.ReadFile(filepath.Join(root, s3CmdFilePath))
+	assert.Nil(t, err)
+	hostname, _ := os.Hostname()
+	assert.Contains(t, string(s3cfg), "access_key = CN4CCESSKEY0000000000\nsecret_key = cnSecretKey000000000000000000000000000000\nhost_base = "+hostname+":8000\n")
+
+	sreeCfg, err := ioutil.ReadFile(filepath.Join(root, dashboardDir+"sree.cfg"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[sree]\nRGW_CIVETWEB_PORT = 8000\nSREE_PORT = 5000\n", string(sreeCfg))
+}
+
+func TestReplayBootstrapOsdDirectories(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+
+	defer fakeSysfs(t, map[string]string{"proc/meminfo": testMemInfo})()
+
+	replay := replayTestdata(t, "bootstrap-osd-directories.json")
+	runner = replay
+	conf = defaultConfig()
+	conf.OsdCount = "2"
+
+	bootstrapOsd()
+	assert.Equal(t, 0, replay.remaining())
+
+	// keyrings are written by ceph, fake them to check the OSDs are found on restart
+	for _, osdID := range []string{"0", "1"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, osdKeyringPath(osdID)), []byte{}, 0600))
+	}
+	assert.Equal(t, []string{"0", "1"}, existingOsdDirectories())
+}
+
+func TestParseLvmList(t *testing.T) {
+	lvmList := `{
+	"1": [{"devices": ["/dev/sdc"], "type": "block", "tags": {"ceph.osd_fsid": "b7c6e2a1-0d4f-4c3e-8f5a-2e9d1c7b6a50"}}],
+	"0": [{"devices": ["/dev/sdd"], "type": "db", "tags": {"ceph.osd_fsid": "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a"}},
+	      {"devices": ["/dev/sdb"], "type": "block", "tags": {"ceph.osd_fsid": "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a"}}]
+}`
+	osds, err := parseLvmList([]byte(lvmList))
+	assert.Nil(t, err)
+	assert.Equal(t, []lvmOsd{
+		{ID: "0", FSID: "f2a2ae4e-9b55-4b5e-9e1a-0c6c1c8b1e4a", Devices: []string{"/dev/sdb"}},
+		{ID: "1", FSID: "b7c6e2a1-0d4f-4c3e-8f5a-2e9d1c7b6a50", Devices: []string{"/dev/sdc"}},
+	}, osds)
+
+	_, err = parseLvmList([]byte(`{"0": [{"devices": ["/dev/sdb"], "tags": {}}]}`))
+	assert.NotNil(t, err)
+
+	_, err = parseLvmList([]byte("No valid Ceph lvm devices found"))
+	assert.NotNil(t, err)
+}
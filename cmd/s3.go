@@ -0,0 +1,498 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3Region is the region of the signatures, Rados Gateway accepts any with the default zonegroup
+	s3Region  = "us-east-1"
+	s3Timeout = 60 * time.Second
+
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Date      = "20060102T150405Z"
+)
+
+// s3Client is a minimal S3 client for the requests cn-core makes to Rados Gateway, signed with AWS signature V4
+type s3Client struct {
+	endpoint  string // e.g: http://127.0.0.1:8000
+	accessKey string
+	secretKey string
+	http      *http.Client
+}
+
+// s3Request is a request on a bucket or an object, query holds the sub-resources, e.g: versioning
+type s3Request struct {
+	method string
+	bucket string
+	key    string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// s3Error is the error document Rados Gateway answers with, in XML for S3 and in JSON for the Admin Ops API
+type s3Error struct {
+	StatusCode int    `xml:"-" json:"-"`
+	Code       string `xml:"Code" json:"Code"`
+	Message    string `xml:"Message" json:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s (%d): %s", e.Code, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s (%d)", e.Code, e.StatusCode)
+}
+
+// isS3Error tells if err is an S3 error with one of the codes
+func isS3Error(err error, codes ...string) bool {
+	e, ok := err.(*s3Error)
+	if !ok {
+		return false
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func newS3Client(endpoint string, creds s3Credentials) *s3Client {
+	return &s3Client{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		accessKey: creds.AccessKey,
+		secretKey: creds.SecretKey,
+		// without compression the objects stored gzipped are read as they are, not inflated behind the back of the caller
+		http: &http.Client{Timeout: s3Timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DisableCompression: true}},
+	}
+}
+
+// localS3Client returns a client of the local Rados Gateway, over HTTP even with TLS
+func localS3Client(creds s3Credentials) *s3Client {
+	return newS3Client("http://"+conf.localAddr(conf.RgwPort), creds)
+}
+
+// do sends the request, the caller closes the body of a successful response
+// A response outside of 2xx is returned as an *s3Error
+// During a dry run the request is recorded in the plan and nothing is sent
+func (c *s3Client) do(r s3Request) (*http.Response, error) {
+	path := "/" + r.bucket
+	if r.key != "" {
+		path += "/" + r.key
+	}
+
+	if dryRun {
+		target := path
+		if len(r.query) > 0 {
+			target += "?" + canonicalQuery(r.query)
+		}
+		plan.record("s3", "", "", []string{r.method, target})
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+	}
+
+	req, err := http.NewRequest(r.method, c.endpoint, bytes.NewReader(r.body))
+	if err != nil {
+		return nil, err
+	}
+	// the key is not parsed as part of a URL, '?', '#' and '%' belong to it, and the path is sent exactly as it is signed
+	req.URL.Path = strings.TrimSuffix(req.URL.Path, "/") + path
+	req.URL.RawPath = uriEncode(req.URL.Path, false)
+	req.URL.RawQuery = canonicalQuery(r.query)
+	for name, values := range r.header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	signV4(req, c.accessKey, c.secretKey, s3Region, "s3", sha256Hex(r.body), time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	s3Err := &s3Error{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if xml.Unmarshal(body, s3Err) != nil {
+		json.Unmarshal(body, s3Err)
+	}
+	if s3Err.Code == "" {
+		s3Err.Code = http.StatusText(resp.StatusCode)
+	}
+	return nil, s3Err
+}
+
+// call sends the request and reads the whole response body
+func (c *s3Client) call(r s3Request) ([]byte, error) {
+	resp, err := c.do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// putXML sends a configuration document of a bucket
+func (c *s3Client) putXML(bucket, subresource string, v interface{}) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.putDocument(bucket, "", subresource, body)
+}
+
+// putDocument sends a settings document of a bucket, or of an object when key is set
+// S3 requires Content-MD5 for most of them, the policy is the only JSON one
+func (c *s3Client) putDocument(bucket, key, subresource string, body []byte) error {
+	contentType := "application/xml"
+	if subresource == "policy" {
+		contentType = "application/json"
+	}
+	sum := md5.Sum(body)
+	header := http.Header{
+		"Content-Type": {contentType},
+		"Content-MD5":  {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+	_, err := c.call(s3Request{method: http.MethodPut, bucket: bucket, key: key, query: url.Values{subresource: {""}}, header: header, body: body})
+	return err
+}
+
+// signV4 adds the AWS signature V4 headers to the request, every header already set is signed
+func signV4(req *http.Request, accessKey, secretKey, region, service, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4Date)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{amzDate[:8], region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery sorts and encodes the query the way it is signed, a sub-resource without value becomes "name="
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986, as signature V4 expects
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// objectETag returns the ETag S3 computes for an object uploaded in parts of partSize, or in one request when it is not larger
+func objectETag(r io.Reader, size, partSize int64) (string, error) {
+	if size <= partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	// the ETag of a multipart object is the MD5 of the MD5 of its parts, followed by the number of parts
+	sums := md5.New()
+	parts := 0
+	for ; int64(parts)*partSize < size; parts++ {
+		h := md5.New()
+		if _, err := io.CopyN(h, r, partSize); err != nil && err != io.EOF {
+			return "", err
+		}
+		sums.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+// headObject returns the headers of an object, a missing object is a NoSuchKey error
+func (c *s3Client) headObject(bucket, key string) (http.Header, error) {
+	resp, err := c.do(s3Request{method: http.MethodHead, bucket: bucket, key: key})
+	if isS3Error(err, http.StatusText(http.StatusNotFound)) {
+		// a HEAD answer has no error document
+		return nil, &s3Error{StatusCode: http.StatusNotFound, Code: "NoSuchKey"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+func (c *s3Client) putObject(bucket, key string, header http.Header, body []byte) error {
+	_, err := c.call(s3Request{method: http.MethodPut, bucket: bucket, key: key, header: header, body: body})
+	return err
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipartObject uploads an object in parts of partSize read from r, the upload is aborted on failure
+func (c *s3Client) putMultipartObject(bucket, key string, header http.Header, r io.Reader, partSize int64) error {
+	body, err := c.call(s3Request{method: http.MethodPost, bucket: bucket, key: key, query: url.Values{"uploads": {""}}, header: header})
+	if err != nil {
+		return err
+	}
+	var upload initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &upload); err != nil && !dryRun {
+		return fmt.Errorf("failed to parse the multipart upload of %s: %v", key, err)
+	}
+
+	complete := completeMultipartUpload{}
+	err = func() error {
+		buf := make([]byte, partSize)
+		for number := 1; ; number++ {
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {upload.UploadID}}
+			resp, err := c.do(s3Request{method: http.MethodPut, bucket: bucket, key: key, query: query, body: buf[:n]})
+			if err != nil {
+				return fmt.Errorf("part %d: %v", number, err)
+			}
+			resp.Body.Close()
+			complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+			if n < len(buf) {
+				return nil
+			}
+		}
+	}()
+	if err == nil {
+		content, _ := xml.Marshal(complete)
+		_, err = c.call(s3Request{method: http.MethodPost, bucket: bucket, key: key, query: url.Values{"uploadId": {upload.UploadID}}, body: content})
+	}
+	if err != nil {
+		c.call(s3Request{method: http.MethodDelete, bucket: bucket, key: key, query: url.Values{"uploadId": {upload.UploadID}}})
+		return err
+	}
+	return nil
+}
+
+type listAllMyBucketsResult struct {
+	Buckets []struct {
+		Name string `xml:"Name"`
+	} `xml:"Buckets>Bucket"`
+}
+
+// listBuckets returns the buckets of the user of the client
+func (c *s3Client) listBuckets() ([]string, error) {
+	body, err := c.call(s3Request{method: http.MethodGet})
+	if err != nil {
+		return nil, err
+	}
+	var result listAllMyBucketsResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse the bucket list: %v", err)
+	}
+	buckets := make([]string, len(result.Buckets))
+	for i, b := range result.Buckets {
+		buckets[i] = b.Name
+	}
+	return buckets, nil
+}
+
+// objectVersion is a version or a delete marker of an object
+type objectVersion struct {
+	Key          string    `xml:"Key"`
+	VersionID    string    `xml:"VersionId"`
+	IsLatest     bool      `xml:"IsLatest"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	DeleteMarker bool      `xml:"-"`
+}
+
+type listVersionsResult struct {
+	IsTruncated         bool            `xml:"IsTruncated"`
+	NextKeyMarker       string          `xml:"NextKeyMarker"`
+	NextVersionIDMarker string          `xml:"NextVersionIdMarker"`
+	Versions            []objectVersion `xml:"Version"`
+	DeleteMarkers       []objectVersion `xml:"DeleteMarker"`
+}
+
+// listObjectVersions returns every version of every object, the versions of a key from the newest
+// An unversioned bucket lists one version per object, its version id is "null"
+func (c *s3Client) listObjectVersions(bucket string) ([]objectVersion, error) {
+	var versions []objectVersion
+	query := url.Values{"versions": {""}}
+	for {
+		body, err := c.call(s3Request{method: http.MethodGet, bucket: bucket, query: query})
+		if err != nil {
+			return nil, err
+		}
+		var result listVersionsResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse the versions of %s: %v", bucket, err)
+		}
+		versions = append(versions, result.Versions...)
+		for _, marker := range result.DeleteMarkers {
+			marker.DeleteMarker = true
+			versions = append(versions, marker)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query = url.Values{"versions": {""}, "key-marker": {result.NextKeyMarker}, "version-id-marker": {result.NextVersionIDMarker}}
+	}
+
+	// the delete markers came apart from the versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// getDocument returns a settings document of a bucket, or of a version of an object when key is set, e.g: acl
+// It is nil when there is none, S3 answers 404 with a code of its own per document
+func (c *s3Client) getDocument(bucket, key, versionID, subresource string) ([]byte, error) {
+	query := url.Values{subresource: {""}}
+	if versionID != "" {
+		query.Set("versionId", versionID)
+	}
+	body, err := c.call(s3Request{method: http.MethodGet, bucket: bucket, key: key, query: query})
+	if err != nil {
+		if isS3Error(err, "NoSuchBucket", "NoSuchKey", "NoSuchVersion") {
+			return nil, err
+		}
+		if e, ok := err.(*s3Error); ok && e.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+// getObject returns a version of an object, the latest when versionID is empty, the caller closes the body
+func (c *s3Client) getObject(bucket, key, versionID string) (*http.Response, error) {
+	r := s3Request{method: http.MethodGet, bucket: bucket, key: key}
+	if versionID != "" {
+		r.query = url.Values{"versionId": {versionID}}
+	}
+	return c.do(r)
+}
@@ -0,0 +1,437 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// seedMetaSuffix marks the sidecar file holding the headers of the object next to it
+	seedMetaSuffix = ".cn-meta.json"
+	// seedBucketSuffix marks the settings of the bucket of the same name, e.g: photos.cn-bucket.json
+	seedBucketSuffix = ".cn-bucket.json"
+	// seedVersionsSuffix marks the directory of the previous versions of the objects of a bucket, e.g: photos.cn-versions/cat.jpg/0001
+	seedVersionsSuffix = ".cn-versions"
+	seedWorkers        = 4
+)
+
+// seedPartSize is the size of the parts of the objects uploaded in several parts, tests lower it
+var seedPartSize int64 = 16 << 20
+
+// seedMeta is the sidecar of an object, e.g: photos/cat.jpg.cn-meta.json
+type seedMeta struct {
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	CacheControl    string            `json:"cache_control,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	// ACL is the AccessControlPolicy document of the object
+	ACL string `json:"acl,omitempty"`
+	// the version and date the object had when it was dumped, for reference only
+	VersionID    string `json:"version_id,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// seedBucket holds the settings of a bucket, documents are kept as S3 returns them
+type seedBucket struct {
+	Owner      string `json:"owner,omitempty"`
+	Versioning string `json:"versioning,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	ACL        string `json:"acl,omitempty"`
+	CORS       string `json:"cors,omitempty"`
+	Lifecycle  string `json:"lifecycle,omitempty"`
+}
+
+// seedObject is a file of the seed directory and the object it becomes
+type seedObject struct {
+	Bucket string
+	Key    string
+	Path   string // on the host
+	Size   int64
+	// Versions are the files of the previous versions, from the oldest
+	Versions []string
+}
+type seedFailure struct {
+	Bucket string
+	Key    string
+	Err    error
+}
+
+// seedReport sums up an import, it is safe to update from several workers
+type seedReport struct {
+	sync.Mutex
+	Uploaded int
+	Skipped  int
+	Bytes    int64
+	Failures []seedFailure
+}
+
+func (r *seedReport) add(object seedObject, skipped bool, err error) {
+	r.Lock()
+	defer r.Unlock()
+	switch {
+	case err != nil:
+		r.Failures = append(r.Failures, seedFailure{Bucket: object.Bucket, Key: object.Key, Err: err})
+	case skipped:
+		r.Skipped++
+	default:
+		r.Uploaded++
+		r.Bytes += object.Size
+	}
+}
+
+func (r *seedReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d object(s) uploaded (%s), %d already present, %d failed", r.Uploaded, formatBytes(uint64(r.Bytes)), r.Skipped, len(r.Failures))
+	for _, f := range r.Failures {
+		fmt.Fprintf(&b, "\n  %s/%s: %v", f.Bucket, f.Key, f.Err)
+	}
+	return b.String()
+}
+
+// seedObjects uploads the seed directory with the cn user, or the owners of the buckets, every directory is a bucket and every file below an object
+func seedObjects() {
+	if err := waitRgw(rgwWaitTimeout); err != nil {
+		log.Fatalf("init rgw: cannot import the seed objects: %v", err)
+	}
+	creds := s3Credentials{User: cnCoreRgwUserUID, AccessKey: "<access key>", SecretKey: "<secret key>"}
+	if !dryRun {
+		var err error
+		if creds, err = cnCredentials(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Println("init rgw: importing the seed objects from " + conf.SeedDir)
+	report, err := importSeed(localS3Client(creds), conf.SeedDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(report.Failures) > 0 {
+		log.Fatalf("init rgw: seed import: %s", report)
+	}
+	log.Printf("init rgw: seed import: %s", report)
+}
+
+// importSeed creates the buckets of the seed directory and uploads the objects missing or changed in them
+func importSeed(client *s3Client, dir string) (*seedReport, error) {
+	buckets, objects, err := scanSeed(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// a bucket is created, configured and filled by its owner
+	report := &seedReport{}
+	clients := map[string]*s3Client{}
+	for _, bucket := range buckets {
+		settings, err := readSeedBucket(dir, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("seed: %v", err)
+		}
+		owner, err := seedOwnerClient(client, settings.Owner)
+		if err != nil {
+			return nil, fmt.Errorf("seed: owner %s of bucket %s: %v", settings.Owner, bucket, err)
+		}
+		clients[bucket] = owner
+
+		if err := createBucket(owner, bucket, false); err != nil {
+			return nil, fmt.Errorf("seed: failed to create bucket %s: %v", bucket, err)
+		}
+		if err := applySeedBucket(owner, bucket, settings); err != nil {
+			return nil, fmt.Errorf("seed: failed to configure bucket %s: %v", bucket, err)
+		}
+	}
+
+	// the plan of a dry run lists the uploads in order
+	workers := seedWorkers
+	if dryRun {
+		workers = 1
+	}
+	jobs := make(chan seedObject)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range jobs {
+				skipped, err := uploadSeedObject(clients[object.Bucket], object)
+				report.add(object, skipped, err)
+			}
+		}()
+	}
+	for _, object := range objects {
+		jobs <- object
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(report.Failures, func(i, j int) bool {
+		return report.Failures[i].Bucket+"/"+report.Failures[i].Key < report.Failures[j].Bucket+"/"+report.Failures[j].Key
+	})
+	return report, nil
+}
+
+// scanSeed lists the buckets and the objects of the seed directory
+// Files at its top are not in a bucket and are ignored, but for the settings of the buckets
+func scanSeed(dir string) ([]string, []seedObject, error) {
+	root := hostPath(dir)
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("seed: %v", err)
+	}
+
+	var buckets []string
+	var objects []seedObject
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case entry.IsDir() && strings.HasSuffix(name, seedVersionsSuffix):
+			continue
+		case !entry.IsDir():
+			if !strings.HasSuffix(name, seedBucketSuffix) {
+				log.Printf("init rgw: seed: ignoring %s, objects go in a bucket directory", name)
+			}
+			continue
+		}
+		bucket := name
+		if !bucketNameRegexp.MatchString(bucket) {
+			return nil, nil, fmt.Errorf("seed: %s is not a valid bucket name", bucket)
+		}
+		buckets = append(buckets, bucket)
+
+		// the versions of a key are the files of its directory, named after their order
+		versions := map[string][]string{}
+		files, err := scanSeedFiles(filepath.Join(root, bucket+seedVersionsSuffix))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("seed: %v", err)
+		}
+		for _, file := range files {
+			key := path.Dir(file.key)
+			versions[key] = append(versions[key], file.path)
+		}
+
+		files, err = scanSeedFiles(filepath.Join(root, bucket))
+		if err != nil {
+			return nil, nil, fmt.Errorf("seed: %v", err)
+		}
+		for _, file := range files {
+			objects = append(objects, seedObject{Bucket: bucket, Key: file.key, Path: file.path, Size: file.size, Versions: versions[file.key]})
+		}
+	}
+	return buckets, objects, nil
+}
+
+type seedFile struct {
+	key  string
+	path string
+	size int64
+}
+
+// scanSeedFiles lists the files below a directory but the sidecars, in lexical order
+func scanSeedFiles(dir string) ([]seedFile, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	var files []seedFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, seedMetaSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, seedFile{key: filepath.ToSlash(rel), path: path, size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// readSeedBucket reads the settings of a bucket, they are empty when the seed directory does not have them
+func readSeedBucket(dir, bucket string) (seedBucket, error) {
+	var settings seedBucket
+	content, err := ioutil.ReadFile(filepath.Join(hostPath(dir), bucket+seedBucketSuffix))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(content, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse %s: %v", bucket+seedBucketSuffix, err)
+	}
+	return settings, nil
+}
+
+// seedOwnerClient returns a client signing as the owner of a bucket, tenant$uid for the user of a tenant
+// The owner is created like the owner of a fixture when it does not exist, the cn user when it is not set
+func seedOwnerClient(client *s3Client, owner string) (*s3Client, error) {
+	if owner == "" || owner == cnCoreRgwUserUID {
+		return client, nil
+	}
+	tenant, uid := "", owner
+	if i := strings.Index(owner, "$"); i >= 0 {
+		tenant, uid = owner[:i], owner[i+1:]
+	}
+	creds, err := fixtureOwnerCredentials(tenant, uid)
+	if err != nil {
+		return nil, err
+	}
+	return newS3Client(client.endpoint, creds), nil
+}
+
+// applySeedBucket applies the settings of a bucket
+func applySeedBucket(client *s3Client, bucket string, settings seedBucket) error {
+	if settings.Versioning != "" {
+		if err := client.putXML(bucket, "versioning", versioningConfiguration{XMLNS: s3XMLNS, Status: settings.Versioning}); err != nil {
+			return fmt.Errorf("versioning: %v", err)
+		}
+	}
+	for _, doc := range []struct{ subresource, content string }{
+		{"policy", settings.Policy}, {"acl", settings.ACL}, {"cors", settings.CORS}, {"lifecycle", settings.Lifecycle},
+	} {
+		if doc.content == "" {
+			continue
+		}
+		if err := client.putDocument(bucket, "", doc.subresource, []byte(doc.content)); err != nil {
+			return fmt.Errorf("%s: %v", doc.subresource, err)
+		}
+	}
+	return nil
+}
+
+// uploadSeedObject uploads a file unless the object already has the same content, it tells if the upload was skipped
+// The previous versions are uploaded first, when the object is not in the bucket yet
+func uploadSeedObject(client *s3Client, object seedObject) (bool, error) {
+	f, err := os.Open(object.Path)
+	if err != nil {
+		return false, err
+	}
+	etag, err := objectETag(f, object.Size, seedPartSize)
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+
+	current, err := client.headObject(object.Bucket, object.Key)
+	switch {
+	case isS3Error(err, "NoSuchKey"):
+		for _, version := range object.Versions {
+			if err := uploadSeedFile(client, object.Bucket, object.Key, version); err != nil {
+				return false, fmt.Errorf("version %s: %v", filepath.Base(version), err)
+			}
+		}
+	case err != nil:
+		return false, err
+	case strings.Trim(current.Get("ETag"), `"`) == etag:
+		return true, nil
+	}
+	return false, uploadSeedFile(client, object.Bucket, object.Key, object.Path)
+}
+
+// uploadSeedFile uploads a file with the headers and the ACL of its sidecar
+func uploadSeedFile(client *s3Client, bucket, key, path string) error {
+	meta, err := readSeedMeta(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := meta.header(key)
+	if info.Size() > seedPartSize {
+		err = client.putMultipartObject(bucket, key, header, f, seedPartSize)
+	} else {
+		var body []byte
+		if body, err = ioutil.ReadAll(f); err == nil {
+			err = client.putObject(bucket, key, header, body)
+		}
+	}
+	if err != nil || meta.ACL == "" {
+		return err
+	}
+	if err := client.putDocument(bucket, key, "acl", []byte(meta.ACL)); err != nil {
+		return fmt.Errorf("acl: %v", err)
+	}
+	return nil
+}
+
+// readSeedMeta reads the sidecar of a file, a file without sidecar has none of its settings
+func readSeedMeta(path string) (seedMeta, error) {
+	var meta seedMeta
+	content, err := ioutil.ReadFile(path + seedMetaSuffix)
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse %s: %v", filepath.Base(path)+seedMetaSuffix, err)
+	}
+	return meta, nil
+}
+
+// header returns the headers of the object, the content type defaults to the one of the extension of its key
+func (meta seedMeta) header(key string) http.Header {
+	header := http.Header{}
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(key))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	if meta.ContentEncoding != "" {
+		header.Set("Content-Encoding", meta.ContentEncoding)
+	}
+	if meta.CacheControl != "" {
+		header.Set("Cache-Control", meta.CacheControl)
+	}
+	for name, value := range meta.Metadata {
+		header.Set("X-Amz-Meta-"+name, value)
+	}
+	return header
+}
@@ -0,0 +1,124 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestSeed writes the files of a seed directory, indexed by their path below it
+func writeTestSeed(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(root, "seed", path)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestImportSeed(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	defer func(size int64) { seedPartSize = size }(seedPartSize)
+	seedPartSize = 1024
+
+	big := bytes.Repeat([]byte("0123456789"), 250)
+	writeTestSeed(t, root, map[string]string{
+		"README":                               "not in a bucket",
+		"photos/cat.jpg":                       "meow",
+		"photos/docs/read me.txt":              "hello",
+		"photos/docs/read me.txt.cn-meta.json": `{"content_type": "text/markdown", "cache_control": "no-cache", "metadata": {"author": "alice"}}`,
+		"videos/big.bin":                       string(big),
+	})
+	client := localS3Client(s3Credentials{AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey"})
+
+	report, err := importSeed(client, "/seed")
+	assert.Nil(t, err)
+	assert.Equal(t, "3 object(s) uploaded (2.5KiB), 0 already present, 0 failed", report.String())
+	assert.True(t, fake.buckets["photos"] && fake.buckets["videos"])
+
+	assert.Equal(t, "meow", string(fake.objects["photos/cat.jpg"].data))
+	assert.Equal(t, "image/jpeg", fake.objects["photos/cat.jpg"].header.Get("Content-Type"))
+	readme := fake.objects["photos/docs/read me.txt"]
+	assert.Equal(t, http.Header{"Content-Type": {"text/markdown"}, "Cache-Control": {"no-cache"}, "X-Amz-Meta-Author": {"alice"}}, readme.header)
+	assert.Nil(t, fake.objects["photos/docs/read me.txt.cn-meta.json"])
+
+	// the large file went in 3 parts, its ETag is the one computed locally
+	assert.Equal(t, big, fake.objects["videos/big.bin"].data)
+	etag, err := objectETag(bytes.NewReader(big), int64(len(big)), seedPartSize)
+	assert.Nil(t, err)
+	assert.Equal(t, etag, fake.objects["videos/big.bin"].etag)
+	assert.Contains(t, etag, "-3")
+	assert.Contains(t, fake.requests, "POST /videos/big.bin?uploads=")
+	assert.Empty(t, fake.uploads)
+
+	// a restart only uploads what changed
+	writeTestSeed(t, root, map[string]string{"photos/cat.jpg": "purr"})
+	report, err = importSeed(client, "/seed")
+	assert.Nil(t, err)
+	assert.Equal(t, "1 object(s) uploaded (4B), 2 already present, 0 failed", report.String())
+	assert.Equal(t, "purr", string(fake.objects["photos/cat.jpg"].data))
+}
+
+func TestImportSeedFailures(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	defer func(size int64) { seedPartSize = size }(seedPartSize)
+	seedPartSize = 4
+
+	writeTestSeed(t, root, map[string]string{
+		"photos/cat.jpg":              "meow",
+		"photos/dog.jpg":              "woof",
+		"photos/dog.jpg.cn-meta.json": `{"metadata": `,
+		"videos/big.bin":              "0123456789",
+	})
+	fake.errors["PUT /photos/cat.jpg"] = s3Error{StatusCode: http.StatusForbidden, Code: "QuotaExceeded"}
+	fake.errors["PUT /videos/big.bin?partNumber=2&uploadId=upload-1"] = s3Error{StatusCode: http.StatusInternalServerError, Code: "InternalError"}
+	client := localS3Client(s3Credentials{AccessKey: "CN4CCESSKEY0000000000", SecretKey: "cnSecretKey"})
+
+	report, err := importSeed(client, "/seed")
+	assert.Nil(t, err)
+	assert.Len(t, report.Failures, 3)
+	assert.Contains(t, report.String(), "0 object(s) uploaded (0B), 0 already present, 3 failed\n  photos/cat.jpg: QuotaExceeded (403)\n  photos/dog.jpg: failed to parse dog.jpg.cn-meta.json")
+
+	// a failed multipart upload is aborted
+	if assert.Equal(t, "videos/big.bin", report.Failures[2].Bucket+"/"+report.Failures[2].Key) {
+		assert.Contains(t, report.Failures[2].Err.Error(), "InternalError")
+	}
+	assert.Empty(t, fake.uploads)
+
+	// a bucket name S3 refuses stops the import before any upload
+	writeTestSeed(t, root, map[string]string{"Photos/cat.jpg": "meow"})
+	_, err = importSeed(client, "/seed")
+	assert.EqualError(t, err, "seed: Photos is not a valid bucket name")
+}
@@ -0,0 +1,338 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// initPidPath is where init publishes its pid, backup and purge run beside it and only signal its children
+const initPidPath = "/var/run/cn-core.pid"
+
+// stopGroup describes how to find and stop the processes of a daemon among the ones cn-core started
+type stopGroup struct {
+	name    string
+	comm    string // process name as seen in /proc/<pid>/comm, a prefix is enough
+	arg     string // optional argument the command line must contain
+	timeout time.Duration
+}
+
+var (
+	// shutdownOrder stops the daemons in reverse dependency order
+	shutdownOrder = []stopGroup{
+		{name: "dash", comm: "python", arg: "app.py", timeout: 5 * time.Second},
+		{name: "rgw", comm: "radosgw", timeout: 10 * time.Second},
+		{name: "osd", comm: "ceph-osd", timeout: 30 * time.Second},
+		{name: "mgr", comm: "ceph-mgr", timeout: 10 * time.Second},
+		{name: "mon", comm: "ceph-mon", timeout: 20 * time.Second},
+	}
+
+	// forwardedSignals are passed as is to every daemon, e.g: SIGHUP makes ceph reopen its logs
+	forwardedSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+	// children tracks the processes cn-core started and waits for itself, the reaper must leave them alone
+	// they are the only processes the shutdown and the forwarded signals reach, other processes may share the pid namespace
+	children   = map[int]bool{}
+	childrenMu sync.Mutex
+
+	shutdownOnce sync.Once
+)
+
+// startTracked starts a command cn-core is going to wait for
+func startTracked(cmd *exec.Cmd) error {
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	children[cmd.Process.Pid] = true
+
+	return nil
+}
+
+// waitTracked waits for a command started with startTracked
+func waitTracked(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	childrenMu.Lock()
+	delete(children, cmd.Process.Pid)
+	childrenMu.Unlock()
+
+	return err
+}
+
+// handleSignals installs the PID 1 duties: reaping orphans, forwarding signals and stopping the daemons
+func handleSignals() {
+	if err := ioutil.WriteFile(hostPath(initPidPath), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		log.Printf("signal: failed to publish the pid of init: %v", err)
+	}
+
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGCHLD}, forwardedSignals...)...)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGCHLD:
+				reapZombies()
+			case syscall.SIGTERM, syscall.SIGINT:
+				go shutdownOnce.Do(func() {
+					shutdown(sig)
+					os.Exit(0)
+				})
+			default:
+				forwardSignal(sig)
+			}
+		}
+	}()
+}
+
+// reapZombies waits for the orphaned processes re-parented to cn-core
+// Only PID 1 inherits orphans, otherwise there is nothing to do
+func reapZombies() {
+	if os.Getpid() != 1 {
+		return
+	}
+
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+
+	for _, pid := range zombieChildren() {
+		if children[pid] {
+			continue
+		}
+		var status syscall.WaitStatus
+		syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+	}
+}
+
+// zombieChildren returns our children that exited and were not waited for
+func zombieChildren() []int {
+	var pids []int
+	self := os.Getpid()
+	for _, pid := range listPids() {
+		state, ppid, err := readProcStat(pid)
+		if err == nil && state == "Z" && ppid == self {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+// forwardSignal sends a signal to every daemon
+func forwardSignal(sig os.Signal) {
+	for _, group := range shutdownOrder {
+		for _, pid := range group.pids() {
+			log.Printf("signal: forwarding %s to %s (pid %d)", sig, group.name, pid)
+			syscall.Kill(pid, sig.(syscall.Signal))
+		}
+	}
+}
+
+// shutdown stops the daemons in reverse dependency order
+func shutdown(sig os.Signal) {
+	log.Printf("shutdown: received %s, stopping daemons", sig)
+	if supervising() {
+		sup.shutdown()
+	}
+
+	for _, group := range shutdownOrder {
+		stopDaemon(group)
+	}
+	log.Println("shutdown: all daemons are stopped")
+}
+
+// stopDaemon sends SIGTERM to the processes of a daemon and kills them if they do not stop in time
+func stopDaemon(group stopGroup) {
+	pids := group.pids()
+	if len(pids) == 0 {
+		return
+	}
+
+	log.Printf("shutdown: stopping %s (pid %s), waiting up to %s", group.name, joinPids(pids), group.timeout)
+	for _, pid := range pids {
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+
+	if waitExit(pids, group.timeout) {
+		log.Printf("shutdown: %s stopped", group.name)
+		return
+	}
+
+	log.Printf("shutdown: %s did not stop within %s, killing it", group.name, group.timeout)
+	for _, pid := range pids {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	waitExit(pids, group.timeout)
+}
+
+// waitExit polls the processes until they are all gone, it returns false on timeout
+func waitExit(pids []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		alive := false
+		for _, pid := range pids {
+			if processAlive(pid) {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// processAlive tells if a process is running, a zombie already exited
+func processAlive(pid int) bool {
+	state, _, err := readProcStat(pid)
+	return err == nil && state != "Z"
+}
+
+// pids returns the running processes of a daemon, only the tracked children are considered
+func (g stopGroup) pids() []int {
+	var pids []int
+	for _, pid := range trackedPids() {
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm"))
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(comm)), g.comm) {
+			continue
+		}
+		if g.arg != "" {
+			cmdline, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+			if err != nil || !strings.Contains(string(cmdline), g.arg) {
+				continue
+			}
+		}
+		if processAlive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+// trackedPids returns the tracked children in ascending order
+// The commands running beside init, e.g: backup and purge, have none and take the children of init instead
+func trackedPids() []int {
+	if initPid := publishedInitPid(); initPid != 0 && initPid != os.Getpid() {
+		var pids []int
+		for _, pid := range listPids() {
+			if _, ppid, err := readProcStat(pid); err == nil && ppid == initPid {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
+	}
+
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+
+	pids := make([]int, 0, len(children))
+	for pid := range children {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// publishedInitPid returns the pid of the running init, 0 when there is none
+// A stale pid file may name another process, it must be cn-core
+func publishedInitPid() int {
+	content, err := ioutil.ReadFile(hostPath(initPidPath))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0
+	}
+	comm, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm"))
+	if err != nil || strings.TrimSpace(string(comm)) != cliName || !processAlive(pid) {
+		return 0
+	}
+	return pid
+}
+
+// listPids returns every process id found in /proc
+func listPids() []int {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	return pids
+}
+
+// readProcStat returns the state and the parent of a process from /proc/<pid>/stat
+func readProcStat(pid int) (string, int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	// the command name is between parenthesis and may contain spaces, the fields we want come after it
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return "", 0, os.ErrInvalid
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fields[0], ppid, nil
+}
+
+func joinPids(pids []int) string {
+	s := make([]string, len(pids))
+	for i, pid := range pids {
+		s[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,178 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProc creates a /proc with the given processes: pid -> comm, state, ppid and cmdline
+func fakeProc(t *testing.T, procs map[string][4]string) func() {
+	files := map[string]string{}
+	for pid, p := range procs {
+		files["proc/"+pid+"/comm"] = p[0] + "\n"
+		files["proc/"+pid+"/stat"] = pid + " (" + p[0] + ") " + p[1] + " " + p[2] + " 1 1 0 -1\n"
+		files["proc/"+pid+"/cmdline"] = p[3]
+	}
+	return fakeSysfs(t, files)
+}
+
+func TestReadProcStat(t *testing.T) {
+	defer fakeProc(t, map[string][4]string{
+		"42": {"tp_osd (tp)", "S", "1", ""},
+	})()
+
+	// the command name may contain spaces and parenthesis
+	state, ppid, err := readProcStat(42)
+	assert.Nil(t, err)
+	assert.Equal(t, "S", state)
+	assert.Equal(t, 1, ppid)
+
+	_, _, err = readProcStat(43)
+	assert.NotNil(t, err)
+}
+
+func TestStopGroupPids(t *testing.T) {
+	defer fakeProc(t, map[string][4]string{
+		"10": {"ceph-mon", "S", "1", "ceph-mon\x00--cluster\x00ceph"},
+		"11": {"ceph-osd", "S", "1", "ceph-osd\x00-i\x000"},
+		"12": {"ceph-osd", "S", "1", "ceph-osd\x00-i\x001"},
+		"13": {"ceph-osd", "Z", "1", ""},
+		"14": {"python3", "S", "1", "python3\x00app.py"},
+		"15": {"python3", "S", "1", "python3\x00other.py"},
+		"16": {"ceph-osd", "S", "1", "ceph-osd\x00-i\x002"},
+		"17": {"radosgw", "S", "1", "radosgw\x00-n\x00client.rgw.other"},
+	})()
+	// 16 and 17 belong to another container sharing the pid namespace
+	childrenMu.Lock()
+	children = map[int]bool{10: true, 11: true, 12: true, 13: true, 14: true, 15: true}
+	childrenMu.Unlock()
+	defer func() {
+		childrenMu.Lock()
+		children = map[int]bool{}
+		childrenMu.Unlock()
+	}()
+
+	groups := map[string]stopGroup{}
+	for _, g := range shutdownOrder {
+		groups[g.name] = g
+	}
+
+	assert.Equal(t, []int{10}, groups["mon"].pids())
+	// zombies already exited
+	assert.Equal(t, []int{11, 12}, groups["osd"].pids())
+	assert.Equal(t, []int{14}, groups["dash"].pids())
+	assert.Nil(t, groups["rgw"].pids())
+}
+
+func TestStopGroupPidsBesideInit(t *testing.T) {
+	root, cleanup := testRoot(t)
+	defer cleanup()
+	defer fakeProc(t, map[string][4]string{
+		"5":  {"cn-core", "S", "0", "cn-core\x00init"},
+		"10": {"ceph-osd", "S", "5", "ceph-osd\x00-i\x000"},
+		"11": {"ceph-osd", "S", "1", "ceph-osd\x00-i\x001"},
+		"12": {"bash", "S", "0", "bash"},
+	})()
+	osd := stopGroup{name: "osd", comm: "ceph-osd"}
+
+	// backup and purge signal nothing without a running init
+	assert.Nil(t, osd.pids())
+
+	// then only the children of init
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "var/run"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, initPidPath), []byte("5\n"), 0644))
+	assert.Equal(t, []int{10}, osd.pids())
+
+	// a stale pid file naming another process is ignored
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, initPidPath), []byte("12\n"), 0644))
+	assert.Nil(t, osd.pids())
+}
+
+func TestZombieChildren(t *testing.T) {
+	self := os.Getpid()
+	defer fakeProc(t, map[string][4]string{
+		"20": {"ceph-osd", "Z", "1", ""},
+		"21": {"ceph-mon", "S", "1", ""},
+	})()
+	if self != 1 {
+		assert.Nil(t, zombieChildren())
+	} else {
+		assert.Equal(t, []int{20}, zombieChildren())
+	}
+}
+
+func TestShutdownOrder(t *testing.T) {
+	var names []string
+	for _, g := range shutdownOrder {
+		names = append(names, g.name)
+	}
+	assert.Equal(t, []string{"dash", "rgw", "osd", "mgr", "mon"}, names)
+}
+
+func TestStopDaemon(t *testing.T) {
+	// a shell ignoring SIGTERM is killed once the timeout expires
+	cmd := exec.Command("sh", "-c", "trap '' TERM; while true; do sleep 0.1; done", "cn-core-stop-test")
+	assert.Nil(t, startTracked(cmd))
+	defer func() {
+		cmd.Process.Kill()
+		waitTracked(cmd)
+	}()
+
+	// wait for the shell to set its trap
+	time.Sleep(200 * time.Millisecond)
+
+	// sh may be a link to another shell, take its name from /proc
+	comm, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(cmd.Process.Pid), "comm"))
+	assert.Nil(t, err)
+	group := stopGroup{name: "test", comm: strings.TrimSpace(string(comm)), arg: "cn-core-stop-test", timeout: 300 * time.Millisecond}
+	assert.Equal(t, []int{cmd.Process.Pid}, group.pids())
+
+	start := time.Now()
+	stopDaemon(group)
+	assert.True(t, time.Since(start) >= group.timeout)
+	assert.Nil(t, group.pids())
+}
+
+func TestSupervisorShutdown(t *testing.T) {
+	s := newTestSupervisor(5)
+	assert.Nil(t, s.start("rgw", []string{"sleep", "5"}, "", true))
+
+	// once stopping, a daemon that exits is not restarted
+	s.shutdown()
+	assert.Nil(t, s.daemons[0].cmd.Process.Kill())
+	s.wg.Wait()
+
+	status := s.status()
+	assert.Equal(t, 0, status[0].Restarts)
+	assert.False(t, status[0].Running)
+}
@@ -0,0 +1,285 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ceph/cn-core/internal/ceph"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of 'cn-core status', scripts and the cn client branch on them
+const (
+	statusExitOK          = 0
+	statusExitWarn        = 1
+	statusExitErr         = 2
+	statusExitUnreachable = 3
+	statusExitUsage       = 4
+
+	healthUnreachable = "UNREACHABLE"
+)
+
+var (
+	statusFormat  string
+	statusTimeout int
+)
+
+// pgState is the number of placement groups in a given state
+type pgState struct {
+	State string `json:"state"`
+	Count int    `json:"count"`
+}
+
+// clusterSummary is what 'cn-core status' prints
+type clusterSummary struct {
+	Health      string    `json:"health"`
+	Checks      []string  `json:"checks,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Mons        int       `json:"mons"`
+	Quorum      []string  `json:"quorum"`
+	Osds        int       `json:"osds"`
+	OsdsUp      int       `json:"osds_up"`
+	OsdsIn      int       `json:"osds_in"`
+	Pgs         int       `json:"pgs"`
+	PgStates    []pgState `json:"pg_states"`
+	BytesUsed   uint64    `json:"bytes_used"`
+	BytesAvail  uint64    `json:"bytes_avail"`
+	BytesTotal  uint64    `json:"bytes_total"`
+	RgwEndpoint string    `json:"rgw_endpoint"`
+}
+
+// cliStatus is the Cobra CLI call
+func cliStatus() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print a summary of the cluster status",
+		Long: `Print a summary of the cluster status.
+
+Exit codes:
+  0  HEALTH_OK
+  1  HEALTH_WARN
+  2  HEALTH_ERR
+  3  the cluster is unreachable, or its health is unknown
+  4  invalid flags or configuration`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.NoArgs(cmd, args); err != nil {
+				exitStatusUsage(err)
+			}
+			return nil
+		},
+		Run:     statusCluster,
+		Example: "cn-core status --format line\n",
+	}
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		exitStatusUsage(err)
+		return nil
+	})
+	cmd.Flags().SortFlags = false
+	cmd.Flags().StringVar(&statusFormat, "format", "table", "Output format: table, json or line.")
+	cmd.Flags().IntVar(&statusTimeout, "timeout", 10, "Seconds to wait for the monitors before reporting the cluster as unreachable.")
+	addConfigFlags(cmd.Flags())
+
+	return cmd
+}
+
+// statusCluster prints the cluster summary and exits with a code matching the health
+func statusCluster(cmd *cobra.Command, args []string) {
+	if err := validateStatusFormat(statusFormat); err != nil {
+		exitStatusUsage(err)
+	}
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		exitStatusUsage(err)
+	}
+	conf = c
+
+	summary := getClusterSummary(statusTimeout)
+	if err := printStatus(os.Stdout, statusFormat, summary); err != nil {
+		exitStatusUsage(err)
+	}
+	os.Exit(statusExitCode(summary.Health))
+}
+
+// exitStatusUsage exits with a code of its own, scripts must not mistake a usage error for a cluster in HEALTH_WARN
+func exitStatusUsage(err error) {
+	log.Print(err)
+	os.Exit(statusExitUsage)
+}
+
+func validateStatusFormat(format string) error {
+	if format != "table" && format != "json" && format != "line" {
+		return fmt.Errorf("status: unknown format %q, valid choices are: table, json, line", format)
+	}
+	return nil
+}
+
+// getClusterSummary queries the monitors, an unreachable cluster is reported in the summary
+func getClusterSummary(timeout int) clusterSummary {
+	summary := clusterSummary{RgwEndpoint: rgwEndpoint()}
+
+	status, err := cephClient().WithTimeout(time.Duration(timeout) * time.Second).Status()
+	if err != nil {
+		summary.Health = healthUnreachable
+		summary.Error = err.Error()
+		if e, ok := err.(*ceph.Error); ok {
+			summary.Error = e.Reason()
+		}
+		return summary
+	}
+	summary.fill(status)
+
+	return summary
+}
+
+// fill copies the interesting bits of the ceph status
+func (s *clusterSummary) fill(status ceph.Status) {
+	s.Health = status.Health.Status
+	for name, check := range status.Health.Checks {
+		s.Checks = append(s.Checks, name+": "+check.Summary.Message)
+	}
+	sort.Strings(s.Checks)
+
+	s.Quorum = status.QuorumNames
+	s.Mons = status.MonMap.NumMons
+	if len(status.MonMap.Mons) > 0 {
+		s.Mons = len(status.MonMap.Mons)
+	}
+
+	s.Osds, s.OsdsUp, s.OsdsIn = status.OsdMap.NumOsds, status.OsdMap.NumUpOsds, status.OsdMap.NumInOsds
+
+	s.Pgs = status.PgMap.NumPgs
+	for _, state := range status.PgMap.PgsByState {
+		s.PgStates = append(s.PgStates, pgState{State: state.StateName, Count: state.Count})
+	}
+	sort.Slice(s.PgStates, func(i, j int) bool {
+		if s.PgStates[i].Count != s.PgStates[j].Count {
+			return s.PgStates[i].Count > s.PgStates[j].Count
+		}
+		return s.PgStates[i].State < s.PgStates[j].State
+	})
+	s.BytesUsed, s.BytesAvail, s.BytesTotal = status.PgMap.BytesUsed, status.PgMap.BytesAvail, status.PgMap.BytesTotal
+}
+
+// rgwEndpoint returns the address S3 clients use to reach Rados Gateway
+func rgwEndpoint() string {
+	host := conf.ExposedIP
+	if host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		host = hostname
+	}
+	if conf.rgwTLS() {
+		return "https://" + net.JoinHostPort(host, conf.RgwTLSPort)
+	}
+	return "http://" + net.JoinHostPort(host, conf.RgwPort)
+}
+
+// statusExitCode maps the health of the cluster to the exit code of 'cn-core status'
+func statusExitCode(health string) int {
+	switch health {
+	case "HEALTH_OK":
+		return statusExitOK
+	case "HEALTH_WARN":
+		return statusExitWarn
+	case "HEALTH_ERR":
+		return statusExitErr
+	}
+	// unreachable, or a health this cn-core does not know
+	return statusExitUnreachable
+}
+
+// printStatus writes the summary in the requested format
+func printStatus(w io.Writer, format string, s clusterSummary) error {
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+	case "line":
+		if s.Health == healthUnreachable {
+			fmt.Fprintf(w, "%s %s\n", s.Health, s.Error)
+			return nil
+		}
+		// key=value pairs without spaces so the line is easy to split
+		pgs := make([]string, len(s.PgStates))
+		for i, state := range s.PgStates {
+			pgs[i] = strconv.Itoa(state.Count) + ":" + state.State
+		}
+		fmt.Fprintf(w, "%s mon=%d/%d osd_up=%d/%d osd_in=%d/%d pg=%s usage=%s/%s rgw=%s\n",
+			s.Health, len(s.Quorum), s.Mons, s.OsdsUp, s.Osds, s.OsdsIn, s.Osds,
+			strings.Join(pgs, ","), formatBytes(s.BytesUsed), formatBytes(s.BytesTotal), s.RgwEndpoint)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "HEALTH\t%s\n", s.Health)
+		if s.Health == healthUnreachable {
+			fmt.Fprintf(tw, "ERROR\t%s\n", s.Error)
+			return tw.Flush()
+		}
+		for _, check := range s.Checks {
+			fmt.Fprintf(tw, "\t%s\n", check)
+		}
+		fmt.Fprintf(tw, "MON\t%d daemons, quorum %s\n", s.Mons, strings.Join(s.Quorum, ","))
+		fmt.Fprintf(tw, "OSD\t%d osds: %d up, %d in\n", s.Osds, s.OsdsUp, s.OsdsIn)
+		pgs := make([]string, len(s.PgStates))
+		for i, state := range s.PgStates {
+			pgs[i] = strconv.Itoa(state.Count) + " " + state.State
+		}
+		fmt.Fprintf(tw, "PG\t%d pgs: %s\n", s.Pgs, strings.Join(pgs, ", "))
+		fmt.Fprintf(tw, "USAGE\t%s used, %s / %s avail\n", formatBytes(s.BytesUsed), formatBytes(s.BytesAvail), formatBytes(s.BytesTotal))
+		fmt.Fprintf(tw, "RGW\t%s\n", s.RgwEndpoint)
+		return tw.Flush()
+	default:
+		return validateStatusFormat(format)
+	}
+
+	return nil
+}
+
+// formatBytes prints a size with a binary unit, e.g: 1.5GiB
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return strconv.FormatUint(b, 10) + "B"
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
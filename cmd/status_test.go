@@ -0,0 +1,153 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nautilus nests the osdmap and lists the mons
+const testStatusNautilus = `{
+  "fsid": "8f3e7b4a-5a4a-4c6b-9a0a-2f1c6c2d1b5e",
+  "health": {
+    "checks": {
+      "POOL_NO_REDUNDANCY": {"severity": "HEALTH_WARN", "summary": {"message": "1 pool(s) have no replicas configured"}}
+    },
+    "status": "HEALTH_WARN"
+  },
+  "quorum_names": ["vm"],
+  "monmap": {"epoch": 1, "mons": [{"rank": 0, "name": "vm"}]},
+  "osdmap": {"osdmap": {"epoch": 12, "num_osds": 2, "num_up_osds": 2, "num_in_osds": 1}},
+  "pgmap": {
+    "pgs_by_state": [{"state_name": "peering", "count": 2}, {"state_name": "active+clean", "count": 6}],
+    "num_pgs": 8,
+    "bytes_used": 1073741824,
+    "bytes_avail": 9663676416,
+    "bytes_total": 10737418240
+  }
+}`
+
+// later releases flatten the osdmap and only count the mons
+const testStatusOctopus = `{
+  "health": {"checks": {}, "status": "HEALTH_OK"},
+  "quorum_names": ["vm"],
+  "monmap": {"epoch": 1, "num_mons": 1},
+  "osdmap": {"epoch": 12, "num_osds": 1, "num_up_osds": 1, "num_in_osds": 1},
+  "pgmap": {"pgs_by_state": [{"state_name": "active+clean", "count": 8}], "num_pgs": 8}
+}`
+
+func replayStatus(t *testing.T, exitCode int, output string) clusterSummary {
+	runner = &replayRunner{entries: []transcriptEntry{{
+		Args:     []string{"ceph", "status", "--format", "json", "--connect-timeout", "5"},
+		ExitCode: exitCode,
+		Output:   output,
+	}}}
+	conf = defaultConfig()
+	conf.ExposedIP = "10.0.0.1"
+	defer func() {
+		runner = execRunner{}
+		conf = defaultConfig()
+	}()
+
+	return getClusterSummary(5)
+}
+
+func TestClusterSummaryNautilus(t *testing.T) {
+	s := replayStatus(t, 0, testStatusNautilus)
+
+	assert.Equal(t, "HEALTH_WARN", s.Health)
+	assert.Equal(t, []string{"POOL_NO_REDUNDANCY: 1 pool(s) have no replicas configured"}, s.Checks)
+	assert.Equal(t, 1, s.Mons)
+	assert.Equal(t, []string{"vm"}, s.Quorum)
+	assert.Equal(t, 2, s.Osds)
+	assert.Equal(t, 2, s.OsdsUp)
+	assert.Equal(t, 1, s.OsdsIn)
+	assert.Equal(t, 8, s.Pgs)
+	assert.Equal(t, []pgState{{"active+clean", 6}, {"peering", 2}}, s.PgStates)
+	assert.Equal(t, uint64(10737418240), s.BytesTotal)
+	assert.Equal(t, "http://10.0.0.1:8000", s.RgwEndpoint)
+	assert.Equal(t, statusExitWarn, statusExitCode(s.Health))
+}
+
+func TestClusterSummaryOctopus(t *testing.T) {
+	s := replayStatus(t, 0, testStatusOctopus)
+
+	assert.Equal(t, "HEALTH_OK", s.Health)
+	assert.Nil(t, s.Checks)
+	assert.Equal(t, 1, s.Mons)
+	assert.Equal(t, 1, s.Osds)
+	assert.Equal(t, statusExitOK, statusExitCode(s.Health))
+}
+
+func TestClusterSummaryUnreachable(t *testing.T) {
+	s := replayStatus(t, 1, "[errno 110] error connecting to the cluster\n")
+
+	assert.Equal(t, healthUnreachable, s.Health)
+	assert.Equal(t, "[errno 110] error connecting to the cluster", s.Error)
+	assert.Equal(t, statusExitUnreachable, statusExitCode(s.Health))
+
+	s = replayStatus(t, 0, "not json")
+	assert.Equal(t, healthUnreachable, s.Health)
+}
+
+func TestStatusExitCode(t *testing.T) {
+	assert.Equal(t, 0, statusExitCode("HEALTH_OK"))
+	assert.Equal(t, 1, statusExitCode("HEALTH_WARN"))
+	assert.Equal(t, 2, statusExitCode("HEALTH_ERR"))
+	assert.Equal(t, 3, statusExitCode(healthUnreachable))
+	assert.Equal(t, 3, statusExitCode(""))
+	assert.Equal(t, 3, statusExitCode("HEALTH_UNKNOWN"))
+}
+
+func TestPrintStatus(t *testing.T) {
+	s := replayStatus(t, 0, testStatusNautilus)
+
+	var line bytes.Buffer
+	assert.Nil(t, printStatus(&line, "line", s))
+	assert.Equal(t, "HEALTH_WARN mon=1/1 osd_up=2/2 osd_in=1/2 pg=6:active+clean,2:peering usage=1.0GiB/10.0GiB rgw=http://10.0.0.1:8000\n", line.String())
+
+	var table bytes.Buffer
+	assert.Nil(t, printStatus(&table, "table", s))
+	assert.Contains(t, table.String(), "HEALTH  HEALTH_WARN\n")
+	assert.Contains(t, table.String(), "OSD     2 osds: 2 up, 1 in\n")
+	assert.Contains(t, table.String(), "PG      8 pgs: 6 active+clean, 2 peering\n")
+	assert.Contains(t, table.String(), "USAGE   1.0GiB used, 9.0GiB / 10.0GiB avail\n")
+
+	var out bytes.Buffer
+	assert.Nil(t, printStatus(&out, "json", s))
+	var decoded clusterSummary
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, s, decoded)
+
+	assert.NotNil(t, printStatus(&out, "yaml", s))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KiB", formatBytes(1536))
+	assert.Equal(t, "10.0GiB", formatBytes(10737418240))
+}
@@ -0,0 +1,273 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	supervisorBackoffMin    = 1 * time.Second
+	supervisorBackoffMax    = 30 * time.Second
+	supervisorRestartWindow = 10 * time.Minute
+	// a daemon running longer than this is considered healthy again and its backoff is reset
+	supervisorStableRun = 1 * time.Minute
+)
+
+// supervisedDaemon is a daemon running in the foreground as a child of cn-core
+type supervisedDaemon struct {
+	name     string
+	args     []string
+	dir      string
+	required bool
+
+	cmd      *exec.Cmd
+	restarts int
+	history  []time.Time // restarts within the window
+	running  bool
+	failed   bool
+}
+
+// daemonStatus is a snapshot of a supervised daemon
+type daemonStatus struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	Running  bool   `json:"running"`
+	Failed   bool   `json:"failed"`
+	Restarts int    `json:"restarts"`
+	Pid      int    `json:"pid,omitempty"`
+}
+
+// supervisor keeps the daemons running and restarts them when they crash
+type supervisor struct {
+	budget     int
+	window     time.Duration
+	backoffMin time.Duration
+	backoffMax time.Duration
+	stableRun  time.Duration
+
+	mu      sync.Mutex
+	daemons []*supervisedDaemon
+	wg      sync.WaitGroup
+	failure chan error
+	// stopping is set on shutdown, daemons exiting afterwards are not restarted
+	stopping bool
+}
+
+var (
+	// daemons started with supervise are watched by sup
+	sup *supervisor
+)
+
+func newSupervisor(budget int) *supervisor {
+	return &supervisor{
+		budget:     budget,
+		window:     supervisorRestartWindow,
+		backoffMin: supervisorBackoffMin,
+		backoffMax: supervisorBackoffMax,
+		stableRun:  supervisorStableRun,
+		failure:    make(chan error, 1),
+	}
+}
+
+// supervising tells if the daemons run in the foreground under the supervisor
+func supervising() bool {
+	return sup != nil
+}
+
+// superviseDaemon starts a daemon in the foreground and hands it to the supervisor
+func superviseDaemon(name string, cmd *exec.Cmd, required bool) {
+	if dryRun {
+		if err := startCommand(cmd); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := sup.start(name, cmd.Args, cmd.Dir, required); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// start runs a new daemon under supervision
+func (s *supervisor) start(name string, args []string, dir string, required bool) error {
+	d := &supervisedDaemon{name: name, args: args, dir: dir, required: required}
+	if err := s.spawn(d); err != nil {
+		return fmt.Errorf("supervisor: failed to start %s: %v", name, err)
+	}
+
+	s.mu.Lock()
+	s.daemons = append(s.daemons, d)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.watch(d)
+
+	return nil
+}
+
+// spawn starts the process of a daemon, bypassing the command runner: supervised daemons are neither recorded nor replayed
+// The runner only starts a command, the supervisor waits for the process and restarts it
+func (s *supervisor) spawn(d *supervisedDaemon) error {
+	cmd := exec.Command(d.args[0], d.args[1:]...)
+	cmd.Dir = d.dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := startTracked(cmd); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	d.cmd = cmd
+	d.running = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+// watch waits for a daemon to exit and restarts it until it runs out of restart budget
+func (s *supervisor) watch(d *supervisedDaemon) {
+	defer s.wg.Done()
+
+	backoff := s.backoffMin
+	for {
+		started := time.Now()
+		err := waitTracked(d.cmd)
+
+		s.mu.Lock()
+		d.running = false
+		s.mu.Unlock()
+
+		if s.isStopping() {
+			log.Printf("supervisor: %s exited (%v)", d.name, err)
+			return
+		}
+
+		if err == nil {
+			err = fmt.Errorf("exit status 0")
+		}
+		if time.Since(started) > s.stableRun {
+			backoff = s.backoffMin
+		}
+
+		if !s.allowRestart(d) {
+			log.Printf("supervisor: %s exited (%v) and exceeded its restart budget of %d in %s, giving up", d.name, err, s.budget, s.window)
+			s.fail(d, fmt.Errorf("supervisor: required daemon %s exceeded its restart budget", d.name))
+			return
+		}
+
+		log.Printf("supervisor: %s exited (%v), restarting in %s (restart %d)", d.name, err, backoff, d.restarts)
+		time.Sleep(backoff)
+
+		if s.isStopping() {
+			return
+		}
+		if err := s.spawn(d); err != nil {
+			log.Printf("supervisor: failed to restart %s: %v", d.name, err)
+			s.fail(d, fmt.Errorf("supervisor: failed to restart required daemon %s: %v", d.name, err))
+			return
+		}
+
+		backoff *= 2
+		if backoff > s.backoffMax {
+			backoff = s.backoffMax
+		}
+	}
+}
+
+// fail marks a daemon as failed for good, cn-core stops if the daemon is required
+func (s *supervisor) fail(d *supervisedDaemon, err error) {
+	s.mu.Lock()
+	d.failed = true
+	s.mu.Unlock()
+
+	if d.required {
+		select {
+		case s.failure <- err:
+		default:
+		}
+	}
+}
+
+// allowRestart records a restart if the daemon still has some budget within the window
+func (s *supervisor) allowRestart(d *supervisedDaemon) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var history []time.Time
+	for _, t := range d.history {
+		if now.Sub(t) < s.window {
+			history = append(history, t)
+		}
+	}
+	d.history = history
+
+	if len(d.history) >= s.budget {
+		return false
+	}
+	d.history = append(d.history, now)
+	d.restarts++
+
+	return true
+}
+
+// shutdown stops restarting the daemons, they are then stopped by the caller
+func (s *supervisor) shutdown() {
+	s.mu.Lock()
+	s.stopping = true
+	s.mu.Unlock()
+}
+
+func (s *supervisor) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopping
+}
+
+// wait blocks until a required daemon fails for good
+func (s *supervisor) wait() error {
+	return <-s.failure
+}
+
+// status returns a snapshot of every supervised daemon
+func (s *supervisor) status() []daemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]daemonStatus, len(s.daemons))
+	for i, d := range s.daemons {
+		statuses[i] = daemonStatus{Name: d.name, Required: d.required, Running: d.running, Failed: d.failed, Restarts: d.restarts}
+		if d.running && d.cmd.Process != nil {
+			statuses[i].Pid = d.cmd.Process.Pid
+		}
+	}
+
+	return statuses
+}
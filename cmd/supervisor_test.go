@@ -0,0 +1,88 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSupervisor(budget int) *supervisor {
+	s := newSupervisor(budget)
+	s.backoffMin = time.Millisecond
+	s.backoffMax = 4 * time.Millisecond
+	return s
+}
+
+func TestSupervisorRestartBudget(t *testing.T) {
+	s := newTestSupervisor(2)
+	assert.Nil(t, s.start("osd.0", []string{"sh", "-c", "exit 1"}, "", true))
+
+	select {
+	case err := <-s.failure:
+		assert.Contains(t, err.Error(), "osd.0")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the supervisor never gave up on osd.0")
+	}
+
+	status := s.status()
+	assert.Equal(t, 1, len(status))
+	assert.Equal(t, 2, status[0].Restarts)
+	assert.True(t, status[0].Failed)
+	assert.False(t, status[0].Running)
+}
+
+func TestSupervisorOptionalDaemon(t *testing.T) {
+	s := newTestSupervisor(1)
+	assert.Nil(t, s.start("dash", []string{"sh", "-c", "exit 1"}, "", false))
+	s.wg.Wait()
+
+	// an optional daemon never stops cn-core
+	select {
+	case err := <-s.failure:
+		t.Fatalf("unexpected failure: %v", err)
+	default:
+	}
+	assert.True(t, s.status()[0].Failed)
+}
+
+func TestSupervisorRunning(t *testing.T) {
+	s := newTestSupervisor(0)
+	assert.Nil(t, s.start("mon", []string{"sleep", "5"}, "", true))
+
+	status := s.status()
+	assert.True(t, status[0].Running)
+	assert.NotEqual(t, 0, status[0].Pid)
+
+	// without budget the daemon is not restarted once killed
+	assert.Nil(t, s.daemons[0].cmd.Process.Kill())
+	assert.NotNil(t, s.wait())
+	assert.False(t, s.status()[0].Running)
+}
+
+func TestSupervisorStartFailure(t *testing.T) {
+	s := newTestSupervisor(1)
+	assert.NotNil(t, s.start("mon", []string{"/nonexistent/ceph-mon"}, "", true))
+}
@@ -0,0 +1,320 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	rgwTLSDir       = cephConfigPath + "/tls"
+	rgwTLSCAPath    = rgwTLSDir + "/ca.crt"
+	rgwTLSCAKeyPath = rgwTLSDir + "/ca.key"
+	rgwTLSCertPath  = rgwTLSDir + "/rgw.crt"
+	rgwTLSKeyPath   = rgwTLSDir + "/rgw.key"
+	// copies of rgw_tls_cert and rgw_tls_key radosgw can read once it dropped its privileges
+	rgwTLSMountedCertPath = rgwTLSDir + "/mounted.crt"
+	rgwTLSMountedKeyPath  = rgwTLSDir + "/mounted.key"
+
+	tlsCAValidity   = 10 * 365 * 24 * time.Hour
+	tlsCertValidity = 365 * 24 * time.Hour
+	// tlsRenewBefore is how long before its expiry the server certificate is issued again
+	tlsRenewBefore = 30 * 24 * time.Hour
+)
+
+// rgwTLS tells if Rados Gateway serves HTTPS
+func (c *cnConfig) rgwTLS() bool {
+	b, _ := strconv.ParseBool(c.RgwTLS)
+	return b
+}
+
+// rgwTLSFiles returns the certificate, the key and the CA bundle Rados Gateway and its clients use
+// The CA bundle is empty when mounted certificates are signed by a CA the clients already trust
+func (c *cnConfig) rgwTLSFiles() (string, string, string) {
+	if c.RgwTLSCert != "" {
+		return rgwTLSMountedCertPath, rgwTLSMountedKeyPath, c.RgwTLSCA
+	}
+	return rgwTLSCertPath, rgwTLSKeyPath, rgwTLSCAPath
+}
+
+// rgwTLSNames returns the names and addresses the server certificate must cover
+func rgwTLSNames(hostname string) ([]string, []net.IP) {
+	names := []string{hostname, "localhost"}
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	for _, addr := range []string{conf.ExposedIP, conf.MonIP, conf.BindAddress} {
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.IsUnspecified() || containsIP(ips, ip) {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return names, ips
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupRgwTLS makes sure Rados Gateway has a certificate before it starts
+// A mounted certificate is copied as is, otherwise the server certificate is issued again by the local CA
+// whenever it does not cover the current names, e.g: EXPOSED_IP changed, or is about to expire
+func setupRgwTLS(hostname string) {
+	if conf.RgwTLSCert != "" {
+		if !dryRun {
+			if _, err := tls.LoadX509KeyPair(hostPath(conf.RgwTLSCert), hostPath(conf.RgwTLSKey)); err != nil {
+				log.Fatalf("init rgw: invalid rgw_tls_cert or rgw_tls_key: %v", err)
+			}
+		}
+		// copied on every start, so a renewed certificate is picked up by a restart
+		log.Println("init rgw: installing rgw certificate " + conf.RgwTLSCert)
+		if err := installRgwCert(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if _, err := stat(rgwTLSCAPath); os.IsNotExist(err) {
+		runStep("rgw", journalStep{Name: "tls-ca", Paths: []string{rgwTLSCAPath, rgwTLSCAKeyPath}}, generateRgwCA)
+	} else {
+		skipStep("rgw CA " + rgwTLSCAPath + " already exists")
+	}
+
+	names, ips := rgwTLSNames(hostname)
+	if reason := rgwCertOutdated(names, ips); reason != "" {
+		log.Println("init rgw: issuing rgw certificate, " + reason)
+		if err := issueRgwCert(names, ips); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		skipStep("rgw certificate " + rgwTLSCertPath + " is up to date")
+	}
+}
+
+// generateRgwCA creates the CA signing the server certificate, its certificate is the bundle the clients trust
+func generateRgwCA() {
+	log.Println("init rgw: generating rgw CA")
+	if err := mkdirAll(rgwTLSDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if dryRun {
+		writeFile(rgwTLSCAKeyPath, nil, 0600)
+		writeFile(rgwTLSCAPath, nil, 0644)
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template, err := newCertTemplate("cn-core CA", tlsCAValidity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatalf("init rgw: failed to create the CA: %v", err)
+	}
+	if err := writeKeyPair(rgwTLSCAPath, rgwTLSCAKeyPath, der, key); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rgwCertOutdated returns why the server certificate must be issued again, or an empty string
+func rgwCertOutdated(names []string, ips []net.IP) string {
+	if dryRun {
+		return "dry run"
+	}
+	pair, err := tls.LoadX509KeyPair(hostPath(rgwTLSCertPath), hostPath(rgwTLSKeyPath))
+	if err != nil {
+		return "no valid certificate"
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return "no valid certificate"
+	}
+	ca, _, err := loadKeyPair(rgwTLSCAPath, rgwTLSCAKeyPath)
+	if err != nil || cert.CheckSignatureFrom(ca) != nil {
+		return "signed by another CA"
+	}
+	if time.Now().Add(tlsRenewBefore).After(cert.NotAfter) {
+		return "expires on " + cert.NotAfter.Format("2006-01-02")
+	}
+	for _, name := range names {
+		if cert.VerifyHostname(name) != nil {
+			return "does not cover " + name
+		}
+	}
+	for _, ip := range ips {
+		if !containsIP(cert.IPAddresses, ip) {
+			return "does not cover " + ip.String()
+		}
+	}
+	return ""
+}
+
+// issueRgwCert signs a server certificate for the names and addresses with the local CA
+func issueRgwCert(names []string, ips []net.IP) error {
+	if dryRun {
+		writeFile(rgwTLSKeyPath, nil, 0600)
+		return writeFile(rgwTLSCertPath, nil, 0644)
+	}
+
+	ca, caKey, err := loadKeyPair(rgwTLSCAPath, rgwTLSCAKeyPath)
+	if err != nil {
+		return fmt.Errorf("init rgw: failed to load the CA: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := newCertTemplate(names[0], tlsCertValidity)
+	if err != nil {
+		return err
+	}
+	template.DNSNames = names
+	template.IPAddresses = ips
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("init rgw: failed to issue the certificate: %v", err)
+	}
+	if err := writeKeyPair(rgwTLSCertPath, rgwTLSKeyPath, der, key); err != nil {
+		return err
+	}
+
+	// radosgw drops its privileges before it loads the certificate
+	for _, path := range []string{rgwTLSCertPath, rgwTLSKeyPath} {
+		if err := chown(path, cephUID, cephGID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installRgwCert copies the mounted certificate and key where radosgw can read them
+// A mounted key is often readable by root only, radosgw runs as ceph
+func installRgwCert() error {
+	if err := mkdirAll(rgwTLSDir, 0755); err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		src, dst string
+		perm     os.FileMode
+	}{
+		{conf.RgwTLSKey, rgwTLSMountedKeyPath, 0600},
+		{conf.RgwTLSCert, rgwTLSMountedCertPath, 0644},
+	} {
+		var content []byte
+		if !dryRun {
+			var err error
+			if content, err = ioutil.ReadFile(hostPath(file.src)); err != nil {
+				return err
+			}
+		}
+		if err := writeFile(file.dst, content, file.perm); err != nil {
+			return err
+		}
+		if err := chown(file.dst, cephUID, cephGID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCertTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	// backdated so a clock a bit behind on the client side does not reject a fresh certificate
+	notBefore := time.Now().Add(-time.Hour)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Ceph Nano"}, CommonName: commonName},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+	}, nil
+}
+
+// writeKeyPair writes the key before the certificate, a certificate on disk always has its key
+func writeKeyPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return writeFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func loadKeyPair(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPem, err := ioutil.ReadFile(hostPath(certPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPem, err := ioutil.ReadFile(hostPath(keyPath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPem)
+	keyBlock, _ := pem.Decode(keyPem)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s or %s is not PEM encoded", certPath, keyPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyTestCert checks the server certificate against the CA bundle for a name or an address
+func verifyTestCert(t *testing.T, root, name string) error {
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM([]byte(readTestFile(t, root, rgwTLSCAPath))))
+
+	block, _ := pem.Decode([]byte(readTestFile(t, root, rgwTLSCertPath)))
+	assert.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: name, Roots: pool})
+	return err
+}
+
+func TestSetupRgwTLS(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.RgwTLS, conf.ExposedIP = "true", "192.168.0.10"
+
+	setupRgwTLS("nano")
+	for _, name := range []string{"nano", "localhost", "127.0.0.1", "::1", "192.168.0.10"} {
+		assert.Nil(t, verifyTestCert(t, root, name), name)
+	}
+	assert.NotNil(t, verifyTestCert(t, root, "192.168.0.11"))
+	info, err := os.Stat(filepath.Join(root, rgwTLSKeyPath))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// an up to date certificate is kept
+	ca, cert := readTestFile(t, root, rgwTLSCAPath), readTestFile(t, root, rgwTLSCertPath)
+	setupRgwTLS("nano")
+	assert.Equal(t, cert, readTestFile(t, root, rgwTLSCertPath))
+
+	// a new address gets a new certificate from the same CA
+	conf.ExposedIP = "192.168.0.11"
+	setupRgwTLS("nano")
+	assert.Equal(t, ca, readTestFile(t, root, rgwTLSCAPath))
+	assert.NotEqual(t, cert, readTestFile(t, root, rgwTLSCertPath))
+	assert.Nil(t, verifyTestCert(t, root, "192.168.0.11"))
+
+	// so does a certificate without its key
+	assert.Nil(t, os.Remove(filepath.Join(root, rgwTLSKeyPath)))
+	assert.Equal(t, "no valid certificate", rgwCertOutdated(rgwTLSNames("nano")))
+}
+
+func TestSetupRgwTLSMounted(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	conf.RgwTLS = "true"
+
+	// the generated pair stands for a mounted one
+	setupRgwTLS("nano")
+	cert, key := readTestFile(t, root, rgwTLSCertPath), readTestFile(t, root, rgwTLSKeyPath)
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "/certs"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "/certs/s3.pem"), []byte(cert), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "/certs/s3.key"), []byte(key), 0400))
+	conf.RgwTLSCert, conf.RgwTLSKey = "/certs/s3.pem", "/certs/s3.key"
+
+	setupRgwTLS("nano")
+	assert.Equal(t, cert, readTestFile(t, root, rgwTLSMountedCertPath))
+	assert.Equal(t, key, readTestFile(t, root, rgwTLSMountedKeyPath))
+	info, err := os.Stat(filepath.Join(root, rgwTLSMountedKeyPath))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestRgwTLSClients(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+
+	assert.Equal(t, "beast endpoint=0.0.0.0:8000", rgwFrontends())
+
+	conf.RgwTLS = "true"
+	assert.Equal(t, "beast endpoint=0.0.0.0:8000 ssl_endpoint=0.0.0.0:8443 ssl_certificate=/etc/ceph/tls/rgw.crt ssl_private_key=/etc/ceph/tls/rgw.key", rgwFrontends())
+
+	hostname, _ := os.Hostname()
+	configureClients("s3cmd")
+	assert.Equal(t, "[default]\naccess_key = CN4CCESSKEY0000000000\nsecret_key = cnSecretKey\nhost_base = "+hostname+":8443\nhost_bucket = "+hostname+":8443\nuse_https = True\nca_certs_file = /etc/ceph/tls/ca.crt\ncheck_ssl_certificate = True\n", readTestFile(t, root, s3CmdFilePath))
+
+	// a certificate from a public CA needs no bundle
+	conf.RgwTLSCert, conf.RgwTLSKey = "/certs/s3.pem", "/certs/s3.key"
+	assert.Equal(t, "beast endpoint=0.0.0.0:8000 ssl_endpoint=0.0.0.0:8443 ssl_certificate=/etc/ceph/tls/mounted.crt ssl_private_key=/etc/ceph/tls/mounted.key", rgwFrontends())
+	configureClients("s3cmd")
+	assert.Equal(t, "[default]\naccess_key = CN4CCESSKEY0000000000\nsecret_key = cnSecretKey\nhost_base = "+hostname+":8443\nhost_bucket = "+hostname+":8443\nuse_https = True\n", readTestFile(t, root, s3CmdFilePath))
+}
@@ -0,0 +1,401 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// cnUsersDir holds the credentials of the users created with 'cn-core user create'
+	cnUsersDir = "/opt/ceph-container/tmp/users"
+)
+
+// userOptions are the flags of the user subcommands
+type userOptions struct {
+	tenant      string
+	displayName string
+	caps        string
+	maxBuckets  int
+	suspended   bool
+	purgeData   bool
+	accessKey   string
+	secretKey   string
+}
+
+var userOpts userOptions
+
+// rgwUser is a user as printed by the user subcommands
+type rgwUser struct {
+	UserID      string   `json:"user_id"`
+	Tenant      string   `json:"tenant,omitempty"`
+	DisplayName string   `json:"display_name"`
+	Suspended   bool     `json:"suspended"`
+	MaxBuckets  int      `json:"max_buckets"`
+	Caps        []rgwCap `json:"caps"`
+	Keys        []rgwKey `json:"keys"`
+	// DetailsFile is where the credentials of the user were written
+	DetailsFile string `json:"details_file,omitempty"`
+}
+
+type rgwCap struct {
+	Type string `json:"type"`
+	Perm string `json:"perm"`
+}
+
+type rgwKey struct {
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+// rgwUserInfo is the subset of a user we use, as radosgw-admin and the Admin Ops API describe it, the uid of a tenant user is tenant$uid
+type rgwUserInfo struct {
+	UserID      string   `json:"user_id"`
+	DisplayName string   `json:"display_name"`
+	Suspended   int      `json:"suspended"`
+	MaxBuckets  int      `json:"max_buckets"`
+	Caps        []rgwCap `json:"caps"`
+	Keys        []rgwKey `json:"keys"`
+}
+
+// cliUser is the Cobra CLI call
+func cliUser() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Manage the S3 users of Rados Gateway",
+		Args:  cobra.NoArgs,
+	}
+
+	create := &cobra.Command{
+		Use:     "create UID",
+		Short:   "Create a user and write its credentials to " + cnUsersDir,
+		Args:    cobra.ExactArgs(1),
+		Run:     createUserCmd,
+		Example: "cn-core user create alice --tenant acme --caps 'buckets=read' --max-buckets 10\n",
+	}
+	create.Flags().SortFlags = false
+	addTenantFlag(create)
+	create.Flags().StringVar(&userOpts.displayName, "display-name", "", "Specify the display name, default is the uid.")
+	create.Flags().StringVar(&userOpts.caps, "caps", "", "Specify the admin capabilities, e.g: 'users=read;buckets=*'.")
+	create.Flags().IntVar(&userOpts.maxBuckets, "max-buckets", 1000, "Specify the maximum number of buckets, 0 is unlimited.")
+	create.Flags().BoolVar(&userOpts.suspended, "suspended", false, "Create the user suspended.")
+
+	list := &cobra.Command{
+		Use:     "list",
+		Short:   "List the users, without their secret keys",
+		Args:    cobra.NoArgs,
+		Run:     listUsersCmd,
+		Example: "cn-core user list --tenant acme\n",
+	}
+	addTenantFlag(list)
+
+	del := &cobra.Command{
+		Use:     "delete UID",
+		Short:   "Delete a user and its credentials file",
+		Args:    cobra.ExactArgs(1),
+		Run:     deleteUserCmd,
+		Example: "cn-core user delete alice --tenant acme --purge-data\n",
+	}
+	del.Flags().SortFlags = false
+	addTenantFlag(del)
+	del.Flags().BoolVar(&userOpts.purgeData, "purge-data", false, "Delete the buckets and objects of the user too.")
+
+	keys := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the S3 keys of a user",
+		Args:  cobra.NoArgs,
+	}
+	keysAdd := &cobra.Command{
+		Use:     "add UID",
+		Short:   "Add an S3 key to a user, generated unless given",
+		Args:    cobra.ExactArgs(1),
+		Run:     addUserKeyCmd,
+		Example: "cn-core user keys add alice --tenant acme\n",
+	}
+	keysAdd.Flags().SortFlags = false
+	addTenantFlag(keysAdd)
+	keysAdd.Flags().StringVar(&userOpts.accessKey, "access-key", "", "Specify the access key.")
+	keysAdd.Flags().StringVar(&userOpts.secretKey, "secret-key", "", "Specify the secret key.")
+	keysRm := &cobra.Command{
+		Use:     "rm UID ACCESS_KEY",
+		Short:   "Remove an S3 key from a user",
+		Args:    cobra.ExactArgs(2),
+		Run:     removeUserKeyCmd,
+		Example: "cn-core user keys rm alice 0555b35654ad1656d804 --tenant acme\n",
+	}
+	addTenantFlag(keysRm)
+	keys.AddCommand(keysAdd, keysRm)
+	for _, leaf := range []*cobra.Command{create, list, del, keysAdd, keysRm} {
+		addConfigFlags(leaf.Flags())
+	}
+
+	cmd.AddCommand(create, list, del, keys)
+
+	return cmd
+}
+
+func addTenantFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&userOpts.tenant, "tenant", "", "Specify the tenant of the user.")
+}
+
+func createUserCmd(cmd *cobra.Command, args []string) {
+	loadUserConfig(cmd)
+	user, err := createUser(args[0], userOpts, cmd.Flags().Changed("max-buckets"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(os.Stdout, user)
+}
+
+func listUsersCmd(cmd *cobra.Command, args []string) {
+	loadUserConfig(cmd)
+	users, err := listUsers(userOpts.tenant)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(os.Stdout, users)
+}
+
+func deleteUserCmd(cmd *cobra.Command, args []string) {
+	loadUserConfig(cmd)
+	if err := deleteUser(userOpts.tenant, args[0], userOpts.purgeData); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func addUserKeyCmd(cmd *cobra.Command, args []string) {
+	loadUserConfig(cmd)
+	user, err := addUserKey(userOpts.tenant, args[0], userOpts.accessKey, userOpts.secretKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(os.Stdout, user)
+}
+
+func removeUserKeyCmd(cmd *cobra.Command, args []string) {
+	loadUserConfig(cmd)
+	user, err := removeUserKey(userOpts.tenant, args[0], args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(os.Stdout, user)
+}
+
+func printJSON(w io.Writer, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(w, string(out))
+}
+
+// userDetailsFile returns where the credentials of a user are kept, the cn user keeps its historical file
+func userDetailsFile(tenant, uid string) string {
+	if tenant == "" && uid == cnCoreRgwUserUID {
+		return cnUserDetailsFile
+	}
+	if tenant != "" {
+		return filepath.Join(cnUsersDir, tenant, uid+"_user_details")
+	}
+	return filepath.Join(cnUsersDir, uid+"_user_details")
+}
+
+// loadUserConfig reads the configuration, the Admin Ops API is reached on rgw_port
+func loadUserConfig(cmd *cobra.Command) {
+	c, err := loadConfig(cmd.Flags())
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf = c
+}
+
+// newRgwUser turns a user of the Admin Ops API into a user as printed, the uid of a tenant user is tenant$uid
+func newRgwUser(info rgwUserInfo) rgwUser {
+	user := rgwUser{
+		UserID:      info.UserID,
+		DisplayName: info.DisplayName,
+		Suspended:   info.Suspended != 0,
+		MaxBuckets:  info.MaxBuckets,
+		Caps:        info.Caps,
+		Keys:        info.Keys,
+	}
+	if i := strings.Index(info.UserID, "$"); i >= 0 {
+		user.Tenant, user.UserID = info.UserID[:i], info.UserID[i+1:]
+	}
+	if user.Caps == nil {
+		user.Caps = []rgwCap{}
+	}
+	if user.Keys == nil {
+		user.Keys = []rgwKey{}
+	}
+	return user
+}
+
+// saveUserDetails writes the user as Rados Gateway describes it, like cnUserDetailsFile, so the same readers work
+func saveUserDetails(info rgwUserInfo, user *rgwUser) error {
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := userDetailsFile(user.Tenant, user.UserID)
+	// the cn user details predate this command and stay readable
+	perm := os.FileMode(0600)
+	if path == cnUserDetailsFile {
+		perm = 0644
+	}
+	if err := mkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := writeFile(path, out, perm); err != nil {
+		return fmt.Errorf("failed to write the credentials of %s: %v", user.UserID, err)
+	}
+	user.DetailsFile = path
+	return nil
+}
+
+// createUser creates a user, maxBucketsSet tells if the default of Rados Gateway must be overridden
+func createUser(uid string, opts userOptions, maxBucketsSet bool) (rgwUser, error) {
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return rgwUser{}, err
+	}
+	spec := rgwUserSpec{DisplayName: opts.displayName, Caps: opts.caps, Suspended: opts.suspended}
+	if spec.DisplayName == "" {
+		spec.DisplayName = uid
+	}
+	if maxBucketsSet {
+		spec.MaxBuckets = &opts.maxBuckets
+	}
+
+	info, err := admin.createUser(rgwAdminUID(opts.tenant, uid), spec)
+	if err != nil {
+		return rgwUser{}, fmt.Errorf("failed to create user %s: %v", uid, err)
+	}
+	user := newRgwUser(info)
+	return user, saveUserDetails(info, &user)
+}
+
+// listUsers returns the users of a tenant, or every user, without their secret keys
+func listUsers(tenant string) ([]rgwUser, error) {
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return nil, err
+	}
+	uids, err := admin.users()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the users: %v", err)
+	}
+
+	users := []rgwUser{}
+	for _, uid := range uids {
+		if tenant != "" && !strings.HasPrefix(uid, tenant+"$") {
+			continue
+		}
+		info, err := admin.user(uid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user %s: %v", uid, err)
+		}
+		user := newRgwUser(info)
+		for i := range user.Keys {
+			user.Keys[i].SecretKey = ""
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// deleteUser deletes a user and its credentials file, the cn user is needed by the clients cn-core configures
+func deleteUser(tenant, uid string, purgeData bool) error {
+	if tenant == "" && uid == cnCoreRgwUserUID {
+		return fmt.Errorf("user %s is used by the clients cn-core configures, it cannot be deleted", uid)
+	}
+
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return err
+	}
+	if err := admin.removeUser(rgwAdminUID(tenant, uid), purgeData); err != nil {
+		return fmt.Errorf("failed to delete user %s: %v", uid, err)
+	}
+	return removeAll(userDetailsFile(tenant, uid))
+}
+
+// addUserKey adds an S3 key to a user, generated by Rados Gateway unless given
+func addUserKey(tenant, uid, accessKey, secretKey string) (rgwUser, error) {
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return rgwUser{}, err
+	}
+	if _, err := admin.createKey(rgwAdminUID(tenant, uid), accessKey, secretKey); err != nil {
+		return rgwUser{}, fmt.Errorf("failed to add a key to user %s: %v", uid, err)
+	}
+	return updateUserKeys(admin, tenant, uid)
+}
+
+// removeUserKey removes an S3 key from a user
+func removeUserKey(tenant, uid, accessKey string) (rgwUser, error) {
+	admin, err := localRgwAdmin()
+	if err != nil {
+		return rgwUser{}, err
+	}
+	// the clients cn-core configures, and this one, use the first key of the cn user
+	next := admin
+	if tenant == "" && uid == cnCoreRgwUserUID {
+		info, err := admin.user(uid)
+		if err != nil {
+			return rgwUser{}, fmt.Errorf("failed to get user %s: %v", uid, err)
+		}
+		if len(info.Keys) == 1 && info.Keys[0].AccessKey == accessKey {
+			return newRgwUser(info), fmt.Errorf("%s is the last key of user %s, add another one first", accessKey, uid)
+		}
+		for _, key := range info.Keys {
+			if admin.s3.accessKey == accessKey && key.AccessKey != accessKey {
+				next = newRgwAdmin(admin.s3.endpoint, s3Credentials{User: uid, AccessKey: key.AccessKey, SecretKey: key.SecretKey})
+				break
+			}
+		}
+	}
+
+	if err := admin.removeKey(rgwAdminUID(tenant, uid), accessKey); err != nil {
+		return rgwUser{}, fmt.Errorf("failed to remove key %s of user %s: %v", accessKey, uid, err)
+	}
+	return updateUserKeys(next, tenant, uid)
+}
+
+// updateUserKeys reads the user once its keys changed, the credentials file follows
+func updateUserKeys(admin *rgwAdmin, tenant, uid string) (rgwUser, error) {
+	info, err := admin.user(rgwAdminUID(tenant, uid))
+	if err != nil {
+		return rgwUser{}, fmt.Errorf("failed to get user %s: %v", uid, err)
+	}
+	user := newRgwUser(info)
+	return user, saveUserDetails(info, &user)
+}
@@ -0,0 +1,151 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the cn user details as radosgw-admin writes them on bootstrap, trimmed to the fields we read
+const testUserCn = `{"user_id": "cn", "display_name": "Ceph Nano user", "suspended": 0, "max_buckets": 1000,
+"keys": [{"user": "cn", "access_key": "CN4CCESSKEY0000000000", "secret_key": "cnSecretKey"}], "caps": []}`
+
+func TestCreateUser(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	opts := userOptions{tenant: "acme", displayName: "Alice", caps: "buckets=read", maxBuckets: 10, suspended: true}
+	user, err := createUser("alice", opts, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"PUT /admin/user?display-name=Alice&format=json&generate-key=true&key-type=s3&max-buckets=10&suspended=true&uid=acme%24alice&user-caps=buckets%3Dread"}, fake.requests)
+
+	var out bytes.Buffer
+	printJSON(&out, user)
+	assert.Equal(t, `{
+  "user_id": "alice",
+  "tenant": "acme",
+  "display_name": "Alice",
+  "suspended": true,
+  "max_buckets": 10,
+  "caps": [
+    {
+      "type": "buckets",
+      "perm": "read"
+    }
+  ],
+  "keys": [
+    {
+      "access_key": "ALICEACCESSKEY000000",
+      "secret_key": "aliceSecret"
+    }
+  ],
+  "details_file": "/opt/ceph-container/tmp/users/acme/alice_user_details"
+}
+`, out.String())
+
+	// the details are kept in the format of cnUserDetailsFile
+	creds, err := loadCredentials(user.DetailsFile)
+	assert.Nil(t, err)
+	assert.Equal(t, s3Credentials{User: "acme$alice", AccessKey: "ALICEACCESSKEY000000", SecretKey: "aliceSecret"}, creds)
+	info, err := os.Stat(filepath.Join(root, user.DetailsFile))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// failures carry the reason given by Rados Gateway
+	_, err = createUser("alice", userOptions{tenant: "acme"}, false)
+	assert.EqualError(t, err, "failed to create user alice: UserAlreadyExists (409)")
+}
+
+func TestListUsers(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	_, err := createUser("alice", userOptions{tenant: "acme", caps: "buckets=read"}, false)
+	assert.Nil(t, err)
+
+	users, err := listUsers("")
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+	assert.Equal(t, "cn", users[1].UserID)
+	assert.Equal(t, []rgwCap{}, users[1].Caps)
+	assert.Equal(t, []rgwKey{{AccessKey: "ALICEACCESSKEY000000"}}, users[0].Keys)
+
+	users, err = listUsers("acme")
+	assert.Nil(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "acme", users[0].Tenant)
+}
+
+func TestUserKeys(t *testing.T) {
+	_, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+
+	// the cn user details are what the clients are configured from
+	user, err := addUserKey("", "cn", "CN4CCESSKEY1111111111", "")
+	assert.Nil(t, err)
+	assert.Len(t, user.Keys, 2)
+	assert.Equal(t, cnUserDetailsFile, user.DetailsFile)
+	assert.Equal(t, rgwKey{AccessKey: "CN4CCESSKEY1111111111", SecretKey: "cnSecret1"}, user.Keys[1])
+
+	_, err = removeUserKey("", "cn", "CN4CCESSKEY0000000000")
+	assert.Nil(t, err)
+	creds, err := cnCredentials()
+	assert.Nil(t, err)
+	assert.Equal(t, "CN4CCESSKEY1111111111", creds.AccessKey)
+
+	// the last key of the cn user stays
+	_, err = removeUserKey("", "cn", "CN4CCESSKEY1111111111")
+	assert.EqualError(t, err, "CN4CCESSKEY1111111111 is the last key of user cn, add another one first")
+	_, err = removeUserKey("", "bob", "BOBACCESSKEY00000000")
+	assert.EqualError(t, err, "failed to remove key BOBACCESSKEY00000000 of user bob: NoSuchUser (404)")
+}
+
+func TestDeleteUser(t *testing.T) {
+	root, cleanup := testClientsRoot(t)
+	defer cleanup()
+	fake := newFakeS3(t)
+	defer fake.close()
+	user, err := createUser("alice", userOptions{tenant: "acme"}, false)
+	assert.Nil(t, err)
+	assert.Nil(t, createBucket(localS3Client(s3Credentials{AccessKey: user.Keys[0].AccessKey}), "invoices", false))
+
+	// the buckets of the user go with it
+	assert.EqualError(t, deleteUser("acme", "alice", false), "failed to delete user alice: BucketNotEmpty (409)")
+	assert.Nil(t, deleteUser("acme", "alice", true))
+	assert.Contains(t, fake.requests, "DELETE /admin/user?format=json&purge-data=true&uid=acme%24alice")
+	assert.False(t, fake.buckets["invoices"])
+	_, err = os.Stat(filepath.Join(root, user.DetailsFile))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NotNil(t, deleteUser("", "cn", false))
+}
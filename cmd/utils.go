@@ -27,18 +27,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -87,18 +82,19 @@ func generateUUID() string {
 	return uuid.String()
 }
 
-func generateCephConf(hostname, rgwEngine, rgwPort string) (string, string) {
-	fsid := generateUUID()
-	return fmt.Sprintf(cephConfTemplate, fsid), fsid
-}
-
-func writeCephConf(hostname, cephConfFilePath string) string {
+// writeCephConf renders ceph.conf for the cluster fsid, a new fsid is generated if it is empty
+func writeCephConf(hostname, cephConfFilePath, fsid string) string {
 	log.Println("init mon: writing ceph configuration file")
 
-	cephConf, fsid := generateCephConf(hostname, rgwEngine, rgwPort)
-	cephConfBytes := []byte(cephConf)
+	if fsid == "" {
+		fsid = generateUUID()
+	}
+	cephConf, err := renderCephConf(hostname, fsid)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	err := ioutil.WriteFile(cephConfFilePath, cephConfBytes, 0644)
+	err = writeFile(cephConfFilePath, []byte(cephConf), 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -116,89 +112,11 @@ func chownR(path string, uid, gid int) error {
 	})
 }
 
-func getAwsKeys() (string, string) {
-	jsonFile, err := os.Open(cnUserDetailsFile)
-	if err != nil {
-		fmt.Println(err)
-	}
-	// defer the closing of our jsonFile so that we can parse it later on
-	defer jsonFile.Close()
-
-	byteValue, _ := ioutil.ReadAll(jsonFile)
-
-	// declare structures for json
-	type s3Details []struct {
-		AccessKey string `json:"Access_key"`
-		SecretKey string `json:"Secret_key"`
-	}
-	type jason struct {
-		Keys s3Details
-	}
-	// assign variable to our json struct
-	var parsedMap jason
-
-	json.Unmarshal(byteValue, &parsedMap)
-
-	cnAccessKey := parsedMap.Keys[0].AccessKey
-	cnSecretKey := parsedMap.Keys[0].SecretKey
-
-	return cnAccessKey, cnSecretKey
-}
-
-func fetchAdminKeyring(monKeyringPath string) ([]byte, []string, error) {
+// fetchAdminKeyring writes the keyring of client.admin, it authenticates as mon. since the admin keyring may not exist yet
+func fetchAdminKeyring(monKeyringPath string) error {
 	log.Println("init mgr: fetching admin keyring")
 
-	cmd := exec.Command("ceph", "-n", "mon.", "-k", monKeyringPath, "auth", "get-or-create", "client.admin", "-o", adminKeyringPath)
-	out, err := cmd.CombinedOutput()
-	return out, cmd.Args, err
-}
-
-func sedFile(path, old, new string) {
-	read, err := ioutil.ReadFile(path)
-	if err != nil {
-		panic(err)
-	}
-
-	newContents := strings.Replace(string(read), old, new, -1)
-
-	err = ioutil.WriteFile(path, []byte(newContents), 0)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func configureClients(client string, arg ...string) {
-	cnAccessKey, cnSecretKey := getAwsKeys()
-
-	switch client {
-	case "s3cmd":
-		log.Println("init rgw: configure s3cmd client")
-		sedFile(s3CmdFilePath, "AWS_ACCESS_KEY_PLACEHOLDER", cnAccessKey)
-		sedFile(s3CmdFilePath, "AWS_SECRET_KEY_PLACEHOLDER", cnSecretKey)
-		sedFile(s3CmdFilePath, "localhost", arg[0]) // this is always one arg, not sure why making the string default makes it a slice...
-
-	case "dashboard":
-		// Read ENV and search for a value for dashExposedIP
-		dashExposedIPEnv := os.Getenv("EXPOSED_IP")
-		if len(dashExposedIPEnv) > 0 {
-			dashExposedIP = dashExposedIPEnv
-		}
-
-		log.Println("init dashboard: configure dashboard")
-		path := dashboardDir + "static/js/base.js"
-		sedFile(path, "ENDPOINT", "http://"+dashExposedIP+":"+rgwPort)
-		sedFile(path, "ACCESS_KEY", cnAccessKey)
-		sedFile(path, "SECRET_KEY", cnSecretKey)
-
-		err := os.Link(dashboardDir+"sree.cfg.sample", dashboardDir+"sree.cfg")
-		if err != nil {
-			log.Fatal(err)
-		}
-		path = dashboardDir + "sree.cfg"
-		sedFile(path, "RGW_CIVETWEB_PORT_VALUE", rgwPort)
-		sedFile(path, "SREE_PORT_VALUE", dashPort)
-	}
-
+	return cephClient().As("mon.", monKeyringPath).AuthGetOrCreate("client.admin", adminKeyringPath)
 }
 
 func cephHealth() error {
@@ -212,7 +130,11 @@ func cephHealth() error {
 	log.Println("init: running ceph health watcher")
 
 	// declare command to execute
-	cmd := exec.Command("ceph", "-w")
+	cmd := cephClient().Command("-w")
+	if dryRun {
+		plan.record("run", "", "", cmd.Args)
+		return nil
+	}
 
 	// get an io reader for stdout
 	stdout, err := cmd.StdoutPipe()
@@ -227,7 +149,7 @@ func cephHealth() error {
 	}
 
 	// start the command
-	if err := cmd.Start(); err != nil {
+	if err := startTracked(cmd); err != nil {
 		return err
 	}
 
@@ -250,7 +172,7 @@ func cephHealth() error {
 	// If stdout succeeds this means waiting 'forever'
 	wg.Wait()
 
-	if err := cmd.Wait(); err != nil {
+	if err := waitTracked(cmd); err != nil {
 		return err
 	}
 
@@ -273,59 +195,37 @@ func readPipe(reader io.Reader) {
 	}
 }
 
-func getMemLimit() int {
-	memLimit, err := ioutil.ReadFile("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+// getMemLimit returns the amount of memory cn-core can use, in bytes
+// This is the cgroup limit, or the total memory of the system if the cgroup is unbounded
+func getMemLimit() (uint64, error) {
+	mem, err := detectCgroupMemory()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
-
-	// we need to trim the newline since the string representation of the Readfile gives
-	// "209715200\n" and strconv.Atoi is not happy
-	memLimitInt, err := strconv.Atoi(strings.TrimSpace((string(memLimit))))
-	if err != nil {
-		log.Fatal(err)
+	if mem.Limited {
+		return mem.Limit, nil
 	}
 
-	return memLimitInt
+	return readMemInfo("MemTotal")
 }
 
-func getAvailableRAM() uint64 {
-	memLimitInt := getMemLimit()
-
-	memInfo, err := ioutil.ReadFile("/proc/meminfo")
+// getAvailableRAM returns the amount of memory still available to cn-core, in bytes
+func getAvailableRAM() (uint64, error) {
+	mem, err := detectCgroupMemory()
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	// 8 ExaBytes is the value of an unbounded device
-	if memLimitInt == 9223372036854771712 {
+	if !mem.Limited {
 		// Looks like the container doesn't have any memory limit
 		// Let's report the MemAvailable on this system
-		pattern := regexp.MustCompile("MemAvailable: *[0-9]{1,}")
-		s := pattern.FindString(string(memInfo))
-		m := strings.TrimSpace(strings.Split(s, ":")[1])
-
-		mem, err := strconv.Atoi(m)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		return (uint64(mem) * uint64(1024))
+		return readMemInfo("MemAvailable")
 	}
 
-	currentUsage, err := ioutil.ReadFile("/sys/fs/cgroup/memory/memory.usage_in_bytes")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	currentUsageInt, err := strconv.Atoi(strings.TrimSpace((string(currentUsage))))
-	if err != nil {
-		log.Fatal(err)
+	if mem.Usage > mem.Limit {
+		return 0, nil
 	}
-
-	a := memLimitInt - currentUsageInt
-
-	return uint64(a)
+	return mem.Limit - mem.Usage, nil
 }
 
 func bToMb(b uint64) uint64 {
@@ -336,6 +236,11 @@ func mbTob(b uint64) uint64 {
 	return b * 1024 * 1024
 }
 
+// osdMemoryShare returns the memory each OSD is tuned for, they share the memory left once the mon and mgr run
+func osdMemoryShare(memAvailable uint64, osdCount int) uint64 {
+	return memAvailable / uint64(osdCount)
+}
+
 func tuneMemory(memAvailable uint64) (osdMemoryTarget uint64, osdMemoryBase uint64, osdMemoryCacheMin uint64) {
 	_50mB := mbTob(50)
 	_128mB := mbTob(128)
@@ -364,10 +269,10 @@ func tuneMemory(memAvailable uint64) (osdMemoryTarget uint64, osdMemoryBase uint
 	return osdMemoryTarget, osdMemoryBase, osdMemoryCacheMin
 }
 
-func validateAvaibleMemory(cnMemMin uint64, memLimit int) error {
+func validateAvaibleMemory(cnMemMin uint64, memLimit uint64) error {
 	cnMemMinB := mbTob(cnMemMin)
 
-	if uint64(memLimit) < cnMemMinB {
+	if memLimit < cnMemMinB {
 		return errors.New("init: run me with at least 512mb of ram")
 	}
 
@@ -375,6 +280,11 @@ func validateAvaibleMemory(cnMemMin uint64, memLimit int) error {
 }
 
 func toBytes(value string) int64 {
+	// a plain number is already a size in bytes
+	if b, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return b
+	}
+
 	var bytes units.Base2Bytes
 	var err error
 	bytes, err = units.ParseBase2Bytes(value)
@@ -23,7 +23,9 @@
 package cmd
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gofrs/uuid"
@@ -40,22 +42,8 @@ func TestGenerateUUID(t *testing.T) {
 	assert.Equal(t, 36, len(uuid.String()), "Wrong UUID length!")
 }
 
-func TestGenerateCephConf(t *testing.T) {
-	fsid := "7ff73783-cec6-4ace-b655-a6bc4f2532a8"
-	expectedCephConf := `
-[global]
-fsid = 7ff73783-cec6-4ace-b655-a6bc4f2532a8
-mon host = [v2:127.0.0.1:3300,v1:127.0.0.1:6789]
-public network = 0.0.0.0/0
-cluster network = 0.0.0.0/0
-log file = /dev/null
-
-`
-	assert.Equal(t, expectedCephConf, fmt.Sprintf(cephConfTemplate, fsid), "Ceph configuration file generation error!")
-}
-
 func TestValidateAvaibleMemory(t *testing.T) {
-	memLimit := 511
+	memLimit := uint64(511)
 	err := validateAvaibleMemory(cnMemMin, memLimit)
 	assert.NotNil(t, err)
 }
@@ -74,3 +62,127 @@ func TestTuneMemory(t *testing.T) {
 	assert.Equal(t, expectedOsdMemoryCacheMin, osdMemoryCacheMin)
 
 }
+
+// fakeSysfs points cgroupRoot and procRoot to a temporary directory holding files
+func fakeSysfs(t *testing.T, files map[string]string) func() {
+	root, err := ioutil.TempDir("", "cn-core-sysfs")
+	assert.Nil(t, err)
+	for path, content := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, path), []byte(content), 0644))
+	}
+
+	cgroupRoot = filepath.Join(root, "sys/fs/cgroup")
+	procRoot = filepath.Join(root, "proc")
+	return func() {
+		cgroupRoot = "/sys/fs/cgroup"
+		procRoot = "/proc"
+		os.RemoveAll(root)
+	}
+}
+
+const testMemInfo = `MemTotal:        8048616 kB
+MemFree:          400200 kB
+MemAvailable:    3887372 kB
+`
+
+func TestMemoryCgroupV1(t *testing.T) {
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "1073741824\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "209715200\n",
+		"proc/meminfo": testMemInfo,
+	})()
+
+	mem, err := detectCgroupMemory()
+	assert.Nil(t, err)
+	assert.Equal(t, cgroupMemory{Version: 1, Limited: true, Limit: 1073741824, Usage: 209715200}, mem)
+
+	memLimit, err := getMemLimit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1073741824), memLimit)
+
+	memAvailable, err := getAvailableRAM()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1073741824-209715200), memAvailable)
+}
+
+func TestMemoryCgroupV1Unlimited(t *testing.T) {
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"sys/fs/cgroup/memory/memory.usage_in_bytes": "209715200\n",
+		"proc/meminfo": testMemInfo,
+	})()
+
+	memLimit, err := getMemLimit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(8048616*1024), memLimit)
+
+	memAvailable, err := getAvailableRAM()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3887372*1024), memAvailable)
+}
+
+func TestMemoryCgroupV2(t *testing.T) {
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/cgroup.controllers": "cpuset cpu io memory pids\n",
+		"sys/fs/cgroup/memory.max":         "2147483648\n",
+		"sys/fs/cgroup/memory.current":     "536870912\n",
+		"proc/self/cgroup":                 "0::/\n",
+		"proc/meminfo":                     testMemInfo,
+	})()
+
+	mem, err := detectCgroupMemory()
+	assert.Nil(t, err)
+	assert.Equal(t, cgroupMemory{Version: 2, Limited: true, Limit: 2147483648, Usage: 536870912}, mem)
+
+	memAvailable, err := getAvailableRAM()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2147483648-536870912), memAvailable)
+}
+
+func TestMemoryCgroupV2Nested(t *testing.T) {
+	// without a cgroup namespace, our cgroup is found through /proc/self/cgroup
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/cgroup.controllers":                            "cpuset cpu io memory pids\n",
+		"sys/fs/cgroup/system.slice/docker-37c2.scope/memory.max":     "1073741824\n",
+		"sys/fs/cgroup/system.slice/docker-37c2.scope/memory.current": "104857600\n",
+		"proc/self/cgroup": "0::/system.slice/docker-37c2.scope\n",
+		"proc/meminfo":     testMemInfo,
+	})()
+
+	memLimit, err := getMemLimit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1073741824), memLimit)
+}
+
+func TestMemoryCgroupV2Max(t *testing.T) {
+	defer fakeSysfs(t, map[string]string{
+		"sys/fs/cgroup/cgroup.controllers": "cpuset cpu io memory pids\n",
+		"sys/fs/cgroup/memory.max":         "max\n",
+		"sys/fs/cgroup/memory.current":     "536870912\n",
+		"proc/self/cgroup":                 "0::/\n",
+		"proc/meminfo":                     testMemInfo,
+	})()
+
+	mem, err := detectCgroupMemory()
+	assert.Nil(t, err)
+	assert.False(t, mem.Limited)
+
+	memAvailable, err := getAvailableRAM()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3887372*1024), memAvailable)
+}
+
+func TestMemoryNoCgroup(t *testing.T) {
+	defer fakeSysfs(t, map[string]string{
+		"proc/meminfo": testMemInfo,
+	})()
+
+	mem, err := detectCgroupMemory()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, mem.Version)
+
+	memLimit, err := getMemLimit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(8048616*1024), memLimit)
+}
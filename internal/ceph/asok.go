@@ -0,0 +1,156 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package ceph
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// AdminSocket talks to a daemon through its admin socket, e.g: /var/run/ceph/ceph-osd.0.asok
+// It does not go through the monitors, it answers as long as the daemon runs
+type AdminSocket struct {
+	Path    string
+	Timeout time.Duration
+}
+
+// PerfCounters are the perf counters of a daemon by subsystem, a counter is a number or an average, e.g: {"avgcount": 2, "sum": 0.5}
+type PerfCounters map[string]map[string]json.RawMessage
+
+func NewAdminSocket(path string, timeout time.Duration) *AdminSocket {
+	return &AdminSocket{Path: path, Timeout: timeout}
+}
+
+// Command sends a command, e.g: "config get" with {"var": "osd_memory_target"}, and returns the answer of the daemon
+// The request is a JSON object terminated by a NUL byte, the answer is prefixed by its length on 4 bytes, big endian
+// Failures are *Error, a missing socket is ErrNotFound, a dead daemon is ErrConnectionRefused
+func (s *AdminSocket) Command(prefix string, args map[string]interface{}) ([]byte, error) {
+	request := map[string]interface{}{}
+	for name, value := range args {
+		request[name] = value
+	}
+	request["prefix"] = prefix
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.send(append(body, 0))
+	if err != nil {
+		return nil, s.fail(prefix, err)
+	}
+	return out, nil
+}
+
+// CommandJSON sends a command with format json and decodes the answer in v
+func (s *AdminSocket) CommandJSON(v interface{}, prefix string, args map[string]interface{}) error {
+	withFormat := map[string]interface{}{"format": "json"}
+	for name, value := range args {
+		withFormat[name] = value
+	}
+	out, err := s.Command(prefix, withFormat)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return &Error{Args: s.args(prefix), Err: fmt.Errorf("failed to parse the answer: %v", err)}
+	}
+	return nil
+}
+
+// Version returns the version of the daemon, e.g: 14.2.8
+func (s *AdminSocket) Version() (string, error) {
+	var version struct {
+		Version string `json:"version"`
+	}
+	err := s.CommandJSON(&version, "version", nil)
+	return version.Version, err
+}
+
+// PerfDump returns the perf counters of the daemon
+func (s *AdminSocket) PerfDump() (PerfCounters, error) {
+	var counters PerfCounters
+	err := s.CommandJSON(&counters, "perf dump", nil)
+	return counters, err
+}
+
+// send writes a request and reads the answer, the whole exchange is bounded by the timeout
+func (s *AdminSocket) send(request []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", s.Path, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		if err == io.EOF {
+			// the daemon hangs up on the commands it does not know
+			return nil, fmt.Errorf("the daemon closed the connection without answering, is the command valid? try help")
+		}
+		return nil, err
+	}
+	answer := make([]byte, length)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// args describes a command in the errors, the socket then the words of the command
+func (s *AdminSocket) args(prefix string) []string {
+	return append([]string{s.Path}, strings.Fields(prefix)...)
+}
+
+// fail classifies the failure of a command
+func (s *AdminSocket) fail(prefix string, err error) *Error {
+	e := &Error{Args: s.args(prefix), Err: err}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		e.Kind = ErrTimeout
+		return e
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if syscallErr, ok := opErr.Err.(*os.SyscallError); ok {
+			switch syscallErr.Err {
+			case syscall.ENOENT:
+				e.Kind = ErrNotFound
+			case syscall.ECONNREFUSED:
+				e.Kind = ErrConnectionRefused
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,120 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package ceph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveAdminSocket serves an admin socket answering with handle, a nil answer hangs up and a hang never answers
+func serveAdminSocket(t *testing.T, path string, handle func(request map[string]interface{}) []byte) func() {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	assert.Nil(t, err)
+	// like a daemon that crashed, the socket is left behind
+	listener.SetUnlinkOnClose(false)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadBytes(0)
+				if err != nil {
+					return
+				}
+				var request map[string]interface{}
+				if err := json.Unmarshal(line[:len(line)-1], &request); err != nil {
+					return
+				}
+				if request["prefix"] == "hang" {
+					time.Sleep(time.Second)
+					return
+				}
+				answer := handle(request)
+				if answer == nil {
+					return
+				}
+				binary.Write(conn, binary.BigEndian, uint32(len(answer)))
+				conn.Write(answer)
+			}()
+		}
+	}()
+	return func() { listener.Close() }
+}
+
+func TestAdminSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asok")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ceph-osd.0.asok")
+
+	var requests []map[string]interface{}
+	closeSocket := serveAdminSocket(t, path, func(request map[string]interface{}) []byte {
+		requests = append(requests, request)
+		switch request["prefix"] {
+		case "version":
+			return []byte(`{"version":"14.2.8","release":"nautilus","release_type":"stable"}`)
+		case "perf dump":
+			return []byte(`{"osd":{"op":12,"op_latency":{"avgcount":12,"sum":0.25}}}`)
+		case "config get":
+			return []byte(`{"osd_memory_target":"` + request["var"].(string) + `"}`)
+		}
+		return nil
+	})
+	s := NewAdminSocket(path, 100*time.Millisecond)
+
+	version, err := s.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, "14.2.8", version)
+	counters, err := s.PerfDump()
+	assert.Nil(t, err)
+	assert.Equal(t, json.RawMessage("12"), counters["osd"]["op"])
+	out, err := s.Command("config get", map[string]interface{}{"var": "osd_memory_target"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"osd_memory_target":"osd_memory_target"}`, string(out))
+	assert.Equal(t, map[string]interface{}{"prefix": "version", "format": "json"}, requests[0])
+
+	_, err = s.Command("dump everything", nil)
+	assert.EqualError(t, err, path+" dump everything: the daemon closed the connection without answering, is the command valid? try help")
+	_, err = s.Command("hang", nil)
+	assert.Equal(t, ErrTimeout, KindOf(err))
+
+	closeSocket()
+	_, err = s.Version()
+	assert.Equal(t, ErrConnectionRefused, KindOf(err))
+	_, err = NewAdminSocket(filepath.Join(dir, "ceph-osd.1.asok"), time.Second).Version()
+	assert.True(t, IsNotFound(err), "%v", err)
+}
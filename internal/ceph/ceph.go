@@ -0,0 +1,268 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+// Package ceph runs the ceph command line tool and decodes its answers
+// The commands are executed by the function given to New, so callers decide how they run, e.g: recorded or replayed
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunFunc runs a command until it completes and returns its combined output
+type RunFunc func(cmd *exec.Cmd) ([]byte, error)
+
+// Client runs ceph commands, its zero value is not usable, see New
+type Client struct {
+	run     RunFunc
+	name    string
+	keyring string
+	timeout time.Duration
+	release int // major version of ceph, 0 when unknown
+}
+
+func New(run RunFunc) *Client {
+	return &Client{run: run}
+}
+
+// As returns a client authenticating as another entity with the given keyring, e.g: mon. before client.admin exists
+func (c *Client) As(name, keyring string) *Client {
+	clone := *c
+	clone.name, clone.keyring = name, keyring
+	return &clone
+}
+
+// WithTimeout returns a client giving up after timeout when the monitors cannot be reached
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	clone := *c
+	clone.timeout = timeout
+	return &clone
+}
+
+// WithRelease returns a client for a major version of ceph, e.g: 14 for Nautilus, see ParseRelease
+// Some commands take their arguments differently from one release to another
+func (c *Client) WithRelease(release int) *Client {
+	clone := *c
+	clone.release = release
+	return &clone
+}
+
+// ParseRelease returns the major version of ceph from the output of ceph --version
+func ParseRelease(version string) (int, error) {
+	// ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)
+	fields := strings.Fields(version)
+	if len(fields) < 3 || fields[0] != "ceph" || fields[1] != "version" {
+		return 0, fmt.Errorf("unexpected ceph version %q", version)
+	}
+	release, err := strconv.Atoi(strings.SplitN(fields[2], ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ceph version %q", version)
+	}
+	return release, nil
+}
+
+// Command returns the command running ceph with args and the options of the client
+func (c *Client) Command(args ...string) *exec.Cmd {
+	argv := []string{}
+	if c.name != "" {
+		argv = append(argv, "-n", c.name)
+	}
+	if c.keyring != "" {
+		argv = append(argv, "-k", c.keyring)
+	}
+	argv = append(argv, args...)
+	if c.timeout > 0 {
+		argv = append(argv, "--connect-timeout", strconv.Itoa(int(c.timeout/time.Second)))
+	}
+	return exec.Command("ceph", argv...)
+}
+
+// Run runs a ceph command and returns its output, failures are *Error
+func (c *Client) Run(args ...string) ([]byte, error) {
+	return c.exec(c.Command(args...))
+}
+
+// RunSecret runs a ceph command reading a secret, e.g: a password, from its standard input with -i -
+// The secret is never on the command line, which ends up in the logs, the plans and the transcripts, nor in the errors
+func (c *Client) RunSecret(secret string, args ...string) ([]byte, error) {
+	cmd := c.Command(append(args, "-i", "-")...)
+	cmd.Stdin = strings.NewReader(secret)
+	return c.exec(cmd, secret)
+}
+
+// exec runs a command, the secrets are redacted from its failure
+func (c *Client) exec(cmd *exec.Cmd, secrets ...string) ([]byte, error) {
+	out, err := c.run(cmd)
+	if err != nil {
+		return out, newError(cmd.Args, out, err, secrets...)
+	}
+	return out, nil
+}
+
+// RunJSON runs a ceph command with --format json and decodes its output in v
+func (c *Client) RunJSON(v interface{}, args ...string) error {
+	args = append(args, "--format", "json")
+	out, err := c.Run(args...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return &Error{Args: c.Command(args...).Args, Err: fmt.Errorf("failed to parse the output: %v", err)}
+	}
+	return nil
+}
+
+// ErrorKind classifies the failures of the commands
+type ErrorKind int
+
+const (
+	// ErrOther is any failure not listed below
+	ErrOther ErrorKind = iota
+	// ErrNotFound is ENOENT, e.g: an unknown entity or user
+	ErrNotFound
+	// ErrExists is EEXIST, e.g: an entity created with other caps
+	ErrExists
+	// ErrTimeout is returned when the monitors did not answer in time
+	ErrTimeout
+	// ErrConnectionRefused is returned when no monitor listens on the address
+	ErrConnectionRefused
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrNotFound:
+		return "not found"
+	case ErrExists:
+		return "already exists"
+	case ErrTimeout:
+		return "timeout"
+	case ErrConnectionRefused:
+		return "connection refused"
+	}
+	return "error"
+}
+
+// errnos are the exit codes of ceph and the markers of its messages for each kind
+var errnos = []struct {
+	kind    ErrorKind
+	code    int
+	markers []string
+}{
+	{ErrNotFound, int(syscall.ENOENT), []string{"Error ENOENT", "[errno 2]"}},
+	{ErrExists, int(syscall.EEXIST), []string{"Error EEXIST", "[errno 17]"}},
+	{ErrTimeout, int(syscall.ETIMEDOUT), []string{"Error ETIMEDOUT", "[errno 110]", "timed out"}},
+	{ErrConnectionRefused, int(syscall.ECONNREFUSED), []string{"Error ECONNREFUSED", "[errno 111]", "Connection refused"}},
+}
+
+// Error is a failed ceph command
+type Error struct {
+	Args   []string
+	Output string
+	Kind   ErrorKind
+	Err    error
+}
+
+// newError classifies a failure, the secrets are redacted from the command and its output
+func newError(args []string, out []byte, err error, secrets ...string) *Error {
+	e := &Error{Args: redact(args, secrets), Output: strings.TrimSpace(redact([]string{string(out)}, secrets)[0]), Err: err}
+	code := exitCode(err)
+	for _, errno := range errnos {
+		if code == errno.code {
+			e.Kind = errno.kind
+			return e
+		}
+		for _, marker := range errno.markers {
+			if strings.Contains(e.Output, marker) {
+				e.Kind = errno.kind
+				return e
+			}
+		}
+	}
+	return e
+}
+
+// redact replaces the secrets found in values
+func redact(values, secrets []string) []string {
+	redacted := make([]string, len(values))
+	for i, value := range values {
+		for _, secret := range secrets {
+			if secret != "" {
+				value = strings.Replace(value, secret, "<redacted>", -1)
+			}
+		}
+		redacted[i] = value
+	}
+	return redacted
+}
+
+// Reason is what ceph printed, or how it failed when it printed nothing
+func (e *Error) Reason() string {
+	if e.Output != "" {
+		return e.Output
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Args, " ") + ": " + e.Reason()
+}
+
+// KindOf returns the kind of a failure, ErrOther when it does not come from a ceph command
+func KindOf(err error) ErrorKind {
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return ErrOther
+}
+
+func IsNotFound(err error) bool {
+	return KindOf(err) == ErrNotFound
+}
+
+func IsExists(err error) bool {
+	return KindOf(err) == ErrExists
+}
+
+// IsUnreachable tells if the monitors could not be reached
+func IsUnreachable(err error) bool {
+	kind := KindOf(err)
+	return kind == ErrTimeout || kind == ErrConnectionRefused
+}
+
+// exitCode returns the exit code of a command from the error it returned, -1 when it did not exit
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case *exec.ExitError:
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	case interface{ ExitCode() int }:
+		return e.ExitCode()
+	}
+	return -1
+}
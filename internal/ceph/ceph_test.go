@@ -0,0 +1,206 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package ceph
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exitError fails like a command exiting with a code
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitError) ExitCode() int {
+	return int(e)
+}
+
+// stub answers the commands by their arguments and remembers them
+type stub struct {
+	answers map[string]string
+	codes   map[string]int
+	args    []string
+}
+
+func (s *stub) run(cmd *exec.Cmd) ([]byte, error) {
+	args := strings.Join(cmd.Args, " ")
+	s.args = append(s.args, args)
+	if code, ok := s.codes[args]; ok {
+		return []byte(s.answers[args]), exitError(code)
+	}
+	out, ok := s.answers[args]
+	if !ok {
+		return nil, errors.New("unexpected command " + args)
+	}
+	return []byte(out), nil
+}
+
+func TestClientCommand(t *testing.T) {
+	s := &stub{answers: map[string]string{
+		"ceph -n mon. -k /tmp/mon.keyring auth get-or-create client.admin -o /tmp/admin.keyring": "",
+		"ceph osd new 0b5d4a56-e5b2-4c6a-8a8d-7e1c3d0c5a11":                                      "3\n",
+		"ceph --version": "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)\n",
+	}}
+	c := New(s.run)
+
+	assert.Nil(t, c.As("mon.", "/tmp/mon.keyring").AuthGetOrCreate("client.admin", "/tmp/admin.keyring"))
+	id, err := c.OsdNew("0b5d4a56-e5b2-4c6a-8a8d-7e1c3d0c5a11")
+	assert.Nil(t, err)
+	assert.Equal(t, "3", id)
+	version, err := c.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, "ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)", version)
+
+	// the options of a client do not leak to the one it derives from
+	assert.Equal(t, []string{"ceph", "status", "--connect-timeout", "5"}, c.WithTimeout(5*time.Second).Command("status").Args)
+	assert.Equal(t, []string{"ceph", "status"}, c.Command("status").Args)
+}
+
+func TestClientJSON(t *testing.T) {
+	s := &stub{answers: map[string]string{
+		"ceph osd stat --format json --connect-timeout 5": `{"osdmap": {"num_osds": 2, "num_up_osds": 1, "num_in_osds": 2}}`,
+		"ceph status --format json --connect-timeout 5":   `{"health": {"status": "HEALTH_OK"}, "osdmap": {"num_osds": 1, "num_up_osds": 1, "num_in_osds": 1}}`,
+		"ceph osd tree --format json --connect-timeout 5": `{"nodes": [{"id": -1, "name": "default", "type": "root", "children": [-2]},
+			{"id": -2, "name": "vm", "type": "host", "children": [0]},
+			{"id": 0, "name": "osd.0", "type": "osd", "status": "up", "crush_weight": 0.0098, "reweight": 1}], "stray": []}`,
+		"ceph mgr dump --format json --connect-timeout 5": `not json`,
+	}}
+	c := New(s.run).WithTimeout(5 * time.Second)
+
+	// Nautilus nests the osdmap, later releases do not
+	osdMap, err := c.OsdStat()
+	assert.Nil(t, err)
+	assert.Equal(t, OsdMap{NumOsds: 2, NumUpOsds: 1, NumInOsds: 2}, osdMap)
+	status, err := c.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, "HEALTH_OK", status.Health.Status)
+	assert.Equal(t, OsdMap{NumOsds: 1, NumUpOsds: 1, NumInOsds: 1}, status.OsdMap)
+
+	tree, err := c.OsdTree()
+	assert.Nil(t, err)
+	if assert.Len(t, tree.Nodes, 3) {
+		assert.Equal(t, OsdTreeNode{ID: 0, Name: "osd.0", Type: "osd", Status: "up", CrushWeight: 0.0098, Reweight: 1}, tree.Nodes[2])
+	}
+
+	_, err = c.MgrDump()
+	assert.EqualError(t, err, "ceph mgr dump --format json --connect-timeout 5: failed to parse the output: invalid character 'o' in literal null (expecting 'u')")
+	assert.Equal(t, ErrOther, KindOf(err))
+}
+
+func TestClientErrors(t *testing.T) {
+	s := &stub{
+		answers: map[string]string{
+			"ceph dashboard ac-user-show admin --format json":                       "Error ENOENT: User 'admin' does not exist\n",
+			"ceph auth get-or-create client.rgw.vm mon allow r -o /tmp/rgw.keyring": "Error EINVAL: key for client.rgw.vm exists but cap mon does not match\n",
+			"ceph auth export client.bootstrap-osd -o /tmp/keyring":                 "",
+			"ceph config set mgr mgr/dashboard/ssl false --connect-timeout 1":       "[errno 110] RADOS timed out (error connecting to the cluster)\n",
+			"ceph mgr module enable dashboard --force --connect-timeout 1":          "[errno 111] Connection refused\n",
+		},
+		codes: map[string]int{
+			"ceph dashboard ac-user-show admin --format json":                       2,
+			"ceph auth get-or-create client.rgw.vm mon allow r -o /tmp/rgw.keyring": 22,
+			"ceph auth export client.bootstrap-osd -o /tmp/keyring":                 17,
+			"ceph config set mgr mgr/dashboard/ssl false --connect-timeout 1":       1,
+			"ceph mgr module enable dashboard --force --connect-timeout 1":          1,
+		},
+	}
+	c := New(s.run)
+
+	_, err := c.DashboardUser("admin")
+	assert.True(t, IsNotFound(err))
+	assert.EqualError(t, err, "ceph dashboard ac-user-show admin --format json: Error ENOENT: User 'admin' does not exist")
+
+	err = c.AuthGetOrCreate("client.rgw.vm", "/tmp/rgw.keyring", "mon", "allow r")
+	assert.Equal(t, ErrOther, KindOf(err))
+
+	// nothing was printed, the exit code tells
+	err = c.AuthExport("client.bootstrap-osd", "/tmp/keyring")
+	assert.True(t, IsExists(err))
+	assert.Equal(t, "exit status 17", err.(*Error).Reason())
+
+	err = c.WithTimeout(time.Second).ConfigSet("mgr", "mgr/dashboard/ssl", "false")
+	assert.Equal(t, ErrTimeout, KindOf(err))
+	assert.True(t, IsUnreachable(err))
+	err = c.WithTimeout(time.Second).MgrModuleEnable("dashboard", true)
+	assert.Equal(t, ErrConnectionRefused, KindOf(err))
+	assert.Equal(t, "connection refused", KindOf(err).String())
+
+	assert.Equal(t, ErrOther, KindOf(errors.New("exit status 1")))
+}
+
+func TestClientSecret(t *testing.T) {
+	var stdin string
+	run := func(cmd *exec.Cmd) ([]byte, error) {
+		buf := new(strings.Builder)
+		io.Copy(buf, cmd.Stdin)
+		stdin = buf.String()
+		return []byte("Error EINVAL: bad password s3cr3t\n"), exitError(22)
+	}
+	c := New(run)
+
+	err := c.DashboardCreateUser("admin", "s3cr3t", "administrator")
+	assert.Equal(t, "s3cr3t", stdin)
+	assert.EqualError(t, err, "ceph dashboard ac-user-create admin administrator -i -: Error EINVAL: bad password <redacted>")
+	err = c.DashboardSetSecret("rgw-api-secret-key", "s3cr3t")
+	assert.Equal(t, []string{"ceph", "dashboard", "set-rgw-api-secret-key", "-i", "-"}, err.(*Error).Args)
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func TestClientSecretNautilus(t *testing.T) {
+	s := &stub{answers: map[string]string{
+		"ceph dashboard ac-user-create admin s3cr3t administrator": "",
+		"ceph dashboard ac-user-set-password admin s3cr3t":         "",
+		"ceph dashboard set-rgw-api-secret-key s3cr3t":             "Error EINVAL: invalid key\n",
+	}, codes: map[string]int{
+		"ceph dashboard set-rgw-api-secret-key s3cr3t": 22,
+	}}
+	c := New(s.run).WithRelease(14)
+
+	// the secret is the argument after the user, before the roles
+	assert.Nil(t, c.DashboardCreateUser("admin", "s3cr3t", "administrator"))
+	assert.Nil(t, c.DashboardSetPassword("admin", "s3cr3t"))
+	err := c.DashboardSetSecret("rgw-api-secret-key", "s3cr3t")
+	assert.EqualError(t, err, "ceph dashboard set-rgw-api-secret-key <redacted>: Error EINVAL: invalid key")
+}
+
+func TestParseRelease(t *testing.T) {
+	release, err := ParseRelease("ceph version 14.2.8 (2d095e947a02261ce61424021bb43bd3022d35cb) nautilus (stable)")
+	assert.Nil(t, err)
+	assert.Equal(t, 14, release)
+	release, err = ParseRelease("ceph version 16.2.7 (dd0603118f56ab514f133c8d2e3adfc983942503) pacific (stable)")
+	assert.Nil(t, err)
+	assert.Equal(t, 16, release)
+
+	_, err = ParseRelease("")
+	assert.EqualError(t, err, `unexpected ceph version ""`)
+}
@@ -0,0 +1,266 @@
+/*
+ * Ceph Nano Core (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package ceph
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Status is the subset of 'ceph status' we use
+// Nautilus lists the mons, later releases only count them
+type Status struct {
+	Health struct {
+		Status string                 `json:"status"`
+		Checks map[string]HealthCheck `json:"checks"`
+	} `json:"health"`
+	QuorumNames []string `json:"quorum_names"`
+	MonMap      struct {
+		Mons    []json.RawMessage `json:"mons"`
+		NumMons int               `json:"num_mons"`
+	} `json:"monmap"`
+	OsdMap OsdMap `json:"osdmap"`
+	PgMap  struct {
+		PgsByState []struct {
+			StateName string `json:"state_name"`
+			Count     int    `json:"count"`
+		} `json:"pgs_by_state"`
+		NumPgs     int    `json:"num_pgs"`
+		BytesUsed  uint64 `json:"bytes_used"`
+		BytesAvail uint64 `json:"bytes_avail"`
+		BytesTotal uint64 `json:"bytes_total"`
+	} `json:"pgmap"`
+}
+
+type HealthCheck struct {
+	Severity string `json:"severity"`
+	Summary  struct {
+		Message string `json:"message"`
+	} `json:"summary"`
+}
+
+// OsdMap counts the OSDs, it is the same whether ceph nests it in another osdmap (Nautilus) or not
+type OsdMap struct {
+	NumOsds   int `json:"num_osds"`
+	NumUpOsds int `json:"num_up_osds"`
+	NumInOsds int `json:"num_in_osds"`
+}
+
+func (m *OsdMap) UnmarshalJSON(data []byte) error {
+	type plain OsdMap
+	var raw struct {
+		plain
+		Nested *plain `json:"osdmap"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Nested != nil {
+		*m = OsdMap(*raw.Nested)
+	} else {
+		*m = OsdMap(raw.plain)
+	}
+	return nil
+}
+
+// QuorumStatus is the subset of 'ceph quorum_status' we use
+type QuorumStatus struct {
+	QuorumNames []string `json:"quorum_names"`
+}
+
+// MgrMap is the subset of 'ceph mgr dump' we use
+type MgrMap struct {
+	Available  bool   `json:"available"`
+	ActiveName string `json:"active_name"`
+}
+
+// OsdTree is the CRUSH hierarchy, hosts and OSDs are both nodes
+type OsdTree struct {
+	Nodes []OsdTreeNode `json:"nodes"`
+	Stray []OsdTreeNode `json:"stray"`
+}
+
+type OsdTreeNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Children    []int   `json:"children,omitempty"`
+	Status      string  `json:"status,omitempty"`
+	CrushWeight float64 `json:"crush_weight"`
+	Reweight    float64 `json:"reweight"`
+}
+
+// OsdDump is the subset of 'ceph osd dump' we use
+type OsdDump struct {
+	Osds []struct {
+		Osd  int    `json:"osd"`
+		UUID string `json:"uuid"`
+	} `json:"osds"`
+}
+
+// DashboardUser is an account of the mgr dashboard
+type DashboardUser struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
+}
+
+// Version returns the version of the ceph command line tool, e.g: ceph version 14.2.8 (...) nautilus (stable)
+func (c *Client) Version() (string, error) {
+	out, err := c.Run("--version")
+	return strings.TrimSpace(string(out)), err
+}
+
+// Status returns the status of the cluster
+func (c *Client) Status() (Status, error) {
+	var status Status
+	err := c.RunJSON(&status, "status")
+	return status, err
+}
+
+// QuorumStatus returns the monitors in quorum
+func (c *Client) QuorumStatus() (QuorumStatus, error) {
+	var quorum QuorumStatus
+	err := c.RunJSON(&quorum, "quorum_status")
+	return quorum, err
+}
+
+// MgrDump returns the state of the managers
+func (c *Client) MgrDump() (MgrMap, error) {
+	var mgrMap MgrMap
+	err := c.RunJSON(&mgrMap, "mgr", "dump")
+	return mgrMap, err
+}
+
+// OsdStat returns the number of OSDs, up and in
+func (c *Client) OsdStat() (OsdMap, error) {
+	var osdMap OsdMap
+	err := c.RunJSON(&osdMap, "osd", "stat")
+	return osdMap, err
+}
+
+// OsdTree returns the CRUSH hierarchy with the state of the OSDs
+func (c *Client) OsdTree() (OsdTree, error) {
+	var tree OsdTree
+	err := c.RunJSON(&tree, "osd", "tree")
+	return tree, err
+}
+
+// OsdNew registers an OSD and returns the id the monitor allocated to it
+func (c *Client) OsdNew(osdUUID string) (string, error) {
+	out, err := c.Run("osd", "new", osdUUID)
+	return strings.TrimSpace(string(out)), err
+}
+
+// OsdDump returns the OSDs registered in the cluster
+func (c *Client) OsdDump() (OsdDump, error) {
+	var dump OsdDump
+	err := c.RunJSON(&dump, "osd", "dump")
+	return dump, err
+}
+
+// OsdPurge removes an OSD from the cluster, with its CRUSH entry and its cephx key
+func (c *Client) OsdPurge(osdID string) error {
+	_, err := c.Run("osd", "purge", osdID, "--yes-i-really-mean-it")
+	return err
+}
+
+// AuthGetOrCreate writes the keyring of an entity to path, the entity is created when it does not exist
+// caps are pairs of daemon type and capability, e.g: "mon", "allow *"
+// An entity existing with other caps is ErrExists
+func (c *Client) AuthGetOrCreate(entity, path string, caps ...string) error {
+	args := append([]string{"auth", "get-or-create", entity}, caps...)
+	_, err := c.Run(append(args, "-o", path)...)
+	return err
+}
+
+// AuthExport writes the keyring of an existing entity to path
+func (c *Client) AuthExport(entity, path string) error {
+	_, err := c.Run("auth", "export", entity, "-o", path)
+	return err
+}
+
+// ConfigSet sets an option in the configuration database of the monitors, who is e.g: mgr or osd.0
+func (c *Client) ConfigSet(who, option, value string) error {
+	_, err := c.Run("config", "set", who, option, value)
+	return err
+}
+
+// MgrModuleEnable enables a manager module, force skips the checks of its dependencies
+func (c *Client) MgrModuleEnable(module string, force bool) error {
+	args := []string{"mgr", "module", "enable", module}
+	if force {
+		args = append(args, "--force")
+	}
+	_, err := c.Run(args...)
+	return err
+}
+
+func (c *Client) MgrModuleDisable(module string) error {
+	_, err := c.Run("mgr", "module", "disable", module)
+	return err
+}
+
+// DashboardSet changes a setting of the dashboard, e.g: rgw-api-host
+func (c *Client) DashboardSet(setting, value string) error {
+	_, err := c.Run("dashboard", "set-"+setting, value)
+	return err
+}
+
+// DashboardUser returns an account of the dashboard, an unknown account is ErrNotFound
+func (c *Client) DashboardUser(username string) (DashboardUser, error) {
+	var user DashboardUser
+	err := c.RunJSON(&user, "dashboard", "ac-user-show", username)
+	return user, err
+}
+
+// dashboardSecretsRelease is the first release whose dashboard reads the passwords and keys with -i
+// The earlier ones take them as an argument, where -i - would be read as the secret or as a role
+const dashboardSecretsRelease = 16
+
+// dashboardSecret runs a dashboard command taking a secret, inserted in args at position before releases reading it with -i
+func (c *Client) dashboardSecret(secret string, at int, args ...string) error {
+	if c.release != 0 && c.release < dashboardSecretsRelease {
+		argv := append(append(append([]string{}, args[:at]...), secret), args[at:]...)
+		_, err := c.exec(c.Command(argv...), secret)
+		return err
+	}
+	_, err := c.RunSecret(secret, args...)
+	return err
+}
+
+// DashboardSetSecret changes a secret setting of the dashboard, e.g: rgw-api-secret-key
+// The secret is passed on the standard input when the release allows it, see WithRelease and RunSecret
+func (c *Client) DashboardSetSecret(setting, value string) error {
+	return c.dashboardSecret(value, 2, "dashboard", "set-"+setting)
+}
+
+// DashboardCreateUser creates an account of the dashboard, the password is passed like DashboardSetSecret does
+func (c *Client) DashboardCreateUser(username, password string, roles ...string) error {
+	return c.dashboardSecret(password, 3, append([]string{"dashboard", "ac-user-create", username}, roles...)...)
+}
+
+func (c *Client) DashboardSetPassword(username, password string) error {
+	return c.dashboardSecret(password, 3, "dashboard", "ac-user-set-password", username)
+}